@@ -0,0 +1,76 @@
+use crate::point::Point;
+
+/// Describes a change to the source text, used to update a tree before
+/// re-parsing it incrementally.
+#[magnus::wrap(class = "TreeSitter::InputEdit")]
+#[derive(Clone)]
+pub struct InputEdit {
+    start_byte: usize,
+    old_end_byte: usize,
+    new_end_byte: usize,
+    start_point: Point,
+    old_end_point: Point,
+    new_end_point: Point,
+}
+
+impl InputEdit {
+    pub fn new(
+        start_byte: usize,
+        old_end_byte: usize,
+        new_end_byte: usize,
+        start_point: &Point,
+        old_end_point: &Point,
+        new_end_point: &Point,
+    ) -> Self {
+        Self {
+            start_byte,
+            old_end_byte,
+            new_end_byte,
+            start_point: start_point.clone(),
+            old_end_point: old_end_point.clone(),
+            new_end_point: new_end_point.clone(),
+        }
+    }
+
+    pub fn to_ts(&self) -> tree_sitter::InputEdit {
+        tree_sitter::InputEdit {
+            start_byte: self.start_byte,
+            old_end_byte: self.old_end_byte,
+            new_end_byte: self.new_end_byte,
+            start_position: self.start_point.to_ts(),
+            old_end_position: self.old_end_point.to_ts(),
+            new_end_position: self.new_end_point.to_ts(),
+        }
+    }
+
+    pub fn start_byte(&self) -> usize {
+        self.start_byte
+    }
+
+    pub fn old_end_byte(&self) -> usize {
+        self.old_end_byte
+    }
+
+    pub fn new_end_byte(&self) -> usize {
+        self.new_end_byte
+    }
+
+    pub fn start_point(&self) -> Point {
+        self.start_point.clone()
+    }
+
+    pub fn old_end_point(&self) -> Point {
+        self.old_end_point.clone()
+    }
+
+    pub fn new_end_point(&self) -> Point {
+        self.new_end_point.clone()
+    }
+
+    pub fn inspect(&self) -> String {
+        format!(
+            "#<TreeSitter::InputEdit start_byte={} old_end_byte={} new_end_byte={}>",
+            self.start_byte, self.old_end_byte, self.new_end_byte
+        )
+    }
+}
@@ -13,14 +13,16 @@ struct LoadedLanguage {
     language: tree_sitter::Language,
     #[allow(dead_code)]
     library: Library, // Keep library alive!
+    library_path: String,
 }
 
 // Safety: tree_sitter::Language is thread-safe
 unsafe impl Send for LoadedLanguage {}
 unsafe impl Sync for LoadedLanguage {}
 
-/// Register a language from a shared library path
-pub fn register_language(name: String, library_path: String) -> Result<(), Error> {
+/// Load a language from a shared library path.
+/// Called by `TreeSitter.register_language` once the path passed the grammar policy.
+pub fn load_language_library(name: String, library_path: String) -> Result<(), Error> {
     let ruby = Ruby::get().unwrap();
 
     // Load the shared library
@@ -54,7 +56,14 @@ pub fn register_language(name: String, library_path: String) -> Result<(), Error
         )
     })?;
 
-    registry.insert(name, LoadedLanguage { language, library });
+    registry.insert(
+        name,
+        LoadedLanguage {
+            language,
+            library,
+            library_path,
+        },
+    );
 
     Ok(())
 }
@@ -149,4 +158,26 @@ impl Language {
     pub fn node_kind_count(&self) -> usize {
         self.inner.node_kind_count()
     }
+
+    /// Returns the path of the shared library the language was registered from.
+    pub fn library_path(&self) -> Result<String, Error> {
+        let ruby = Ruby::get().unwrap();
+
+        let registry = LANGUAGES.read().map_err(|_| {
+            Error::new(
+                ruby.exception_runtime_error(),
+                "Failed to acquire language registry lock",
+            )
+        })?;
+
+        registry
+            .get(&self.name)
+            .map(|loaded| loaded.library_path.clone())
+            .ok_or_else(|| {
+                Error::new(
+                    ruby.exception_arg_error(),
+                    format!("Language '{}' is no longer registered", self.name),
+                )
+            })
+    }
 }
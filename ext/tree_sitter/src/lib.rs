@@ -1,3 +1,4 @@
+mod input_edit;
 mod language;
 mod node;
 mod parser;
@@ -12,9 +13,10 @@ use magnus::{function, method, prelude::*, Error, Ruby};
 fn init(ruby: &Ruby) -> Result<(), Error> {
     let module = ruby.define_module("TreeSitter")?;
 
+    // Wrapped by TreeSitter.register_language, which applies the grammar policy
     module.define_singleton_method(
-        "register_language",
-        function!(language::register_language, 2),
+        "load_language_library",
+        function!(language::load_language_library, 2),
     )?;
     module.define_singleton_method("language", function!(language::get_language, 1))?;
     module.define_singleton_method("languages", function!(language::list_languages, 0))?;
@@ -26,6 +28,7 @@ fn init(ruby: &Ruby) -> Result<(), Error> {
         "node_kind_count",
         method!(language::Language::node_kind_count, 0),
     )?;
+    language_class.define_method("library_path", method!(language::Language::library_path, 0))?;
 
     let parser_class = module.define_class("Parser", ruby.class_object())?;
     parser_class.define_singleton_method("new", function!(parser::Parser::new, 0))?;
@@ -43,6 +46,34 @@ fn init(ruby: &Ruby) -> Result<(), Error> {
     tree_class.define_method("root_node", method!(tree::Tree::root_node, 0))?;
     tree_class.define_method("source", method!(tree::Tree::source, 0))?;
     tree_class.define_method("language", method!(tree::Tree::language, 0))?;
+    tree_class.define_method("copy", method!(tree::Tree::copy, 0))?;
+    tree_class.define_method("edit", method!(tree::Tree::edit, 1))?;
+    tree_class.define_method("changed_ranges", method!(tree::Tree::changed_ranges, 1))?;
+
+    let input_edit_class = module.define_class("InputEdit", ruby.class_object())?;
+    input_edit_class.define_singleton_method("new", function!(input_edit::InputEdit::new, 6))?;
+    input_edit_class.define_method("start_byte", method!(input_edit::InputEdit::start_byte, 0))?;
+    input_edit_class.define_method(
+        "old_end_byte",
+        method!(input_edit::InputEdit::old_end_byte, 0),
+    )?;
+    input_edit_class.define_method(
+        "new_end_byte",
+        method!(input_edit::InputEdit::new_end_byte, 0),
+    )?;
+    input_edit_class.define_method(
+        "start_point",
+        method!(input_edit::InputEdit::start_point, 0),
+    )?;
+    input_edit_class.define_method(
+        "old_end_point",
+        method!(input_edit::InputEdit::old_end_point, 0),
+    )?;
+    input_edit_class.define_method(
+        "new_end_point",
+        method!(input_edit::InputEdit::new_end_point, 0),
+    )?;
+    input_edit_class.define_method("inspect", method!(input_edit::InputEdit::inspect, 0))?;
 
     let node_class = module.define_class("Node", ruby.class_object())?;
 
@@ -59,6 +90,10 @@ fn init(ruby: &Ruby) -> Result<(), Error> {
         "child_by_field_name",
         method!(node::Node::child_by_field_name, 1),
     )?;
+    node_class.define_method(
+        "field_name_for_child",
+        method!(node::Node::field_name_for_child, 1),
+    )?;
     node_class.define_method("children", method!(node::Node::children, 0))?;
     node_class.define_method("named_children", method!(node::Node::named_children, 0))?;
     node_class.define_method("next_sibling", method!(node::Node::next_sibling, 0))?;
@@ -108,6 +143,7 @@ fn init(ruby: &Ruby) -> Result<(), Error> {
 
     // Range class
     let range_class = module.define_class("Range", ruby.class_object())?;
+    range_class.define_singleton_method("new", function!(range::Range::rb_new, 4))?;
     range_class.define_method("start_byte", method!(range::Range::start_byte, 0))?;
     range_class.define_method("end_byte", method!(range::Range::end_byte, 0))?;
     range_class.define_method("start_point", method!(range::Range::start_point, 0))?;
@@ -119,11 +155,34 @@ fn init(ruby: &Ruby) -> Result<(), Error> {
     query_class.define_singleton_method("new", function!(query::Query::new, 2))?;
     query_class.define_method("capture_names", method!(query::Query::capture_names, 0))?;
     query_class.define_method("pattern_count", method!(query::Query::pattern_count, 0))?;
+    query_class.define_method("source", method!(query::Query::source, 0))?;
+    query_class.define_method(
+        "start_byte_for_pattern",
+        method!(query::Query::start_byte_for_pattern, 1),
+    )?;
+    query_class.define_method("disable_pattern", method!(query::Query::disable_pattern, 1))?;
 
     let cursor_class = module.define_class("QueryCursor", ruby.class_object())?;
     cursor_class.define_singleton_method("new", function!(query::QueryCursor::new, 0))?;
+    cursor_class.define_method("match_limit", method!(query::QueryCursor::match_limit, 0))?;
+    cursor_class.define_method(
+        "match_limit=",
+        method!(query::QueryCursor::set_match_limit, 1),
+    )?;
+    cursor_class.define_method(
+        "set_byte_range",
+        method!(query::QueryCursor::set_byte_range, 2),
+    )?;
+    cursor_class.define_method(
+        "did_exceed_match_limit?",
+        method!(query::QueryCursor::did_exceed_match_limit, 0),
+    )?;
     cursor_class.define_method("matches", method!(query::QueryCursor::matches, 3))?;
     cursor_class.define_method("captures", method!(query::QueryCursor::captures, 3))?;
+    cursor_class.define_method(
+        "profile_matches",
+        method!(query::QueryCursor::profile_matches, 3),
+    )?;
 
     let match_class = module.define_class("QueryMatch", ruby.class_object())?;
     match_class.define_method(
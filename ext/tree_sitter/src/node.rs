@@ -61,16 +61,32 @@ impl Node {
         }
     }
 
-    /// Relocate the tree-sitter node from the stored tree
+    /// Relocate the tree-sitter node from the stored tree.
+    /// `descendant_for_byte_range` returns the deepest node spanning the range, so
+    /// when a parent and its only child share a range we walk back up until the
+    /// kind matches the node we wrapped.
     fn get_ts_node(&self) -> Option<tree_sitter::Node<'_>> {
         let root = self.tree.root_node();
-        root.descendant_for_byte_range(self.start_byte, self.end_byte)
+        let deepest = root.descendant_for_byte_range(self.start_byte, self.end_byte)?;
+
+        let mut current = deepest;
+        while current.kind_id() != self.kind_id {
+            match current.parent() {
+                Some(parent)
+                    if parent.start_byte() == self.start_byte
+                        && parent.end_byte() == self.end_byte =>
+                {
+                    current = parent
+                }
+                _ => return Some(deepest),
+            }
+        }
+        Some(current)
     }
 
     /// Public method for query.rs to access the tree-sitter node
     pub fn get_ts_node_pub(&self) -> Option<tree_sitter::Node<'_>> {
-        let root = self.tree.root_node();
-        root.descendant_for_byte_range(self.start_byte, self.end_byte)
+        self.get_ts_node()
     }
 
     // Navigation methods
@@ -137,6 +153,12 @@ impl Node {
         array
     }
 
+    /// Returns the field name of the child at `index`, if the grammar assigns one.
+    pub fn field_name_for_child(&self, index: usize) -> Option<&'static str> {
+        let ts_node = self.get_ts_node()?;
+        ts_node.field_name_for_child(index as u32)
+    }
+
     pub fn next_sibling(&self) -> Option<Node> {
         let ts_node = self.get_ts_node()?;
         ts_node
@@ -85,7 +85,7 @@ impl Parser {
         })?;
 
         let mut parser = self.inner.borrow_mut();
-        let old_ts_tree = old_tree.map(|t| (*t.inner).clone());
+        let old_ts_tree = old_tree.map(|t| (**t.inner.borrow()).clone());
 
         let timeout = *self.timeout_micros.borrow();
         let result = if timeout > 0 {
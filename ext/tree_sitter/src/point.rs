@@ -19,6 +19,13 @@ impl Point {
         }
     }
 
+    pub fn to_ts(&self) -> tree_sitter::Point {
+        tree_sitter::Point {
+            row: self.row,
+            column: self.column,
+        }
+    }
+
     pub fn row(&self) -> usize {
         self.row
     }
@@ -2,12 +2,14 @@ use crate::language::Language;
 use crate::node::Node;
 use magnus::{Error, RArray, Ruby};
 use std::cell::RefCell;
+use std::time::Instant;
 use streaming_iterator::StreamingIterator;
 
 #[magnus::wrap(class = "TreeSitter::Query")]
 pub struct Query {
-    inner: tree_sitter::Query,
+    inner: RefCell<tree_sitter::Query>,
     capture_names: Vec<String>,
+    source: String,
 }
 
 impl Query {
@@ -28,11 +30,16 @@ impl Query {
             .collect();
 
         Ok(Self {
-            inner: query,
+            inner: RefCell::new(query),
             capture_names,
+            source,
         })
     }
 
+    pub fn source(&self) -> String {
+        self.source.clone()
+    }
+
     pub fn capture_names(&self) -> RArray {
         let ruby = Ruby::get().unwrap();
         let array = ruby.ary_new();
@@ -43,7 +50,36 @@ impl Query {
     }
 
     pub fn pattern_count(&self) -> usize {
-        self.inner.pattern_count()
+        self.inner.borrow().pattern_count()
+    }
+
+    /// Byte offset in the query source where the given pattern begins
+    pub fn start_byte_for_pattern(&self, pattern_index: usize) -> Result<usize, Error> {
+        self.check_pattern_index(pattern_index)?;
+        Ok(self.inner.borrow().start_byte_for_pattern(pattern_index))
+    }
+
+    /// Stop matching the given pattern. This cannot be undone.
+    pub fn disable_pattern(&self, pattern_index: usize) -> Result<(), Error> {
+        self.check_pattern_index(pattern_index)?;
+        self.inner.borrow_mut().disable_pattern(pattern_index);
+        Ok(())
+    }
+
+    fn check_pattern_index(&self, pattern_index: usize) -> Result<(), Error> {
+        let pattern_count = self.inner.borrow().pattern_count();
+        if pattern_index >= pattern_count {
+            let ruby = Ruby::get().unwrap();
+            return Err(Error::new(
+                ruby.exception_index_error(),
+                format!(
+                    "pattern index {} out of range (pattern count: {})",
+                    pattern_index, pattern_count
+                ),
+            ));
+        }
+
+        Ok(())
     }
 }
 
@@ -59,6 +95,35 @@ impl QueryCursor {
         }
     }
 
+    pub fn match_limit(&self) -> u32 {
+        self.inner.borrow().match_limit()
+    }
+
+    pub fn set_match_limit(&self, limit: u32) {
+        self.inner.borrow_mut().set_match_limit(limit);
+    }
+
+    /// Limit `matches`/`captures` to nodes that intersect the byte range
+    /// `start_byte...end_byte`
+    pub fn set_byte_range(&self, start_byte: usize, end_byte: usize) -> Result<(), Error> {
+        if start_byte > end_byte {
+            let ruby = Ruby::get().unwrap();
+            return Err(Error::new(
+                ruby.exception_arg_error(),
+                format!("invalid byte range {}...{}", start_byte, end_byte),
+            ));
+        }
+
+        self.inner.borrow_mut().set_byte_range(start_byte..end_byte);
+        Ok(())
+    }
+
+    /// Whether the last `matches`/`captures` call dropped in-progress matches
+    /// because it ran into the match limit
+    pub fn did_exceed_match_limit(&self) -> bool {
+        self.inner.borrow().did_exceed_match_limit()
+    }
+
     pub fn matches(&self, query: &Query, node: &Node, source: String) -> RArray {
         let ruby = Ruby::get().unwrap();
         let array = ruby.ary_new();
@@ -66,8 +131,9 @@ impl QueryCursor {
             return array;
         };
 
+        let query_inner = query.inner.borrow();
         let mut cursor = self.inner.borrow_mut();
-        let mut matches = cursor.matches(&query.inner, ts_node, source.as_bytes());
+        let mut matches = cursor.matches(&query_inner, ts_node, source.as_bytes());
 
         while let Some(m) = matches.next() {
             let captures: Vec<QueryCapture> = m
@@ -98,8 +164,9 @@ impl QueryCursor {
             return array;
         };
 
+        let query_inner = query.inner.borrow();
         let mut cursor = self.inner.borrow_mut();
-        let mut captures = cursor.captures(&query.inner, ts_node, source.as_bytes());
+        let mut captures = cursor.captures(&query_inner, ts_node, source.as_bytes());
 
         while let Some((m, capture_index)) = captures.next() {
             if let Some(c) = m.captures.get(*capture_index) {
@@ -113,6 +180,34 @@ impl QueryCursor {
 
         array
     }
+
+    /// Run a query like `matches` without building Ruby objects for the
+    /// matches, for profiling. Returns `[seconds, counts]`: the time spent in
+    /// the cursor, and `[match_count, capture_count]` for each pattern index.
+    pub fn profile_matches(&self, query: &Query, node: &Node, source: String) -> (f64, RArray) {
+        let ruby = Ruby::get().unwrap();
+        let query_inner = query.inner.borrow();
+        let mut counts = vec![(0usize, 0usize); query_inner.pattern_count()];
+        let mut seconds = 0.0;
+
+        if let Some(ts_node) = node.get_ts_node_pub() {
+            let mut cursor = self.inner.borrow_mut();
+            let start = Instant::now();
+            let mut matches = cursor.matches(&query_inner, ts_node, source.as_bytes());
+            while let Some(m) = matches.next() {
+                let (match_count, capture_count) = &mut counts[m.pattern_index];
+                *match_count += 1;
+                *capture_count += m.captures.len();
+            }
+            seconds = start.elapsed().as_secs_f64();
+        }
+
+        let array = ruby.ary_new();
+        for pattern_counts in counts {
+            let _ = array.push(pattern_counts);
+        }
+        (seconds, array)
+    }
 }
 
 #[magnus::wrap(class = "TreeSitter::QueryMatch")]
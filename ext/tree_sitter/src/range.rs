@@ -19,6 +19,25 @@ impl Range {
         }
     }
 
+    /// Constructor exposed to Ruby as `TreeSitter::Range.new`
+    pub fn rb_new(
+        start_byte: usize,
+        end_byte: usize,
+        start_point: &Point,
+        end_point: &Point,
+    ) -> Self {
+        Self::new(start_byte, end_byte, start_point.clone(), end_point.clone())
+    }
+
+    pub fn from_ts(range: tree_sitter::Range) -> Self {
+        Self::new(
+            range.start_byte,
+            range.end_byte,
+            Point::from_ts(range.start_point),
+            Point::from_ts(range.end_point),
+        )
+    }
+
     pub fn start_byte(&self) -> usize {
         self.start_byte
     }
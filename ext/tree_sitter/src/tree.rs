@@ -1,11 +1,16 @@
+use crate::input_edit::InputEdit;
 use crate::language::{get_language_internal, Language};
 use crate::node::Node;
-use magnus::Error;
+use crate::range::Range;
+use magnus::{Error, RArray, Ruby};
+use std::cell::RefCell;
 use std::sync::Arc;
 
 #[magnus::wrap(class = "TreeSitter::Tree")]
 pub struct Tree {
-    pub inner: Arc<tree_sitter::Tree>,
+    // Wrapped in a RefCell so `edit` can update the tree in place. Nodes hold
+    // their own Arc, so editing never invalidates nodes handed out earlier.
+    pub inner: RefCell<Arc<tree_sitter::Tree>>,
     pub source: Arc<String>,
     pub language_name: String,
 }
@@ -13,15 +18,16 @@ pub struct Tree {
 impl Tree {
     pub fn new(tree: tree_sitter::Tree, source: String, language_name: String) -> Self {
         Self {
-            inner: Arc::new(tree),
+            inner: RefCell::new(Arc::new(tree)),
             source: Arc::new(source),
             language_name,
         }
     }
 
     pub fn root_node(&self) -> Node {
-        let ts_node = self.inner.root_node();
-        Node::new(ts_node, self.source.clone(), self.inner.clone())
+        let inner = self.inner.borrow();
+        let ts_node = inner.root_node();
+        Node::new(ts_node, self.source.clone(), inner.clone())
     }
 
     pub fn source(&self) -> String {
@@ -35,4 +41,35 @@ impl Tree {
             inner: ts_lang,
         })
     }
+
+    /// An independent copy of the tree, so one version can be edited while the
+    /// other is kept around.
+    pub fn copy(&self) -> Tree {
+        Tree {
+            inner: RefCell::new(Arc::new((**self.inner.borrow()).clone())),
+            source: self.source.clone(),
+            language_name: self.language_name.clone(),
+        }
+    }
+
+    /// Adjust the tree for a change to the source text, so it can be passed to
+    /// `Parser#parse` as the old tree. `source` keeps returning the text the
+    /// tree was originally parsed from.
+    pub fn edit(&self, edit: &InputEdit) {
+        let mut inner = self.inner.borrow_mut();
+        Arc::make_mut(&mut inner).edit(&edit.to_ts());
+    }
+
+    /// Ranges whose syntactic structure differs between this (edited) tree and
+    /// `new_tree`, which must have been parsed with this tree as its old tree.
+    pub fn changed_ranges(&self, new_tree: &Tree) -> RArray {
+        let ruby = Ruby::get().unwrap();
+        let array = ruby.ary_new();
+        let old_inner = self.inner.borrow();
+        let new_inner = new_tree.inner.borrow();
+        for range in old_inner.changed_ranges(&new_inner) {
+            let _ = array.push(Range::from_ts(range));
+        }
+        array
+    }
 }
@@ -11,15 +11,42 @@ rescue LoadError
 end
 
 # Load pure Ruby components
+require_relative "tree_sitter/grammar_policy"
+require_relative "tree_sitter/node"
+require_relative "tree_sitter/tree"
+require_relative "tree_sitter/range"
+require_relative "tree_sitter/position_index"
 require_relative "tree_sitter/rewriter"
+require_relative "tree_sitter/change_set"
+require_relative "tree_sitter/lsp"
 require_relative "tree_sitter/formatting"
+require_relative "tree_sitter/separated_list"
+require_relative "tree_sitter/align"
+require_relative "tree_sitter/reflow"
+require_relative "tree_sitter/comments"
+require_relative "tree_sitter/query_template"
 require_relative "tree_sitter/query_rewriter"
+require_relative "tree_sitter/rule_set"
 require_relative "tree_sitter/inserter"
 require_relative "tree_sitter/transformer"
 require_relative "tree_sitter/refactor"
+require_relative "tree_sitter/repl"
+require_relative "tree_sitter/query_profiler"
+require_relative "tree_sitter/language_detector"
+require_relative "tree_sitter/text_diff"
+require_relative "tree_sitter/parser"
+require_relative "tree_sitter/watcher"
+require_relative "tree_sitter/structural_diff"
+require_relative "tree_sitter/git"
+require_relative "tree_sitter/symbols"
+require_relative "tree_sitter/change_summary"
+require_relative "tree_sitter/history"
+require_relative "tree_sitter/config_editor"
 
 module TreeSitter
   class Error < StandardError; end
   class ParseError < Error; end
   class QueryError < Error; end
+  class ConflictError < Error; end
+  class GrammarPolicyError < Error; end
 end
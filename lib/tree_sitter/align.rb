@@ -0,0 +1,148 @@
+# frozen_string_literal: true
+
+require_relative "rewriter"
+
+module TreeSitter
+  # Vertical alignment of the operators of consecutive sibling lines:
+  # assignment runs, struct literal fields, hash rockets and the like.
+  #
+  # Nodes are grouped into runs of siblings on consecutive lines; a blank
+  # line, a comment line or any other line between two nodes ends the run,
+  # as does a node without the operator on its first line. Within a run,
+  # the operators are moved to the column just past the longest left-hand
+  # side, so existing extra spaces are not kept.
+  #
+  # `on:` is the text of the operator token, or a Symbol naming a field of
+  # the nodes, for languages without an operator (the column of Go struct
+  # field types, `on: :type`).
+  #
+  # @example Align the `=` of let statements
+  #   TreeSitter::Align.align(tree, "(let_declaration) @let", on: "=")
+  #
+  # @example Align Ruby hash rockets
+  #   TreeSitter::Align.align(tree, "(pair) @pair", on: "=>")
+  #
+  module Align
+    # Columns a tab counts for when measuring left-hand sides
+    TAB_WIDTH = 4
+
+    class << self
+      # Align the operators of runs of nodes
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param nodes_or_query [Array<TreeSitter::Node>, String] The nodes, or a query whose captures are the nodes
+      # @param on [String, Symbol] The operator to align, or the field whose start to align
+      # @return [String] The aligned source
+      def align(tree, nodes_or_query, on: "=")
+        rewriter = Rewriter.new(tree.source, tree)
+        edits(tree, nodes_or_query, on: on).each do |start_byte, end_byte, padding|
+          rewriter.replace(start_byte...end_byte, padding)
+        end
+        rewriter.rewrite
+      end
+
+      # The whitespace replacements #align makes
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param nodes_or_query [Array<TreeSitter::Node>, String] The nodes, or a query whose captures are the nodes
+      # @param on [String, Symbol] The operator to align, or the field whose start to align
+      # @return [Array<Array(Integer, Integer, String)>] `[start_byte, end_byte, spaces]` for the gaps before the operators
+      def edits(tree, nodes_or_query, on: "=")
+        source = tree.source
+        runs(target_nodes(tree, nodes_or_query), source, on).flat_map do |run|
+          column = run.map { |entry| entry[:left_width] }.max + 1
+          run.filter_map do |entry|
+            padding = " " * (column - entry[:left_width])
+            next if source.byteslice(entry[:gap_start]...entry[:gap_end]) == padding
+
+            [entry[:gap_start], entry[:gap_end], padding]
+          end
+        end
+      end
+
+      private
+
+      def target_nodes(tree, nodes_or_query)
+        nodes = if nodes_or_query.is_a?(String)
+          query = TreeSitter::Query.new(tree.language, nodes_or_query)
+          TreeSitter::QueryCursor.new.matches(query, tree.root_node, tree.source)
+            .flat_map { |match| match.captures.map(&:node) }
+        else
+          Array(nodes_or_query)
+        end
+        nodes.uniq { |node| [node.start_byte, node.end_byte] }.sort_by(&:start_byte)
+      end
+
+      # Group the nodes into runs of siblings on consecutive lines
+      def runs(nodes, source, on)
+        runs = []
+        previous = nil
+        nodes.each do |node|
+          entry = entry_for(node, source, on)
+          continues = entry && previous && previous[:entry] &&
+            node.parent == previous[:node].parent &&
+            node.start_point.row == previous[:node].end_point.row + 1
+          runs << [] unless continues
+          runs.last << entry if entry
+          previous = { node: node, entry: entry }
+        end
+        runs.select { |run| run.size > 1 }
+      end
+
+      # Where the operator of a node is and how wide its left-hand side is,
+      # or nil if the node cannot be aligned
+      def entry_for(node, source, on)
+        line_start = node.start_byte.zero? ? 0 : (source.byterindex("\n", node.start_byte - 1) || -1) + 1
+        # Only nodes that start their line
+        return unless source.byteslice(line_start...node.start_byte).strip.empty?
+
+        target = on.is_a?(Symbol) ? node.child_by_field_name(on.to_s) : operator(node, on)
+        return unless target && target.start_point.row == node.start_point.row
+
+        left = previous_leaf(node, target)
+        return unless left && left.end_byte <= target.start_byte
+
+        {
+          gap_start: left.end_byte,
+          gap_end: target.start_byte,
+          left_width: width(source.byteslice(line_start...left.end_byte)),
+        }
+      end
+
+      # The first token of the node with the operator's text, skipping
+      # nested nodes that start on a later line
+      def operator(node, text)
+        stack = [node]
+        until stack.empty?
+          current = stack.shift
+          next if current.start_point.row > node.start_point.row
+          return current if current.child_count.zero? && current.text == text
+
+          stack = current.children + stack
+        end
+        nil
+      end
+
+      # The last token of the node that ends before the target
+      def previous_leaf(node, target)
+        leaves = []
+        stack = [node]
+        until stack.empty?
+          current = stack.shift
+          next if current.start_byte >= target.start_byte
+
+          if current.child_count.zero?
+            leaves << current
+          else
+            stack = current.children + stack
+          end
+        end
+        leaves.select { |leaf| leaf.end_byte <= target.start_byte }.max_by(&:end_byte)
+      end
+
+      def width(text)
+        text.length + (text.count("\t") * (TAB_WIDTH - 1))
+      end
+    end
+  end
+end
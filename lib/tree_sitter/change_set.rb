@@ -0,0 +1,309 @@
+# frozen_string_literal: true
+
+require "json"
+
+module TreeSitter
+  # Edits to one or more files, collected from any of the rewriting tools
+  # (`Rewriter`, `QueryRewriter`, `Transformer`, `Inserter`) against the
+  # same base sources.
+  #
+  # Edits are byte ranges of the base source of their file. Adding an edit
+  # that overlaps one already in the set raises a ConflictError, so several
+  # tools can contribute to one change without silently clobbering each
+  # other. The set can then be applied to the files on disk (and reverted),
+  # or saved as JSON and replayed later.
+  #
+  # @example Combine two tools and write the result
+  #   changes = TreeSitter::ChangeSet.new
+  #   changes.merge!(Rewriter.new(source, tree).replace(name_node, "run").to_change_set(path: "src/main.rs"))
+  #   changes.merge!(Inserter.new(source, tree).after(fn_node).insert_sibling("fn helper() {}").to_change_set(path: "src/main.rs"))
+  #   changes.apply!
+  #
+  class ChangeSet
+    include Enumerable
+
+    # A single edit of a file's base source
+    #
+    # Origin names the tool that produced it, e.g. "rewriter".
+    Edit = Struct.new(:path, :start_byte, :end_byte, :replacement, :origin, keyword_init: true) do
+      # @return [Boolean] True if the edit only inserts text
+      def insertion?
+        start_byte == end_byte
+      end
+
+      # @return [Boolean] True if this edit and another cannot both be applied
+      def conflicts_with?(other)
+        return false unless path == other.path
+        return false if start_byte == other.start_byte && end_byte == other.end_byte && replacement == other.replacement
+
+        if insertion? && other.insertion?
+          # Insertions at one point are only ordered within a single tool
+          start_byte == other.start_byte && origin != other.origin
+        elsif insertion?
+          start_byte > other.start_byte && start_byte < other.end_byte
+        elsif other.insertion?
+          other.conflicts_with?(self)
+        else
+          start_byte < other.end_byte && other.start_byte < end_byte
+        end
+      end
+
+      # @return [String] e.g. "src/main.rs:10...14"
+      def to_s
+        "#{path || "(source)"}:#{start_byte}...#{end_byte}"
+      end
+    end
+
+    # Version of the JSON format written by #to_json
+    FORMAT_VERSION = 1
+
+    class << self
+      # Load a change set saved with #to_json
+      #
+      # @param json [String] The JSON document
+      # @return [ChangeSet]
+      # @raise [ArgumentError] If the document is not a change set
+      def from_json(json)
+        data = JSON.parse(json)
+        unless data.is_a?(Hash) && data["version"] == FORMAT_VERSION
+          raise ArgumentError, "Not a change set (expected version #{FORMAT_VERSION})"
+        end
+
+        data.fetch("files").each_with_object(new) do |file, change_set|
+          edits = file.fetch("edits").map do |edit|
+            [edit.fetch("start_byte"), edit.fetch("end_byte"), edit.fetch("replacement"), edit["origin"]]
+          end
+          change_set.add(file["path"], file.fetch("base"), edits)
+        end
+      end
+    end
+
+    def initialize
+      @bases = {}
+      @edits = []
+    end
+
+    # Add edits of one file
+    #
+    # @param path [String, nil] The file (nil for a source that is not on disk)
+    # @param base [String] The source the edits were made against
+    # @param edits [Array] Edits as `[start_byte, end_byte, replacement]` arrays (optionally
+    #   with an origin as fourth element), hashes with those keys, or Rewriter::Edit structs
+    # @param origin [String, nil] The tool the edits come from
+    # @return [self]
+    # @raise [ConflictError] If the base differs from the one already recorded for the file,
+    #   or an edit overlaps one already in the set
+    def add(path, base, edits, origin: nil)
+      path = path&.to_s
+      if @bases.key?(path) && @bases[path] != base
+        raise ConflictError, "#{path || "(source)"} was edited against a different base"
+      end
+
+      accepted = @edits.dup
+      conflicts = []
+      edits.each do |raw|
+        edit = build_edit(path, raw, origin, base.bytesize)
+        next if duplicate?(accepted, edit)
+
+        conflicts.concat(accepted.select { |existing| edit.conflicts_with?(existing) }.map { |existing| [existing, edit] })
+        accepted << edit
+      end
+      unless conflicts.empty?
+        details = conflicts.map { |a, b| "#{a} (#{a.origin || "unknown"}) and #{b} (#{b.origin || "unknown"})" }
+        raise ConflictError, "Conflicting edits: #{details.join("; ")}"
+      end
+
+      @bases[path] = base.dup.freeze
+      @edits = accepted
+      self
+    end
+
+    # Add all edits of another change set
+    #
+    # @param other [ChangeSet] The change set to merge in
+    # @return [self]
+    # @raise [ConflictError] If the two sets have conflicting edits
+    def merge!(other)
+      other.paths.each do |path|
+        add(path, other.base(path), other.edits_for(path).map do |edit|
+          [edit.start_byte, edit.end_byte, edit.replacement, edit.origin]
+        end)
+      end
+      self
+    end
+
+    # @param other [ChangeSet] The change set to merge in
+    # @return [ChangeSet] A new change set with the edits of both
+    def merge(other)
+      ChangeSet.new.merge!(self).merge!(other)
+    end
+
+    # @yield [Edit] Each edit, by file and then by position
+    def each(&block)
+      sorted_edits.each(&block)
+    end
+
+    # @return [Array<String, nil>] The files with edits
+    def paths
+      @bases.keys
+    end
+
+    # @return [Integer] The number of edits
+    def size
+      @edits.size
+    end
+
+    # @return [Boolean] True if there are no edits
+    def empty?
+      @edits.empty?
+    end
+
+    # @param path [String, nil] A file
+    # @return [String, nil] The source the edits to the file were made against
+    def base(path)
+      @bases[path&.to_s]
+    end
+
+    # @param path [String, nil] A file
+    # @return [Array<Edit>] The edits to the file, in the order they are applied
+    def edits_for(path)
+      path = path&.to_s
+      sorted_edits.select { |edit| edit.path == path }
+    end
+
+    # The edited source of a file
+    #
+    # @param path [String, nil] A file
+    # @return [String, nil] The new source, or nil if the file has no edits
+    def result(path)
+      base = base(path)
+      return unless base
+
+      result = +""
+      position = 0
+      edits_for(path).each do |edit|
+        result << base.byteslice(position...edit.start_byte) if edit.start_byte > position
+        result << edit.replacement
+        position = [position, edit.end_byte].max
+      end
+      result << base.byteslice(position..)
+    end
+
+    # @return [Hash{String => String}] The edited source of every file
+    def results
+      paths.to_h { |path| [path, result(path)] }
+    end
+
+    # Write the edited sources to disk
+    #
+    # @param root [String] Directory the paths are relative to
+    # @return [self]
+    # @raise [ConflictError] If a file no longer matches the base the edits were made against
+    def apply!(root: Dir.pwd)
+      replace_files(root) { |path| [base(path), result(path)] }
+    end
+
+    # Restore the files written by #apply!
+    #
+    # @param root [String] Directory the paths are relative to
+    # @return [self]
+    # @raise [ConflictError] If a file was changed since the edits were applied
+    def revert!(root: Dir.pwd)
+      replace_files(root) { |path| [result(path), base(path)] }
+    end
+
+    # The edits as an LSP `WorkspaceEdit`
+    #
+    # @param root [String] Directory the paths are relative to
+    # @yield [String] Optionally map each path to its URI (default: a file:// URI)
+    # @return [Hash]
+    def to_workspace_edit(root: Dir.pwd, &uri_for)
+      raise ArgumentError, "Cannot name a source without a path" if paths.include?(nil)
+
+      uri_for ||= ->(path) { LSP.file_uri(File.expand_path(path, root)) }
+      { "changes" => paths.to_h { |path| [uri_for.call(path), LSP.text_edits(base(path), edits_for(path))] } }
+    end
+
+    # @return [Hash] The change set as plain data
+    def to_h
+      {
+        "version" => FORMAT_VERSION,
+        "files" => paths.map do |path|
+          {
+            "path" => path,
+            "base" => base(path),
+            "edits" => edits_for(path).map do |edit|
+              {
+                "start_byte" => edit.start_byte,
+                "end_byte" => edit.end_byte,
+                "replacement" => edit.replacement,
+                "origin" => edit.origin,
+              }
+            end,
+          }
+        end,
+      }
+    end
+
+    # @return [String] The change set as JSON, for ChangeSet.from_json
+    def to_json(*args)
+      to_h.to_json(*args)
+    end
+
+    private
+
+    def build_edit(path, edit, origin, size)
+      start_byte, end_byte, replacement, edit_origin = case edit
+      when Array then edit
+      when Hash then edit.values_at(:start_byte, :end_byte, :replacement, :origin)
+      else [edit.start_byte, edit.end_byte, edit.replacement]
+      end
+
+      unless start_byte.is_a?(Integer) && end_byte.is_a?(Integer) && start_byte.between?(0, end_byte) && end_byte <= size
+        raise ArgumentError, "Invalid edit range #{start_byte.inspect}...#{end_byte.inspect} for #{path || "(source)"}"
+      end
+
+      Edit.new(
+        path: path,
+        start_byte: start_byte,
+        end_byte: end_byte,
+        replacement: replacement.to_s,
+        origin: (edit_origin || origin)&.to_s,
+      )
+    end
+
+    # The same replacement from two tools is only kept once. Repeated
+    # insertions from one tool are kept, as they insert the text twice.
+    def duplicate?(edits, edit)
+      edits.any? do |existing|
+        existing.path == edit.path && existing.start_byte == edit.start_byte &&
+          existing.end_byte == edit.end_byte && existing.replacement == edit.replacement &&
+          !(edit.insertion? && existing.origin == edit.origin)
+      end
+    end
+
+    # Insertions come before replacements starting at the same byte, and
+    # keep the order they were added in
+    def sorted_edits
+      @edits.each_with_index
+        .sort_by { |edit, index| [paths.index(edit.path), edit.start_byte, edit.end_byte, index] }
+        .map(&:first)
+    end
+
+    def replace_files(root)
+      raise ArgumentError, "Cannot write a source without a path" if paths.include?(nil)
+
+      writes = paths.map do |path|
+        expected, replacement = yield(path)
+        file = File.expand_path(path, root)
+        current = File.exist?(file) ? File.read(file, mode: "rb").force_encoding(expected.encoding) : nil
+        raise ConflictError, "#{path} has changed since the edits were made" unless current == expected
+
+        [file, replacement]
+      end
+      # Only write once every file has been checked
+      writes.each { |file, content| File.binwrite(file, content) }
+      self
+    end
+  end
+end
@@ -0,0 +1,216 @@
+# frozen_string_literal: true
+
+require_relative "symbols"
+
+module TreeSitter
+  # Symbol-level summary of the changes between two versions of a file:
+  # which functions, methods and types were added, removed, renamed, or had
+  # their signature or body changed.
+  #
+  # Definitions are paired up by qualified name. Whatever is left over on
+  # both sides is paired by body similarity, so a function that was renamed
+  # but otherwise kept its body is reported as a rename rather than as a
+  # removal and an addition. Renames are matched from the outside in: the
+  # members of a renamed class or impl are paired with their counterparts
+  # in the renamed one. A rename is reported along with any change to the
+  # signature or body besides the name. Changes that only touch whitespace
+  # are reported as reformatting.
+  #
+  # @example Changelog lines for a release
+  #   summary = TreeSitter::ChangeSummary.between(old_source, new_source, lang: "rust")
+  #   summary.map(&:to_s)
+  #   # => ["modified `Point::distance` signature", "added function `midpoint`"]
+  #
+  class ChangeSummary
+    include Enumerable
+
+    # A single symbol-level change
+    #
+    # Type is :added, :removed, :renamed, :signature_changed, :body_changed
+    # or :reformatted.
+    Change = Struct.new(:type, :kind, :name, :old_name, :old_definition, :new_definition, keyword_init: true) do
+      # @return [String] A changelog-style description
+      def to_s
+        case type
+        when :added then "added #{kind} `#{name}`"
+        when :removed then "removed #{kind} `#{name}`"
+        when :renamed then "renamed #{kind} `#{old_name}` to `#{name}`"
+        when :signature_changed then "modified `#{name}` signature"
+        when :body_changed then "modified `#{name}` body"
+        when :reformatted then "reformatted `#{name}`"
+        end
+      end
+    end
+
+    # Minimum token similarity (0.0 to 1.0) for a removed and an added
+    # definition to be reported as a rename
+    RENAME_THRESHOLD = 0.6
+
+    attr_reader :changes
+
+    class << self
+      # Summarize the changes between two versions of a file
+      #
+      # @param old_source [String] The old version
+      # @param new_source [String] The new version
+      # @param lang [String, TreeSitter::Language] The language of both versions
+      # @param rename_threshold [Float] Minimum similarity for a rename
+      # @return [ChangeSummary]
+      def between(old_source, new_source, lang:, rename_threshold: RENAME_THRESHOLD)
+        parser = TreeSitter::Parser.new
+        parser.language = lang.is_a?(TreeSitter::Language) ? lang.name : lang
+
+        old_tree = parser.parse(old_source)
+        new_tree = parser.parse(new_source)
+        raise TreeSitter::ParseError, "Failed to parse source" unless old_tree && new_tree
+
+        new(old_tree, new_tree, rename_threshold: rename_threshold)
+      end
+    end
+
+    # Initialize a summary from two already parsed trees
+    #
+    # @param old_tree [TreeSitter::Tree] The old version
+    # @param new_tree [TreeSitter::Tree] The new version
+    # @param rename_threshold [Float] Minimum similarity for a rename
+    def initialize(old_tree, new_tree, rename_threshold: RENAME_THRESHOLD)
+      @old_tree = old_tree
+      @new_tree = new_tree
+      @rename_threshold = rename_threshold
+      @changes = compute
+    end
+
+    # @yield [Change] Each change, in order
+    def each(&block)
+      @changes.each(&block)
+    end
+
+    # @return [Boolean] True if no symbol changed
+    def empty?
+      @changes.empty?
+    end
+
+    # @return [Boolean] True if the files differ only in whitespace
+    def formatting_only?
+      @old_tree.source != @new_tree.source &&
+        Symbols.tokens(@old_tree.root_node) == Symbols.tokens(@new_tree.root_node)
+    end
+
+    # @param type [Symbol] E.g. :added or :signature_changed
+    # @return [Array<Change>] Changes of that type
+    def of_type(type)
+      @changes.select { |change| change.type == type }
+    end
+
+    # @return [String] One changelog line per change
+    def to_s
+      @changes.map { |change| "- #{change}" }.join("\n")
+    end
+
+    private
+
+    def compute
+      old_definitions = Symbols.extract(@old_tree)
+      new_definitions = Symbols.extract(@new_tree)
+
+      # Pair definitions with the same kind and name, in order of appearance,
+      # so that several `impl Point` blocks are matched one to one
+      unmatched = old_definitions.group_by { |definition| [definition.kind, definition.qualified_name] }
+      pairs = new_definitions.map do |definition|
+        [unmatched[[definition.kind, definition.qualified_name]]&.shift, definition]
+      end
+      removed = unmatched.values.flatten
+      added = pairs.select { |old, _new| old.nil? }.map(&:last)
+      renames = match_renames(removed, added)
+
+      changes = []
+      pairs.each do |old, new|
+        old ||= renames[new]
+        if old.nil?
+          changes << change(:added, nil, new)
+        else
+          changes << change(:renamed, old, new) if old.name != new.name
+          changes.concat(modifications(old, new))
+        end
+      end
+
+      matched = renames.values
+      removed.each do |old|
+        changes << change(:removed, old, nil) unless matched.any? { |definition| definition.equal?(old) }
+      end
+      changes
+    end
+
+    # The changes to a pair of definitions besides a rename
+    def modifications(old, new)
+      return [] if old.node.text == new.node.text
+
+      renamed = ->(tokens) { tokens.map { |token| token == old.name ? new.name : token } }
+      if renamed.call(old.tokens) == new.tokens
+        # Only whitespace changed, or only the name
+        return old.name == new.name ? [change(:reformatted, old, new)] : []
+      end
+
+      changes = []
+      changes << change(:signature_changed, old, new) if renamed.call(old.signature.split(" ")) != new.signature.split(" ")
+      # A container's body is its members, which are reported on their own
+      changes << change(:body_changed, old, new) if !new.container? && renamed.call(old.body_tokens) != new.body_tokens
+      changes
+    end
+
+    # Pair removed and added definitions of the same kind and scope whose
+    # tokens are similar enough, best matches first. Containers are paired
+    # before their members: once a container is, its members are compared
+    # with the members of its counterpart, and those with the same name are
+    # paired first.
+    def match_renames(removed, added)
+      renames = {}.compare_by_identity
+      scopes = {}
+      loop do
+        found = match_round(removed, added, renames, scopes)
+        break if found.empty?
+
+        found.each do |old, new|
+          renames[new] = old
+          scopes[old.qualified_name] = new.qualified_name if old.container?
+        end
+      end
+      renames
+    end
+
+    # The pairs found among the definitions not paired yet, given the
+    # renamed scopes
+    def match_round(removed, added, renames, scopes)
+      matched = renames.values
+      olds = removed.reject { |old| matched.any? { |definition| definition.equal?(old) } }
+      news = added.reject { |new| renames.key?(new) }
+
+      candidates = olds.product(news).filter_map do |old, new|
+        next unless old.kind == new.kind && scopes.fetch(old.scope, old.scope) == new.scope
+
+        score = old.name == new.name ? Float::INFINITY : Symbols.similarity(old, new)
+        [score, old, new] if score >= @rename_threshold
+      end
+
+      found = []
+      candidates.sort_by { |score, _old, _new| -score }.each do |_score, old, new|
+        next if found.any? { |o, n| o.equal?(old) || n.equal?(new) }
+
+        found << [old, new]
+      end
+      found
+    end
+
+    def change(type, old, new)
+      definition = new || old
+      Change.new(
+        type: type,
+        kind: definition.kind,
+        name: definition.qualified_name,
+        old_name: old&.qualified_name,
+        old_definition: old,
+        new_definition: new,
+      )
+    end
+  end
+end
@@ -0,0 +1,172 @@
+# frozen_string_literal: true
+
+require "optparse"
+require_relative "language_detector"
+require_relative "repl"
+require_relative "structural_diff"
+
+module TreeSitter
+  # Command-line entry point for the `tree-sitter-rb` executable.
+  #
+  # Grammars are loaded on demand: pass `--grammar PATH`, or set
+  # `TREE_SITTER_<LANG>_PATH` (the same variables the test suite uses).
+  #
+  # @example
+  #   $ tree-sitter-rb repl src/main.rs
+  #   $ GIT_EXTERNAL_DIFF="tree-sitter-rb difftool" git diff
+  #
+  module CLI
+    USAGE = <<~USAGE
+      Usage: tree-sitter-rb COMMAND [options] FILE...
+
+      Commands:
+        repl FILE              Explore the syntax tree of FILE and try out queries interactively
+        difftool OLD NEW       Show a syntax-aware diff between two files
+                               (also accepts the seven or nine arguments passed by GIT_EXTERNAL_DIFF)
+
+      Options:
+    USAGE
+
+    class << self
+      # Run the CLI
+      #
+      # @param argv [Array<String>] Command-line arguments
+      # @param input [IO] Standard input
+      # @param output [IO] Standard output
+      # @param error [IO] Standard error
+      # @return [Integer] Exit status
+      def start(argv, input: $stdin, output: $stdout, error: $stderr)
+        options = {}
+        parser = option_parser(options)
+        args = parser.parse(argv)
+        command = args.shift
+
+        case command
+        when "repl"
+          repl(args, options, input: input, output: output)
+        when "difftool"
+          difftool(args, options, output: output)
+        when nil, "help"
+          output.puts(parser.help)
+          0
+        else
+          error.puts("Unknown command: #{command}")
+          error.puts(parser.help)
+          1
+        end
+      rescue OptionParser::ParseError, ArgumentError, RuntimeError, SystemCallError => e
+        error.puts("tree-sitter-rb: #{e.message}")
+        1
+      end
+
+      # Resolve, register if needed, and return the language name for a file
+      #
+      # @param path [String] The file being processed
+      # @param lang [String, nil] Explicit language name
+      # @param grammar [String, nil] Explicit grammar library path
+      # @return [String] The registered language name
+      def load_language(path, lang: nil, grammar: nil)
+        name = lang || LanguageDetector.new.detect(path)
+        raise ArgumentError, "Cannot tell the language of #{path}; pass --lang" unless name
+
+        return name if grammar.nil? && TreeSitter.languages.include?(name)
+
+        library = grammar || ENV["TREE_SITTER_#{name.upcase}_PATH"]
+        unless library && File.exist?(library)
+          raise ArgumentError, "No grammar for #{name}; pass --grammar or set TREE_SITTER_#{name.upcase}_PATH"
+        end
+
+        TreeSitter.register_language(name, library)
+        name
+      end
+
+      private
+
+      def option_parser(options)
+        OptionParser.new do |opts|
+          opts.banner = USAGE
+          opts.on("-l", "--lang NAME", "Language name (default: guessed from the file extension)") do |name|
+            options[:lang] = name
+          end
+          opts.on("-g", "--grammar PATH", "Grammar shared library to load") do |path|
+            options[:grammar] = path
+          end
+          opts.on("--inline", "difftool: show a unified diff instead of side by side") do
+            options[:inline] = true
+          end
+          opts.on("-w", "--width COLUMNS", Integer, "difftool: output width (default: terminal width)") do |width|
+            options[:width] = width
+          end
+          opts.on("-C", "--context LINES", Integer, "difftool: unchanged lines around each change (default: 3)") do |lines|
+            options[:context] = lines
+          end
+          opts.on("--[no-]color", "difftool: highlight changes (default: when writing to a terminal)") do |color|
+            options[:color] = color
+          end
+        end
+      end
+
+      def repl(args, options, input:, output:)
+        path = args.first
+        raise ArgumentError, "repl needs a FILE" unless path
+
+        parser = TreeSitter::Parser.new
+        parser.language = load_language(path, lang: options[:lang], grammar: options[:grammar])
+        source = File.read(path)
+        tree = parser.parse(source)
+
+        Repl.new(source, tree, input: input, output: output).run
+        0
+      end
+
+      # GIT_EXTERNAL_DIFF runs `cmd path old-file old-hex old-mode new-file new-hex new-mode`,
+      # followed by `new-path xfrm-msg` for a rename or copy
+      def difftool(args, options, output:)
+        path, old_path, new_path, title = case args.length
+        when 9 then [args[7], args[1], args[4], "#{args[0]} => #{args[7]}"]
+        when 7 then [args[0], args[1], args[4], args[0]]
+        when 2 then [args[1], args[0], args[1], args[1]]
+        else raise ArgumentError, "difftool needs OLD and NEW files"
+        end
+
+        language = begin
+          load_language(path, lang: options[:lang], grammar: options[:grammar])
+        rescue ArgumentError, TreeSitter::Error, RuntimeError
+          nil # Unknown language, no grammar or a grammar that fails to load: fall back to a line diff
+        end
+
+        diff = StructuralDiff.new(read_side(old_path), read_side(new_path), language: language)
+        color = options.fetch(:color) { output.respond_to?(:tty?) && output.tty? }
+        context = options.fetch(:context, 3)
+
+        output.puts("#{title} (#{diff.structural? ? language : "line diff"})")
+        unless diff.changed?
+          output.puts(diff.structural? ? "No syntactic changes (only formatting differs)" : "No changes")
+          return 0
+        end
+
+        rendered = if options[:inline]
+          diff.render_inline(color: color, context: context)
+        else
+          diff.render_side_by_side(width: options[:width] || terminal_width, color: color, context: context)
+        end
+        output.print(rendered)
+        0
+      end
+
+      # Missing sides of an added or deleted file are given as /dev/null
+      def read_side(path)
+        path == File::NULL || !File.exist?(path) ? "" : File.read(path)
+      end
+
+      def terminal_width
+        return ENV["COLUMNS"].to_i if ENV["COLUMNS"].to_i.positive?
+
+        require "io/console"
+        IO.console&.winsize&.last || 120
+      rescue LoadError, SystemCallError
+        120
+      end
+    end
+  end
+end
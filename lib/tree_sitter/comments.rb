@@ -0,0 +1,323 @@
+# frozen_string_literal: true
+
+require_relative "rewriter"
+
+module TreeSitter
+  # Rewrapping of comment blocks to a line width.
+  #
+  # Consecutive line comments with the same marker and indentation are
+  # rewrapped together, as are the paragraphs of doc block comments
+  # (`/** */`, `/*! */`) that start their line; these keep the prefix their
+  # lines already have (` * ` or plain indentation). The contents are read
+  # as Markdown: blank lines, list items and `@tags` start paragraphs (list
+  # items wrap with a hanging indent), and fenced or indented code blocks,
+  # headings, tables, quotes and lines holding only an XML tag (C#'s
+  # `<summary>`) are kept as they are. A word longer than the width is not
+  # broken.
+  #
+  # Plain block comments (`/* */`) often hold commented-out code or license
+  # headers, so they are left alone unless `normalize:` turns them into line
+  # comments.
+  #
+  # Line comments without a space after the marker (`//go:build`, `#!`) and
+  # directives such as Ruby magic comments are left alone, as are comments
+  # that share their line with code.
+  #
+  # @example
+  #   TreeSitter::Comments.reflow(tree, width: 80)
+  #   TreeSitter::Comments.reflow(tree, width: 80, normalize: true) # /* */ becomes //
+  #
+  class Comments
+    # Line comment markers (longest first), whether the language has
+    # C-style block comments, and directive comments to leave alone, per
+    # language
+    LANGUAGES = {
+      "rust" => { markers: ["///", "//!", "//"], block: true },
+      "javascript" => { markers: ["//"], block: true },
+      "go" => { markers: ["//"], block: true },
+      "java" => { markers: ["//"], block: true },
+      "c_sharp" => { markers: ["///", "//"], block: true },
+      "php" => { markers: ["//", "#"], block: true },
+      "ruby" => {
+        markers: ["#"],
+        directive: /\A(-\*-|(frozen_string_literal|encoding|coding|warn_indent|shareable_constant_value|typed|rubocop):)/,
+      },
+      "python" => {
+        markers: ["#"],
+        directive: /\A(-\*-|(type|noqa|pylint|fmt|pragma)\b)/,
+      },
+    }.freeze
+
+    # Comment marker block comments are normalized to
+    LINE_MARKER = "//"
+
+    # Columns a tab counts for when measuring lines
+    TAB_WIDTH = 4
+
+    LIST_ITEM = /\A\s*(?:[-*+]|\d+[.)])\s+/
+    TAG = /\A\s*@\w+/
+    FENCE = /\A\s*(```|~~~)/
+    # Lines that are kept as they are: blank lines, headings, tables, quotes and link definitions
+    VERBATIM_LINE = /\A\s*(?:\z|#|\||>|\[[^\]]+\]:)/
+    # Lines that hold only an XML tag, as in C# doc comments
+    XML_TAG_LINE = %r{\A\s*</?[A-Za-z][^<>]*>\s*\z}
+
+    # A paragraph being wrapped: the prefix of its first line (a list
+    # bullet), the prefix of the others, and its words
+    Paragraph = Struct.new(:first, :rest, :words, keyword_init: true)
+
+    # Line comments that are rewrapped together
+    Group = Struct.new(:marker, :indent, :nodes, keyword_init: true)
+
+    class << self
+      # @param language [String, TreeSitter::Language] A language
+      # @return [Boolean] True if comments of the language can be reflowed
+      def supported?(language)
+        LANGUAGES.key?(language_name(language))
+      end
+
+      # Rewrap the comments of a tree
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param width [Integer] Maximum line width, in characters, including indentation and markers
+      # @param normalize [Boolean] True to turn plain block comments (`/* */`, not doc comments) into line comments
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [String] The source with rewrapped comments
+      # @raise [ArgumentError] If the language is not supported
+      def reflow(tree, width: 80, normalize: false, language: nil)
+        rewriter = Rewriter.new(tree.source, tree)
+        edits(tree, width: width, normalize: normalize, language: language).each do |start_byte, end_byte, text|
+          rewriter.replace(start_byte...end_byte, text)
+        end
+        rewriter.rewrite
+      end
+
+      # The replacements #reflow makes
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param width [Integer] Maximum line width
+      # @param normalize [Boolean] True to turn plain block comments into line comments
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [Array<Array(Integer, Integer, String)>] `[start_byte, end_byte, text]` for each changed comment block
+      # @raise [ArgumentError] If the language is not supported
+      def edits(tree, width: 80, normalize: false, language: nil)
+        name = language_name(language || tree.language)
+        config = LANGUAGES.fetch(name) do
+          raise ArgumentError, "Comment reflow is not supported for #{name}"
+        end
+
+        new(tree, config, width, normalize).edits
+      end
+
+      private
+
+      def language_name(language)
+        language.is_a?(TreeSitter::Language) ? language.name : language.to_s
+      end
+    end
+
+    # @param tree [TreeSitter::Tree] The parsed syntax tree
+    # @param config [Hash] An entry of LANGUAGES
+    # @param width [Integer] Maximum line width
+    # @param normalize [Boolean] True to turn plain block comments into line comments
+    def initialize(tree, config, width, normalize)
+      @tree = tree
+      @source = tree.source
+      @config = config
+      @width = width
+      @normalize = normalize
+    end
+
+    # @return [Array<Array(Integer, Integer, String)>] See Comments.edits
+    def edits
+      groups(comment_nodes(@tree.root_node)).filter_map do |group|
+        start_byte = group.nodes.first.start_byte
+        end_byte = comment_end(group.nodes.last)
+        text = group.marker ? line_comments(group) : block_comment(group.nodes.first, group.indent)
+        [start_byte, end_byte, text] if text && text != @source.byteslice(start_byte...end_byte)
+      end
+    end
+
+    private
+
+    def comment_nodes(node, result = [])
+      if node.extra? && node.kind.include?("comment")
+        result << node
+      else
+        node.children.each { |child| comment_nodes(child, result) }
+      end
+      result
+    end
+
+    # Comments on lines of their own, with runs of line comments grouped
+    def groups(comments)
+      comments.each_with_object([]) do |node, groups|
+        indent = @source.byteslice(line_start(node.start_byte)...node.start_byte)
+        next unless indent.strip.empty? && @source.byteslice(comment_end(node)...line_end(comment_end(node))).strip.empty?
+
+        marker = line_marker(node)
+        previous = groups.last
+        if marker && previous && previous.marker == marker && previous.indent == indent &&
+            previous.nodes.last.start_point.row + 1 == node.start_point.row
+          previous.nodes << node
+        elsif marker || block?(node)
+          groups << Group.new(marker: marker, indent: indent, nodes: [node])
+        end
+      end
+    end
+
+    # The marker of a line comment that can be rewrapped
+    def line_marker(node)
+      text = comment_text(node)
+      marker = @config[:markers].find { |candidate| text.start_with?(candidate) }
+      return unless marker
+
+      content = text.delete_prefix(marker)
+      return unless content.empty? || content.start_with?(" ")
+      return if @config[:directive]&.match?(content.strip)
+
+      marker
+    end
+
+    def block?(node)
+      @config[:block] && comment_text(node).start_with?("/*") && comment_text(node).end_with?("*/")
+    end
+
+    def line_comments(group)
+      contents = group.nodes.map { |node| comment_text(node).delete_prefix(group.marker).delete_prefix(" ") }
+      commented(layout(contents, available("#{group.indent}#{group.marker} ")), group.marker, group.indent)
+    end
+
+    def block_comment(node, indent)
+      text = comment_text(node)
+      opener = text[%r{\A/\*[*!]?}]
+      return if opener == "/*" && !@normalize
+
+      body = text[opener.length...-2]
+      contents = block_contents(body)
+      return if contents.empty?
+
+      if opener == "/*"
+        commented(layout(contents, available("#{indent}#{LINE_MARKER} ")), LINE_MARKER, indent)
+      elsif text.include?("\n") || width(indent) + width(text) > @width
+        doc_block_comment(opener, body, contents, indent)
+      end
+    end
+
+    # A doc block comment rewrapped in its own layout: the prefix of its
+    # lines, text on the opener's line or not, and the closer on a line of
+    # its own or not. A comment on one line gets ` * ` lines.
+    def doc_block_comment(opener, body, contents, indent)
+      first, *rest = body.split("\n", -1)
+      opener_alone = rest.empty? || first.strip.empty?
+      closer_alone = rest.empty? || rest.last.strip.empty?
+      closer_line = rest.empty? ? "#{indent} " : rest.last
+      lines = (closer_alone ? rest[0...-1] : rest).reject { |line| line.strip.empty? }
+
+      lead = if lines.empty? || lines.all? { |line| line.lstrip.start_with?("*") }
+        "#{lines.first ? lines.first[/\A[ \t]*\*/] : "#{indent} *"} "
+      else
+        lines.map { |line| line[/\A[ \t]*/] }.min_by(&:length)
+      end
+
+      columns = available(lead)
+      columns = [columns, available("#{indent}#{opener} ")].min unless opener_alone
+      wrapped = layout(contents, columns).map { |line| line.empty? ? lead.rstrip : lead + line }
+      wrapped[0] = "#{opener} #{wrapped[0].delete_prefix(lead)}" unless opener_alone
+
+      result = opener_alone ? "#{opener}\n#{wrapped.join("\n")}" : wrapped.join("\n")
+      closer_alone ? "#{result}\n#{closer_line}*/" : "#{result} */"
+    end
+
+    # The lines of a block comment without the opener, closer and the
+    # leading `*` of each line
+    def block_contents(body)
+      first, *rest = body.split("\n", -1)
+      code = rest.reject { |line| line.strip.empty? }
+      rest = if code.all? { |line| line.lstrip.start_with?("*") }
+        rest.map { |line| line.lstrip.delete_prefix("*").delete_prefix(" ") }
+      else
+        common = code.map { |line| line[/\A[ \t]*/].length }.min || 0
+        rest.map { |line| line[common..] || "" }
+      end
+
+      lines = [first.to_s.strip, *rest].map(&:rstrip)
+      lines.shift while lines.first&.empty?
+      lines.pop while lines.last&.empty?
+      lines
+    end
+
+    def commented(lines, marker, indent)
+      lines.map { |line| line.empty? ? marker : "#{marker} #{line}" }.join("\n#{indent}")
+    end
+
+    # The comment contents as rewrapped lines, `columns` wide
+    def layout(lines, columns)
+      blocks = []
+      fenced = false
+
+      lines.each do |line|
+        if line.match?(FENCE)
+          fenced = !fenced
+          blocks << line.rstrip
+        elsif fenced || line.match?(VERBATIM_LINE) || line.match?(XML_TAG_LINE) || (line.start_with?("    ", "\t") && !blocks.last.is_a?(Paragraph))
+          blocks << line.rstrip
+        elsif (bullet = line[LIST_ITEM])
+          blocks << Paragraph.new(first: bullet, rest: " " * bullet.length, words: line.delete_prefix(bullet).split)
+        elsif line.match?(TAG) || !blocks.last.is_a?(Paragraph)
+          lead = line[/\A\s*/]
+          rest = line.match?(TAG) ? "#{lead}  " : lead
+          blocks << Paragraph.new(first: lead, rest: rest, words: line.split)
+        else
+          blocks.last.words.concat(line.split)
+        end
+      end
+
+      blocks.flat_map { |block| block.is_a?(Paragraph) ? wrap(block, columns) : [block] }
+    end
+
+    # Columns left for text on a line that starts with lead
+    def available(lead)
+      @width - width(lead)
+    end
+
+    def wrap(paragraph, columns)
+      lines = []
+      current = nil
+      paragraph.words.each do |word|
+        if current && width(current) + 1 + width(word) > columns
+          lines << current
+          current = paragraph.rest + word
+        else
+          current = current ? "#{current} #{word}" : paragraph.first + word
+        end
+      end
+      lines << current if current
+      lines
+    end
+
+    # The comment's text; line comments of some grammars include their newline
+    def comment_text(node)
+      @source.byteslice(node.start_byte...comment_end(node))
+    end
+
+    def comment_end(node)
+      text = node.text
+      node.end_byte - (text.bytesize - text.chomp.bytesize)
+    end
+
+    def line_start(byte)
+      return 0 if byte.zero?
+
+      (@source.byterindex("\n", byte - 1) || -1) + 1
+    end
+
+    def line_end(byte)
+      @source.byteindex("\n", byte) || @source.bytesize
+    end
+
+    def width(text)
+      text.length + (text.count("\t") * (TAB_WIDTH - 1))
+    end
+  end
+end
@@ -0,0 +1,178 @@
+# frozen_string_literal: true
+
+require_relative "rewriter"
+require_relative "text_diff"
+require_relative "parser"
+require_relative "formatting"
+require_relative "config_editor/format"
+require_relative "config_editor/json"
+require_relative "config_editor/toml"
+require_relative "config_editor/yaml"
+
+module TreeSitter
+  # Format-preserving editor for JSON, YAML and TOML configuration files.
+  #
+  # Every change is a minimal `Rewriter` edit of the nodes involved, so
+  # comments, key order, quoting style and indentation elsewhere in the file
+  # are left exactly as they were.
+  #
+  # Paths are dot-separated keys (`"dependencies.serde.version"`), with
+  # numbers indexing into arrays (`"bin.0.name"`). Pass an array of keys for
+  # keys that themselves contain dots.
+  #
+  # @example Bump a dependency in Cargo.toml
+  #   editor = TreeSitter::ConfigEditor.new(File.read("Cargo.toml"), lang: "toml")
+  #   editor.get("dependencies.serde.version")    # => "1.0.150"
+  #   editor.set("dependencies.serde.version", "1.0.200")
+  #   File.write("Cargo.toml", editor.source)
+  #
+  # @example Chain edits to package.json
+  #   TreeSitter::ConfigEditor.new(source, lang: "json")
+  #     .set("version", "2.0.0")
+  #     .append("keywords", "parser")
+  #     .delete("scripts.prepublish")
+  #     .source
+  #
+  class ConfigEditor
+    # A key/value (or array element) found at a path
+    #
+    # `name` is the key (or the index, as a string), `key` the node holding
+    # the key (nil for array elements), `value` the value node (nil for an
+    # empty YAML value), `member` the whole pair or element, and `container`
+    # the object, table or array holding it.
+    Entry = Struct.new(:name, :key, :value, :member, :container, keyword_init: true)
+
+    # Language names mapped to their adapters
+    FORMATS = {
+      "json" => Json,
+      "toml" => Toml,
+      "yaml" => Yaml,
+    }.freeze
+
+    attr_reader :source, :tree, :language
+
+    # Initialize a new ConfigEditor
+    #
+    # @param source [String] The configuration file
+    # @param lang [String] "json", "yaml" or "toml" (the grammar must be registered)
+    # @raise [ArgumentError] If the language is not supported
+    def initialize(source, lang:)
+      @language = lang.to_s
+      @format_class = FORMATS.fetch(@language) do
+        raise ArgumentError, "Unsupported config language: #{@language} (expected #{FORMATS.keys.join(", ")})"
+      end
+      @parser = TreeSitter::Parser.new
+      @parser.language = @language
+      update(source, @parser.parse(source))
+    end
+
+    # The value at a path, decoded into Ruby objects
+    #
+    # @param path [String, Array<String>] The path
+    # @return [Object, nil] The value, or nil if there is none
+    def get(path)
+      @format.get(segments(path))
+    end
+
+    # @param path [String, Array<String>] The path
+    # @return [Boolean] True if the path exists
+    def key?(path)
+      !@format.lookup(segments(path)).nil?
+    end
+
+    # Set the value at a path, adding any missing keys
+    #
+    # An existing value is replaced in place. Strings keep the quoting style
+    # of the value they replace where the format allows it.
+    #
+    # @param path [String, Array<String>] The path
+    # @param value [String, Numeric, Boolean, nil, Array, Hash] The new value
+    # @return [self]
+    def set(path, value)
+      keys = segments(path)
+      entry = @format.lookup(keys)
+      apply(entry ? @format.set_edits(entry, value) : @format.insert_edits(keys, value))
+    end
+
+    # Remove a key (with its value) or an array element
+    #
+    # @param path [String, Array<String>] The path
+    # @return [self]
+    # @raise [KeyError] If the path does not exist
+    def delete(path)
+      apply(@format.delete_edits(fetch_entry(path)))
+    end
+
+    # Append a value to the array at a path, creating the array if needed
+    #
+    # @param path [String, Array<String>] Path of the array
+    # @param value [Object] The value to append
+    # @return [self]
+    def append(path, value)
+      keys = segments(path)
+      entry = @format.lookup(keys)
+      return apply(@format.insert_edits(keys, [value])) if entry.nil?
+
+      apply(@format.append_edits(entry, value))
+    end
+
+    # Rename the last key of a path, keeping its value and position
+    #
+    # @param path [String, Array<String>] The path of the key to rename
+    # @param new_key [String] The new name
+    # @return [self]
+    # @raise [KeyError] If the path does not exist
+    def rename_key(path, new_key)
+      entry = fetch_entry(path)
+      raise ArgumentError, "#{path} is an array element, not a key" unless entry.key
+
+      apply(@format.rename_edits(entry, new_key.to_s))
+    end
+
+    # @return [String] The edited source
+    def to_s
+      @source
+    end
+
+    private
+
+    def segments(path)
+      keys = path.is_a?(Array) ? path.map(&:to_s) : path.to_s.split(".")
+      raise ArgumentError, "Empty path" if keys.empty?
+
+      keys
+    end
+
+    def fetch_entry(path)
+      @format.lookup(segments(path)) || raise(KeyError, "No such key: #{Array(path).join(".")}")
+    end
+
+    def apply(edits)
+      return self if edits.empty?
+
+      rewriter = Rewriter.new(@source, @tree)
+      edits.each do |start_byte, end_byte, replacement|
+        range = TreeSitter::Range.new(
+          start_byte,
+          end_byte,
+          TextDiff.point_at(@source, start_byte),
+          TextDiff.point_at(@source, end_byte),
+        )
+        rewriter.replace(range, replacement)
+      end
+
+      new_source = rewriter.rewrite
+      new_tree, _changed_ranges = @parser.reparse(@tree, new_source, old_source: @source)
+      update(new_source, new_tree)
+      self
+    end
+
+    def update(source, tree)
+      raise TreeSitter::ParseError, "Failed to parse #{@language} source" unless tree
+
+      @source = source
+      @tree = tree
+      @format = @format_class.new(source, tree)
+    end
+  end
+end
@@ -0,0 +1,88 @@
+# frozen_string_literal: true
+
+require_relative "../separated_list"
+
+module TreeSitter
+  class ConfigEditor
+    # Base class of the per-language adapters. Adapters find entries and
+    # return edits as `[start_byte, end_byte, replacement]` triples.
+    class Format
+      include SeparatedList
+
+      attr_reader :source, :tree
+
+      def initialize(source, tree)
+        @source = source
+        @tree = tree
+        @indentation = Formatting::IndentationDetector.new(source)
+      end
+
+      # @return [Entry, nil] The entry at a path
+      def lookup(segments)
+        node = root
+        entry = nil
+
+        segments.each do |segment|
+          entry = entries(node).find { |candidate| candidate.name == segment }
+          return unless entry
+
+          node = entry.value
+        end
+        entry
+      end
+
+      # @return [Object, nil] The decoded value at a path
+      def get(segments)
+        entry = lookup(segments)
+        entry&.value && decode(entry.value)
+      end
+
+      # Decode a value node into Ruby objects
+      def decode(node)
+        case container_kind(node)
+        when :mapping
+          entries(node).to_h { |entry| [entry.name, entry.value && decode(entry.value)] }
+        when :sequence
+          entries(node).map { |entry| entry.value && decode(entry.value) }
+        else
+          decode_scalar(node)
+        end
+      end
+
+      # Edits adding a key that does not exist yet
+      #
+      # The value is added to the deepest mapping that does exist, nested in
+      # new mappings for the missing keys in between. If the deepest existing
+      # value is not a mapping it is replaced.
+      def insert_edits(segments, value)
+        depth = segments.length - 1
+        depth -= 1 while depth.positive? && lookup(segments.take(depth)).nil?
+
+        parent = depth.zero? ? nil : lookup(segments.take(depth))
+        remaining = segments.drop(depth)
+        nested = remaining.drop(1).reverse.reduce(value) { |inner, key| { key => inner } }
+        node = parent ? parent.value : root
+
+        if container_kind(node) == :mapping
+          add_member_edits(node, remaining.first, nested)
+        elsif container_kind(node) == :sequence && remaining.first == entries(node).length.to_s
+          append_edits(parent || Entry.new(value: node), nested)
+        elsif parent
+          set_edits(parent, { remaining.first => nested })
+        else
+          raise ArgumentError, "Cannot add #{segments.join(".")}: the document has no mapping at the top level"
+        end
+      end
+
+      private
+
+      def entries(_node)
+        []
+      end
+
+      def container_kind(_node)
+        nil
+      end
+    end
+  end
+end
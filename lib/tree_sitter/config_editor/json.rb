@@ -0,0 +1,126 @@
+# frozen_string_literal: true
+
+require "json"
+
+module TreeSitter
+  class ConfigEditor
+    # JSON adapter (also copes with the comments allowed by the grammar)
+    class Json < Format
+      def root
+        @tree.root_node.named_children.find { |child| child.kind != "comment" }
+      end
+
+      def set_edits(entry, value)
+        target = entry.value
+        [[target.start_byte, target.end_byte, encode(value, indentation_at(entry.member.start_byte))]]
+      end
+
+      def add_member_edits(object, key, value)
+        members = members(object)
+        indent = member_indent(object, members)
+        text = "#{JSON.generate(key)}#{separator}#{encode(value, indent)}"
+        base = indentation_at(object.start_byte)
+
+        add_to_collection_edits(object, members, text, empty: ->(member) { "\n#{indent}#{member}\n#{base}" })
+      end
+
+      def delete_edits(entry)
+        remove_member_edits(entry.container, members(entry.container), entry.member)
+      end
+
+      def append_edits(entry, value)
+        array = entry.value
+        raise ArgumentError, "#{entry.name} is not an array" unless array&.kind == "array"
+
+        members = members(array)
+        add_to_collection_edits(array, members, encode(value, member_indent(array, members)))
+      end
+
+      def rename_edits(entry, new_key)
+        [[entry.key.start_byte, entry.key.end_byte, JSON.generate(new_key)]]
+      end
+
+      private
+
+      def entries(node)
+        case node&.kind
+        when "object"
+          node.named_children.filter_map do |pair|
+            next unless pair.kind == "pair"
+
+            key = pair.child_by_field_name("key")
+            value = pair.child_by_field_name("value")
+            Entry.new(name: decode_scalar(key).to_s, key: key, value: value, member: pair, container: node)
+          end
+        when "array"
+          elements = node.named_children.reject { |child| child.kind == "comment" }
+          elements.each_with_index.map do |element, index|
+            Entry.new(name: index.to_s, key: nil, value: element, member: element, container: node)
+          end
+        else
+          []
+        end
+      end
+
+      def members(node)
+        entries(node).map(&:member)
+      end
+
+      def container_kind(node)
+        case node&.kind
+        when "object" then :mapping
+        when "array" then :sequence
+        end
+      end
+
+      def decode_scalar(node)
+        case node.kind
+        when "string", "number" then JSON.parse(node.text)
+        when "true" then true
+        when "false" then false
+        when "null" then nil
+        else node.text
+        end
+      end
+
+      def encode(value, indent)
+        case value
+        when Hash, Array
+          return JSON.generate(value) if value.empty?
+
+          text = JSON.pretty_generate(
+            value,
+            indent: indent_unit,
+            space_before: separator[/\A[ \t]*/],
+            space: separator[/:([ \t]*)/, 1],
+          )
+          indent_continuation(text, indent)
+        else
+          JSON.generate(value)
+        end
+      end
+
+      # The text between key and value in the file's pairs, e.g. ": "
+      def separator
+        @separator ||= begin
+          pair = first_pair(root)
+          between = pair && @source.byteslice(
+            pair.child_by_field_name("key").end_byte...pair.child_by_field_name("value").start_byte,
+          )
+          between && !between.include?("\n") ? between : ": "
+        end
+      end
+
+      def first_pair(node)
+        return if node.nil?
+        return node if node.kind == "pair"
+
+        node.named_children.each do |child|
+          pair = first_pair(child)
+          return pair if pair
+        end
+        nil
+      end
+    end
+  end
+end
@@ -0,0 +1,389 @@
+# frozen_string_literal: true
+
+require "json"
+
+module TreeSitter
+  class ConfigEditor
+    # TOML adapter
+    #
+    # A TOML document is flat: its keys are spread over the top-level pairs
+    # and `[table]` / `[[array.of.tables]]` sections, and keys may be
+    # dotted. Lookups therefore go through the sections, longest header
+    # first, and new keys are added as a pair line in the deepest existing
+    # section (or as a new table at the end of the file).
+    class Toml < Format
+      # A run of pairs under a header (or at the top of the document)
+      #
+      # Elements of an array of tables have their index as the last path
+      # segment.
+      Section = Struct.new(:path, :node, :pairs, :array, keyword_init: true)
+
+      KEY_KINDS = ["bare_key", "quoted_key", "dotted_key"].freeze
+      TABLE_KINDS = ["table", "table_array_element"].freeze
+      ESCAPES = {
+        "b" => "\b",
+        "t" => "\t",
+        "n" => "\n",
+        "f" => "\f",
+        "r" => "\r",
+        "e" => "\e",
+        '"' => '"',
+        "\\" => "\\",
+      }.freeze
+      private_constant :KEY_KINDS, :TABLE_KINDS, :ESCAPES
+
+      def root
+        @tree.root_node
+      end
+
+      def lookup(segments)
+        sections.sort_by { |section| -section.path.length }.each do |section|
+          next unless segments.take(section.path.length) == section.path
+
+          rest = segments.drop(section.path.length)
+          return section_entry(section) if rest.empty?
+
+          entry = find_in_pairs(section.pairs, section.node, rest)
+          return entry if entry
+        end
+        nil
+      end
+
+      def get(segments)
+        segments.reduce(document_value) do |value, segment|
+          case value
+          when Hash then value[segment]
+          when Array then segment.match?(/\A\d+\z/) ? value[segment.to_i] : nil
+          end
+        end
+      end
+
+      def decode(node)
+        case node.kind
+        when "inline_table" then pairs_to_hash(pairs_in(node))
+        when "array" then array_elements(node).map { |element| decode(element) }
+        else decode_scalar(node)
+        end
+      end
+
+      def insert_edits(segments, value)
+        if sections.any? { |section| section.array && section.path[0...-1] == segments }
+          raise ArgumentError, "#{segments.join(".")} is an array of tables; edit its elements instead"
+        end
+
+        depth = segments.length - 1
+        depth -= 1 while depth.positive? && lookup(segments.take(depth)).nil?
+        parent = depth.zero? ? nil : lookup(segments.take(depth))
+        return add_to_section_edits(segments, value) if parent.nil? || TABLE_KINDS.include?(parent.member.kind)
+
+        rest = segments.drop(depth)
+        nested = rest.drop(1).reverse.reduce(value) { |inner, key| { key => inner } }
+        node = parent.value
+
+        case node.kind
+        when "inline_table"
+          add_to_collection_edits(node, pairs_in(node), "#{encode_key(rest.first)} = #{encode(nested)}")
+        when "array"
+          unless rest.first == array_elements(node).length.to_s
+            raise ArgumentError, "Cannot add #{segments.join(".")}: index out of range"
+          end
+
+          append_edits(parent, nested)
+        else
+          set_edits(parent, { rest.first => nested })
+        end
+      end
+
+      def set_edits(entry, value)
+        return [[entry.value.start_byte, entry.value.end_byte, encode(value, entry.value)]] unless table?(entry.member)
+        raise ArgumentError, "#{entry.name} is a table; set it to a Hash" unless value.is_a?(Hash)
+
+        table = entry.member
+        pairs = pairs_in(table)
+        start = next_line_start(header_close(table).end_byte)
+        stop = pairs.any? ? next_line_start(pairs.last.end_byte) : start
+        lines = value.map { |key, item| "#{encode_key(key.to_s)} = #{encode(item)}\n" }.join
+        [[start, stop, "#{newline_before(start)}#{lines}"]]
+      end
+
+      def delete_edits(entry)
+        member = entry.member
+        if table?(member)
+          last = pairs_in(member).last || header_close(member)
+          stop = next_line_start(last.end_byte)
+          # Take the blank lines after the table along with it
+          while stop < @source.bytesize && @source.byteslice(stop...next_line_start(stop)).strip.empty?
+            stop = next_line_start(stop)
+          end
+          [[line_start(member.start_byte), stop, ""]]
+        elsif ["inline_table", "array"].include?(entry.container.kind)
+          remove_member_edits(entry.container, members(entry.container), member)
+        else
+          [[line_start(member.start_byte), next_line_start(member.end_byte), ""]]
+        end
+      end
+
+      def append_edits(entry, value)
+        array = entry.value
+        raise ArgumentError, "#{entry.name} is not an array" unless array&.kind == "array"
+
+        add_to_collection_edits(array, array_elements(array), encode(value))
+      end
+
+      def rename_edits(entry, new_key)
+        part = key_part_nodes(entry.key).last
+        [[part.start_byte, part.end_byte, encode_key(new_key)]]
+      end
+
+      private
+
+      def sections
+        @sections ||= begin
+          counts = Hash.new(0)
+          list = [Section.new(path: [], node: root, pairs: pairs_in(root), array: false)]
+
+          root.named_children.each do |child|
+            next unless table?(child)
+
+            keys = key_parts(header_key(child))
+            array = child.kind == "table_array_element"
+            path = array ? keys + [counts[keys].to_s] : keys
+            counts[keys] += 1 if array
+            list << Section.new(path: path, node: child, pairs: pairs_in(child), array: array)
+          end
+          list
+        end
+      end
+
+      def section_entry(section)
+        Entry.new(
+          name: section.path.last,
+          key: section.array ? nil : header_key(section.node),
+          value: section.node,
+          member: section.node,
+          container: root,
+        )
+      end
+
+      def find_in_pairs(pairs, container, segments)
+        pairs.each do |pair|
+          key, value = pair_parts(pair)
+          parts = key_parts(key)
+          next unless segments.take(parts.length) == parts
+
+          entry = Entry.new(name: parts.last, key: key, value: value, member: pair, container: container)
+          return entry if segments.length == parts.length
+
+          found = find_in(value, segments.drop(parts.length))
+          return found if found
+        end
+        nil
+      end
+
+      def find_in(node, segments)
+        case node.kind
+        when "inline_table"
+          find_in_pairs(pairs_in(node), node, segments)
+        when "array"
+          index = segments.first.match?(/\A\d+\z/) ? segments.first.to_i : nil
+          element = index && array_elements(node)[index]
+          return unless element
+
+          entry = Entry.new(name: segments.first, key: nil, value: element, member: element, container: node)
+          segments.length == 1 ? entry : find_in(element, segments.drop(1))
+        end
+      end
+
+      # The whole document as nested Hashes
+      def document_value
+        hash = pairs_to_hash(sections.first.pairs)
+
+        sections.drop(1).each do |section|
+          keys = section.array ? section.path[0...-1] : section.path
+          parent = keys[0...-1].reduce(hash) do |current, key|
+            child = (current[key] ||= {})
+            child.is_a?(Array) ? child.last : child
+          end
+          table = if section.array
+            (parent[keys.last] ||= []) << {}
+            parent[keys.last].last
+          else
+            parent[keys.last] ||= {}
+          end
+          pairs_to_hash(section.pairs, table)
+        end
+        hash
+      end
+
+      def pairs_to_hash(pairs, hash = {})
+        pairs.each do |pair|
+          key, value = pair_parts(pair)
+          *path, last = key_parts(key)
+          target = path.reduce(hash) { |current, part| current[part] ||= {} }
+          target[last] = decode(value)
+        end
+        hash
+      end
+
+      def add_to_section_edits(segments, value)
+        section = sections
+          .select { |candidate| segments.length > candidate.path.length && segments.take(candidate.path.length) == candidate.path }
+          .max_by { |candidate| candidate.path.length }
+        rest = segments.drop(section.path.length)
+        return add_table_edits(rest[0...-1], rest.last, value) if section.path.empty? && rest.length > 1
+
+        nested = rest.drop(1).reverse.reduce(value) { |inner, key| { key => inner } }
+        line = "#{encode_key(rest.first)} = #{encode(nested)}"
+
+        if (last = section.pairs.last)
+          insert_line_edits(next_line_start(last.end_byte), "#{indentation_at(last.start_byte)}#{line}")
+        elsif table?(section.node)
+          insert_line_edits(next_line_start(header_close(section.node).end_byte), line)
+        elsif (first_table = root.named_children.find { |child| table?(child) })
+          position = line_start(first_table.start_byte)
+          [[position, position, "#{line}\n\n"]]
+        else
+          insert_line_edits(@source.bytesize, line)
+        end
+      end
+
+      # A new `[table]` at the end of the file
+      def add_table_edits(keys, key, value)
+        text = "[#{keys.map { |part| encode_key(part) }.join(".")}]\n#{encode_key(key)} = #{encode(value)}\n"
+        separator = if @source.strip.empty? || @source.end_with?("\n\n")
+          ""
+        elsif @source.end_with?("\n")
+          "\n"
+        else
+          "\n\n"
+        end
+        [[@source.bytesize, @source.bytesize, "#{separator}#{text}"]]
+      end
+
+      def insert_line_edits(position, line)
+        [[position, position, "#{newline_before(position)}#{line}\n"]]
+      end
+
+      # A newline to add when inserting at the end of a file without one
+      def newline_before(position)
+        position.positive? && @source.byteslice(position - 1) != "\n" ? "\n" : ""
+      end
+
+      # --- Nodes ---
+
+      def table?(node)
+        TABLE_KINDS.include?(node.kind)
+      end
+
+      def header_key(table)
+        table.named_children.find { |child| KEY_KINDS.include?(child.kind) }
+      end
+
+      def header_close(table)
+        table.children.find { |child| child.kind == "]" || child.kind == "]]" }
+      end
+
+      def pairs_in(node)
+        node.named_children.select { |child| child.kind == "pair" }
+      end
+
+      def pair_parts(pair)
+        key, value = pair.named_children.reject { |child| child.kind == "comment" }
+        [key, value]
+      end
+
+      def array_elements(array)
+        array.named_children.reject { |child| child.kind == "comment" }
+      end
+
+      def members(container)
+        container.kind == "inline_table" ? pairs_in(container) : array_elements(container)
+      end
+
+      def key_part_nodes(key)
+        key.kind == "dotted_key" ? key.named_children.flat_map { |part| key_part_nodes(part) } : [key]
+      end
+
+      def key_parts(key)
+        key_part_nodes(key).map { |part| part.kind == "quoted_key" ? decode_string(part.text) : part.text }
+      end
+
+      # --- Values ---
+
+      def decode_scalar(node)
+        text = node.text
+        case node.kind
+        when "string" then decode_string(text)
+        when "integer" then Integer(text.delete("_"))
+        when "float" then decode_float(text.delete("_"))
+        when "boolean" then text == "true"
+        else text # Dates and times are left as written
+        end
+      end
+
+      def decode_string(text)
+        if text.start_with?('"""')
+          unescape(text[3...-3].sub(/\A\r?\n/, "").gsub(/\\[ \t]*\r?\n\s*/, ""))
+        elsif text.start_with?("'''")
+          text[3...-3].sub(/\A\r?\n/, "")
+        elsif text.start_with?('"')
+          unescape(text[1...-1])
+        else
+          text[1...-1]
+        end
+      end
+
+      def unescape(text)
+        text.gsub(/\\(u\h{4}|U\h{8}|.)/) do
+          escape = Regexp.last_match(1)
+          escape.length > 1 ? [escape[1..].to_i(16)].pack("U") : ESCAPES.fetch(escape, escape)
+        end
+      end
+
+      def decode_float(text)
+        if text.end_with?("inf")
+          text.start_with?("-") ? -Float::INFINITY : Float::INFINITY
+        elsif text.end_with?("nan")
+          Float::NAN
+        else
+          Float(text)
+        end
+      end
+
+      # @param existing [TreeSitter::Node, nil] The value being replaced, whose quoting is kept
+      def encode(value, existing = nil)
+        case value
+        when String, Symbol then encode_string(value.to_s, existing)
+        when Integer, true, false then value.to_s
+        when Float
+          return "nan" if value.nan?
+          return value.positive? ? "inf" : "-inf" if value.infinite?
+
+          value.to_s
+        when Hash
+          return "{}" if value.empty?
+
+          "{ #{value.map { |key, item| "#{encode_key(key.to_s)} = #{encode(item)}" }.join(", ")} }"
+        when Array
+          "[#{value.map { |item| encode(item) }.join(", ")}]"
+        when nil
+          raise ArgumentError, "TOML has no null value; delete the key instead"
+        else
+          raise ArgumentError, "Cannot write a #{value.class} to TOML"
+        end
+      end
+
+      def encode_string(value, existing)
+        literal = existing&.kind == "string" && existing.text.start_with?("'") && !existing.text.start_with?("'''")
+        return "'#{value}'" if literal && !value.match?(/['\x00-\x08\x0a-\x1f\x7f]/)
+
+        # JSON string escapes are all valid in TOML basic strings
+        JSON.generate(value)
+      end
+
+      def encode_key(key)
+        key.match?(/\A[A-Za-z0-9_-]+\z/) ? key : JSON.generate(key)
+      end
+    end
+  end
+end
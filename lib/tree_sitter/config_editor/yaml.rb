@@ -0,0 +1,329 @@
+# frozen_string_literal: true
+
+require "json"
+
+module TreeSitter
+  class ConfigEditor
+    # YAML adapter (first document of the stream only)
+    #
+    # Block collections are edited line by line at the indentation of their
+    # existing members; flow collections (`{...}` and `[...]`) like JSON.
+    # Strings keep the quoting style of the scalar they replace, and new
+    # strings are written plain unless that would change their meaning.
+    class Yaml < Format
+      WRAPPER_KINDS = ["block_node", "flow_node"].freeze
+      DECORATION_KINDS = ["anchor", "tag", "comment"].freeze
+      ESCAPES = {
+        "0" => "\0",
+        "a" => "\a",
+        "b" => "\b",
+        "t" => "\t",
+        "\t" => "\t",
+        "n" => "\n",
+        "v" => "\v",
+        "f" => "\f",
+        "r" => "\r",
+        "e" => "\e",
+        " " => " ",
+        '"' => '"',
+        "/" => "/",
+        "\\" => "\\",
+        "N" => "\u0085",
+        "_" => "\u00a0",
+        "L" => "\u2028",
+        "P" => "\u2029",
+      }.freeze
+      # Plain scalars that a YAML 1.1 or 1.2 parser would not read as a string
+      NON_STRING_PATTERN = /\A(?:
+        ~|null|Null|NULL|
+        true|True|TRUE|false|False|FALSE|
+        y|Y|yes|Yes|YES|n|N|no|No|NO|on|On|ON|off|Off|OFF|
+        [-+]?[0-9][0-9_]*|0o[0-7]+|0x\h+|
+        [-+]?(?:\.[0-9]+|[0-9]+(?:\.[0-9]*)?)(?:[eE][-+]?[0-9]+)?|
+        [-+]?\.(?:inf|Inf|INF)|\.(?:nan|NaN|NAN)
+      )\z/x
+      private_constant :WRAPPER_KINDS, :DECORATION_KINDS, :ESCAPES, :NON_STRING_PATTERN
+
+      def root
+        document = @tree.root_node.named_children.find { |child| child.kind == "document" }
+        document && unwrap(document.named_children.find { |child| WRAPPER_KINDS.include?(child.kind) })
+      end
+
+      def set_edits(entry, value)
+        target = entry.value
+
+        if flow?(entry.container)
+          return [[target.start_byte, target.end_byte, flow(value, target)]] if target
+
+          [[entry.key.end_byte, entry.key.end_byte, ": #{flow(value)}"]]
+        elsif composite?(value)
+          start = marker(entry.member).end_byte
+          [[start, target ? target.end_byte : start, block_value(value, entry.member)]]
+        elsif target && !["block_mapping", "block_sequence"].include?(target.kind)
+          [[target.start_byte, target.end_byte, scalar(value, target)]]
+        else
+          start = marker(entry.member).end_byte
+          [[start, target ? target.end_byte : start, " #{scalar(value)}"]]
+        end
+      end
+
+      def add_member_edits(mapping, key, value)
+        members = members(mapping)
+        return add_to_collection_edits(mapping, members, "#{scalar(key)}: #{flow(value)}") if flow?(mapping)
+
+        indent = " " * mapping.start_point.column
+        line = "#{indent}#{scalar(key)}:"
+        line += composite?(value) ? "\n#{block_lines(value, indent + indent_unit).join("\n")}" : " #{scalar(value)}"
+        insert_line_edits(next_line_start(members.last.end_byte), line)
+      end
+
+      def delete_edits(entry)
+        container = entry.container
+        member = entry.member
+        return remove_member_edits(container, members(container), member) if flow?(container)
+
+        following = members(container).find { |candidate| candidate.start_byte > member.start_byte }
+        if first_on_line?(member)
+          [[line_start(member.start_byte), next_line_start(member.end_byte), ""]]
+        elsif following
+          # The first key of a mapping that starts on a `- ` line
+          [[member.start_byte, following.start_byte, ""]]
+        else
+          [[member.start_byte, member.end_byte, ""]]
+        end
+      end
+
+      def append_edits(entry, value)
+        sequence = entry.value
+        return set_edits(entry, [value]) if sequence.nil? && entry.key
+        raise ArgumentError, "#{entry.name} is not a list" unless container_kind(sequence) == :sequence
+
+        members = members(sequence)
+        return add_to_collection_edits(sequence, members, flow(value)) if flow?(sequence)
+
+        indent = " " * sequence.start_point.column
+        insert_line_edits(next_line_start(members.last.end_byte), "#{indent}-#{item_value(value, indent)}")
+      end
+
+      def rename_edits(entry, new_key)
+        [[entry.key.start_byte, entry.key.end_byte, scalar(new_key, entry.key)]]
+      end
+
+      private
+
+      def entries(node)
+        case node&.kind
+        when "block_mapping", "flow_mapping"
+          node.named_children.filter_map { |pair| mapping_entry(pair, node) }
+        when "block_sequence", "flow_sequence"
+          members(node).each_with_index.map do |item, index|
+            value = item.kind == "block_sequence_item" ? unwrap(content(item)) : unwrap(item)
+            Entry.new(name: index.to_s, key: nil, value: value, member: item, container: node)
+          end
+        else
+          []
+        end
+      end
+
+      def mapping_entry(pair, mapping)
+        case pair.kind
+        when "block_mapping_pair", "flow_pair"
+          key = unwrap(pair.child_by_field_name("key"))
+          return unless key
+
+          value = unwrap(pair.child_by_field_name("value"))
+          Entry.new(name: decode_scalar(key).to_s, key: key, value: value, member: pair, container: mapping)
+        when "flow_node"
+          # A key without a value in a flow mapping: `{ a, b: 1 }`
+          key = unwrap(pair)
+          Entry.new(name: decode_scalar(key).to_s, key: key, value: nil, member: pair, container: mapping)
+        end
+      end
+
+      def members(node)
+        node.named_children.reject { |child| child.kind == "comment" }
+      end
+
+      def container_kind(node)
+        case node&.kind
+        when "block_mapping", "flow_mapping" then :mapping
+        when "block_sequence", "flow_sequence" then :sequence
+        end
+      end
+
+      def flow?(node)
+        ["flow_mapping", "flow_sequence"].include?(node.kind)
+      end
+
+      # The content of a block_node or flow_node, past any anchor or tag
+      def unwrap(node)
+        node = content(node) while node && WRAPPER_KINDS.include?(node.kind)
+        node
+      end
+
+      def content(node)
+        node.named_children.find { |child| !DECORATION_KINDS.include?(child.kind) }
+      end
+
+      # The ":" of a pair or the "-" of a sequence item
+      def marker(member)
+        member.children.find { |child| child.kind == ":" || child.kind == "-" }
+      end
+
+      def insert_line_edits(position, line)
+        prefix = position.positive? && @source.byteslice(position - 1) != "\n" ? "\n" : ""
+        [[position, position, "#{prefix}#{line}\n"]]
+      end
+
+      # Default to two spaces rather than four when nothing is indented yet
+      def indent_unit
+        @source.match?(/^ +\S/) ? super : "  "
+      end
+
+      # --- Decoding ---
+
+      def decode_scalar(node)
+        text = node.text
+        case node.kind
+        when "plain_scalar" then decode_plain(node.named_children.first || node)
+        when "double_quote_scalar" then unescape(fold(text[1...-1]))
+        when "single_quote_scalar" then fold(text[1...-1]).gsub("''", "'")
+        when "block_scalar" then decode_block_scalar(text)
+        else text
+        end
+      end
+
+      def decode_plain(node)
+        text = node.text
+        case node.kind
+        when "null_scalar" then nil
+        when "boolean_scalar" then text.downcase == "true"
+        when "integer_scalar" then text.match?(/\A[-+]?[0-9]+\z/) ? text.to_i : Integer(text)
+        when "float_scalar" then decode_float(text)
+        else fold(text)
+        end
+      end
+
+      def decode_float(text)
+        if text.downcase.end_with?(".inf")
+          text.start_with?("-") ? -Float::INFINITY : Float::INFINITY
+        elsif text.downcase == ".nan"
+          Float::NAN
+        else
+          Float(text)
+        end
+      end
+
+      # Line folding of multi-line flow scalars: a single line break
+      # becomes a space, and each further empty line a newline
+      def fold(text)
+        text.gsub(/[ \t]*\r?\n(?:[ \t]*\r?\n)*[ \t]*/) do |breaks|
+          count = breaks.count("\n")
+          count == 1 ? " " : "\n" * (count - 1)
+        end
+      end
+
+      def unescape(text)
+        text.gsub(/\\(x\h{2}|u\h{4}|U\h{8}|.)/m) do
+          escape = Regexp.last_match(1)
+          escape.length > 1 ? [escape[1..].to_i(16)].pack("U") : ESCAPES.fetch(escape, escape)
+        end
+      end
+
+      def decode_block_scalar(text)
+        header, *lines = text.lines
+        indent = lines.reject { |line| line.strip.empty? }.map { |line| line[/\A */].length }.min || 0
+        body = lines.map { |line| line.strip.empty? ? "\n" : line[indent..] }.join
+        body += "\n" unless body.end_with?("\n")
+        # Folded scalars join lines, except around empty and more indented lines
+        body = body.gsub(/(?<=[^\n])\n(?=[^\n ])/, " ") if header.start_with?(">")
+
+        case header[/[+-]/]
+        when "-" then body.sub(/\n+\z/, "")
+        when "+" then body
+        else body.sub(/\n+\z/, "\n")
+        end
+      end
+
+      # --- Encoding ---
+
+      def composite?(value)
+        (value.is_a?(Hash) || value.is_a?(Array)) && !value.empty?
+      end
+
+      # @param existing [TreeSitter::Node, nil] The scalar being replaced, whose quoting is kept
+      def scalar(value, existing = nil)
+        case value
+        when nil then "null"
+        when true, false, Integer then value.to_s
+        when Float
+          return ".nan" if value.nan?
+          return value.positive? ? ".inf" : "-.inf" if value.infinite?
+
+          value.to_s
+        when String, Symbol then string(value.to_s, existing)
+        when Hash, Array then flow(value)
+        else raise ArgumentError, "Cannot write a #{value.class} to YAML"
+        end
+      end
+
+      def string(value, existing)
+        style = existing&.kind
+        if style == "single_quote_scalar" && !value.match?(/[\n\r\t]/)
+          "'#{value.gsub("'", "''")}'"
+        elsif style == "double_quote_scalar" || !plain?(value)
+          # JSON string escapes are all valid in double-quoted YAML
+          JSON.generate(value)
+        else
+          value
+        end
+      end
+
+      def plain?(value)
+        !value.empty? &&
+          !value.match?(/\A[\s\-?:,\[\]{}#&*!|>'"%@`]/) &&
+          !value.match?(/[\s:]\z|: | #|[\n\r\t,\[\]{}]/) &&
+          !value.match?(NON_STRING_PATTERN)
+      end
+
+      def flow(value, existing = nil)
+        case value
+        when Hash then "{#{value.map { |key, item| "#{scalar(key.to_s)}: #{flow(item)}" }.join(", ")}}"
+        when Array then "[#{value.map { |item| flow(item) }.join(", ")}]"
+        else scalar(value, existing)
+        end
+      end
+
+      # A composite value written after the ":" or "-" of a block member
+      def block_value(value, member)
+        indent = " " * member.start_point.column
+        return item_value(value, indent) if member.kind == "block_sequence_item"
+
+        "\n#{block_lines(value, indent + indent_unit).join("\n")}"
+      end
+
+      # What follows the "-" of a sequence item at indent; mappings and
+      # lists start on the same line (`- name: x`)
+      def item_value(value, indent)
+        return " #{scalar(value)}" unless composite?(value)
+
+        nested = "#{indent}  "
+        " #{block_lines(value, nested).join("\n").delete_prefix(nested)}"
+      end
+
+      def block_lines(value, indent)
+        if value.is_a?(Hash)
+          value.map do |key, item|
+            if composite?(item)
+              "#{indent}#{scalar(key.to_s)}:\n#{block_lines(item, indent + indent_unit).join("\n")}"
+            else
+              "#{indent}#{scalar(key.to_s)}: #{scalar(item)}"
+            end
+          end
+        else
+          value.map { |item| "#{indent}-#{item_value(item, indent)}" }
+        end
+      end
+    end
+  end
+end
@@ -3,6 +3,80 @@
 module TreeSitter
   # Formatting utilities for syntax-aware code manipulation
   module Formatting
+    # Kinds of nodes whose lines are text rather than code, per language:
+    # string literals (including raw, template and verbatim strings) and
+    # heredocs
+    VERBATIM_KINDS = {
+      "c_sharp" => ["string_literal", "verbatim_string_literal", "raw_string_literal"],
+      "go" => ["interpreted_string_literal", "raw_string_literal"],
+      "java" => ["string_literal"],
+      "javascript" => ["string", "template_string"],
+      "json" => ["string"],
+      "php" => ["string", "encapsed_string", "heredoc", "nowdoc"],
+      "python" => ["string"],
+      "ruby" => ["string", "heredoc_body"],
+      "rust" => ["string_literal", "raw_string_literal"],
+      "toml" => ["string"],
+      "typescript" => ["string", "template_string"],
+      "yaml" => ["block_scalar", "double_quote_scalar", "single_quote_scalar"],
+    }.freeze
+
+    # Kinds for languages not in VERBATIM_KINDS
+    DEFAULT_VERBATIM_KINDS = ["string", "string_literal", "raw_string_literal", "template_string", "heredoc_body"].freeze
+
+    # Kinds that start at the beginning of a line, so that line is text too
+    LINE_START_KINDS = ["heredoc_body"].freeze
+
+    class << self
+      # Byte ranges of the multi-line string literals and heredocs in a node
+      # or tree, relative to `base`
+      #
+      # Lines that start inside one of these are part of the string's value,
+      # so indentation operations leave them alone.
+      #
+      # @param node [TreeSitter::Node, TreeSitter::Tree] The node or tree to search
+      # @param base [Integer, nil] Byte offset the ranges are relative to (e.g. the start of the node's
+      #   text); defaults to the node's start, or 0 for a tree
+      # @param language [String, TreeSitter::Language, nil] Language of the node; defaults to the tree's
+      # @return [Array<::Range>]
+      def verbatim_ranges(node, base: nil, language: nil)
+        if node.is_a?(TreeSitter::Tree)
+          language ||= node.language
+          node = node.root_node
+          base ||= 0
+        end
+        base ||= node.start_byte
+        kinds = verbatim_kinds(language)
+
+        ranges = []
+        stack = [node]
+        until stack.empty?
+          current = stack.pop
+          if kinds.include?(current.kind) && current.start_point.row != current.end_point.row
+            start_byte = LINE_START_KINDS.include?(current.kind) ? current.start_byte - 1 : current.start_byte
+            ranges << ((start_byte - base)...(current.end_byte - base))
+          else
+            stack.concat(current.children.reverse)
+          end
+        end
+        ranges
+      end
+
+      # @param ranges [Array<::Range>] Ranges from #verbatim_ranges
+      # @param line_start [Integer] Byte offset of the start of a line
+      # @return [Boolean] True if the line starts inside one of the ranges
+      def verbatim_line?(ranges, line_start)
+        ranges.any? { |range| range.begin < line_start && line_start < range.end }
+      end
+
+      # @param language [String, TreeSitter::Language, nil] A language
+      # @return [Array<String>] The node kinds #verbatim_ranges looks for in it
+      def verbatim_kinds(language)
+        name = language.is_a?(TreeSitter::Language) ? language.name : language&.to_s
+        VERBATIM_KINDS.fetch(name, DEFAULT_VERBATIM_KINDS)
+      end
+    end
+
     # Detects and works with indentation in source code
     class IndentationDetector
       # Characters considered whitespace for indentation
@@ -12,10 +86,20 @@ module TreeSitter
 
       # Initialize detector with source code
       #
+      # With a tree, lines inside multi-line strings and heredocs are left
+      # alone by #retab and do not count towards the detected style. With a
+      # tree or a language, #indent, #dedent, #adjust_indentation and
+      # #reindent parse the text they are given to leave its strings alone.
+      #
       # @param source [String] The source code to analyze
-      def initialize(source)
+      # @param tree [TreeSitter::Tree, nil] Its syntax tree
+      # @param language [String, TreeSitter::Language, nil] Its language (defaults to the tree's)
+      def initialize(source, tree: nil, language: nil)
         @source = source
         @lines = source.lines
+        @verbatim = tree ? Formatting.verbatim_ranges(tree) : []
+        language ||= tree&.language
+        @language = language.is_a?(TreeSitter::Language) ? language.name : language&.to_s
         detect
       end
 
@@ -27,7 +111,7 @@ module TreeSitter
         tab_count = 0
         space_count = 0
 
-        @lines.each do |line|
+        each_code_line(@lines, @verbatim) do |line|
           next if line.strip.empty?
 
           leading = line[/\A[ \t]*/]
@@ -119,69 +203,96 @@ module TreeSitter
       # @param content [String] Content to adjust
       # @param target_level [Integer] Target indentation level
       # @param current_level [Integer, nil] Current base level (auto-detected if nil)
+      # @param verbatim [Array<::Range>, TreeSitter::Node, TreeSitter::Tree, nil] Byte ranges of the content
+      #   whose lines are left alone, or the node or tree the content is the text of (nil parses the content)
       # @return [String] Re-indented content
-      def adjust_indentation(content, target_level, current_level: nil)
+      def adjust_indentation(content, target_level, current_level: nil, verbatim: nil)
         content_lines = content.lines
         return content if content_lines.empty?
 
+        verbatim = verbatim_ranges(verbatim, content)
+
         # Auto-detect current level from first non-empty line
         if current_level.nil?
           first_content_line = content_lines.find { |l| !l.strip.empty? }
-          if first_content_line
-            leading = first_content_line[/\A[ \t]*/] || ""
-            current_level = if @style == :tabs
-              leading.count("\t")
-            else
-              leading.length / [@indent_size, 1].max
-            end
-          else
-            current_level = 0
-          end
+          current_level = first_content_line ? line_level(first_content_line) : 0
         end
 
         level_diff = target_level - current_level
 
-        content_lines.map do |line|
+        map_code_lines(content_lines, verbatim) do |line|
           if line.strip.empty?
             line
           else
             leading = line[/\A[ \t]*/] || ""
             rest = line[leading.length..]
 
-            # Calculate this line's level relative to base
-            line_level = if @style == :tabs
-              leading.count("\t")
-            else
-              leading.length / [@indent_size, 1].max
-            end
-
-            # Apply the level difference
-            new_level = [line_level + level_diff, 0].max
+            # Apply the level difference to this line's level
+            new_level = [line_level(line) + level_diff, 0].max
             indent_string_for_level(new_level) + rest
           end
-        end.join
+        end
+      end
+
+      # Re-indent text to be inserted at a byte position
+      #
+      # The first line continues the line the text is inserted in. Every
+      # other line keeps its indentation relative to `from`, the indentation
+      # the text was written at, on top of the indentation of the line at
+      # byte_pos. Blank lines are emptied, except a last one that is
+      # followed by the rest of the line at end_byte.
+      #
+      # @param content [String] Text to insert
+      # @param byte_pos [Integer] Where it is inserted
+      # @param end_byte [Integer] End of the text it replaces
+      # @param from [String] The indentation the text was written at ("" for column zero)
+      # @param verbatim [Array<::Range>, TreeSitter::Node, TreeSitter::Tree, nil] Byte ranges of the content
+      #   whose lines are left alone, or the node or tree the content is the text of (nil parses the content)
+      # @return [String] Re-indented content
+      # @example
+      #   detector.reindent("if ready {\n    go();\n}", node.start_byte)
+      def reindent(content, byte_pos, end_byte: byte_pos, from: "", verbatim: nil)
+        indent = indentation_at_byte(byte_pos)
+        lines = content.split("\n", -1)
+        rest_of_line = !["\n", "\r", "", nil].include?(@source.byteslice(end_byte, 1))
+        line_starts = lines.each_with_object([0]) { |line, starts| starts << starts.last + line.bytesize + 1 }
+        verbatim = verbatim_ranges(verbatim, content)
+
+        lines.each_with_index.map do |line, index|
+          if index.zero? || Formatting.verbatim_line?(verbatim, line_starts[index])
+            line
+          elsif line.strip.empty?
+            index == lines.length - 1 && rest_of_line ? indent : ""
+          else
+            indent + (line.start_with?(from) ? line.delete_prefix(from) : line.lstrip)
+          end
+        end.join("\n")
       end
 
       # Increase indentation of all lines by one level
       #
       # @param content [String] Content to indent
+      # @param verbatim [Array<::Range>, TreeSitter::Node, TreeSitter::Tree, nil] Byte ranges of the content
+      #   whose lines are left alone, or the node or tree the content is the text of (nil parses the content)
       # @return [String] Indented content
-      def indent(content)
-        content.lines.map do |line|
+      def indent(content, verbatim: nil)
+        map_code_lines(content.lines, verbatim_ranges(verbatim, content)) do |line|
           if line.strip.empty?
             line
           else
             @indent_string + line
           end
-        end.join
+        end
       end
 
       # Decrease indentation of all lines by one level
       #
       # @param content [String] Content to dedent
+      # @param verbatim [Array<::Range>, TreeSitter::Node, TreeSitter::Tree, nil] Byte ranges of the content
+      #   whose lines are left alone, or the node or tree the content is the text of (nil parses the content)
       # @return [String] Dedented content
-      def dedent(content)
-        content.lines.map do |line|
+      def dedent(content, verbatim: nil)
+        map_code_lines(content.lines, verbatim_ranges(verbatim, content)) do |line|
           if line.strip.empty?
             line
           elsif @style == :tabs && line.start_with?("\t")
@@ -191,11 +302,82 @@ module TreeSitter
           else
             line
           end
-        end.join
+        end
+      end
+
+      # Convert the indentation of the whole source between tabs and spaces
+      #
+      # Only leading whitespace is changed. A tab counts as `size` columns, and
+      # columns left over after whole levels stay spaces (e.g. for alignment).
+      # Lines inside multi-line strings and heredocs are left alone if the
+      # detector was given a tree.
+      #
+      # @param style [Symbol] :spaces or :tabs
+      # @param size [Integer, nil] Columns per level (default: the detected size, or 4 for tabs)
+      # @return [String] The converted source
+      def retab(style, size: nil)
+        raise ArgumentError, "Unknown indentation style #{style.inspect} (expected :spaces or :tabs)" unless [:spaces, :tabs].include?(style)
+
+        size ||= @style == :spaces ? @indent_size : 4
+        map_code_lines(@lines, @verbatim) do |line|
+          leading = line[/\A[ \t]*/]
+          next line if leading.empty? || line.strip.empty?
+
+          width = leading.each_char.reduce(0) { |column, char| char == "\t" ? (column / size + 1) * size : column + 1 }
+          indentation = style == :tabs ? ("\t" * (width / size)) + (" " * (width % size)) : " " * width
+          indentation + line[leading.length..]
+        end
       end
 
       private
 
+      def line_level(line)
+        leading = line[/\A[ \t]*/] || ""
+        @style == :tabs ? leading.count("\t") : leading.length / [@indent_size, 1].max
+      end
+
+      # Byte ranges of content whose lines are left alone: the given ranges,
+      # those of the given node or tree, or those of the parsed content
+      def verbatim_ranges(verbatim, content)
+        case verbatim
+        when nil
+          tree = content_parser&.parse(content)
+          tree ? Formatting.verbatim_ranges(tree) : []
+        when TreeSitter::Tree then Formatting.verbatim_ranges(verbatim)
+        when TreeSitter::Node then Formatting.verbatim_ranges(verbatim, language: @language)
+        else verbatim
+        end
+      end
+
+      # A parser for the detector's language, if it has one
+      def content_parser
+        return unless @language
+
+        @content_parser ||= TreeSitter::Parser.new.tap { |parser| parser.language = @language }
+      rescue StandardError
+        nil
+      end
+
+      # Yield each line (with its line ending) that does not start inside a
+      # verbatim range
+      def each_code_line(lines, verbatim)
+        line_start = 0
+        lines.each do |line|
+          yield line unless Formatting.verbatim_line?(verbatim, line_start)
+          line_start += line.bytesize
+        end
+      end
+
+      # Map the lines that do not start inside a verbatim range, keep the others
+      def map_code_lines(lines, verbatim)
+        line_start = 0
+        lines.map do |line|
+          mapped = Formatting.verbatim_line?(verbatim, line_start) ? line : yield(line)
+          line_start += line.bytesize
+          mapped
+        end.join
+      end
+
       # Detect the most common space indent size
       def detect_space_indent_size(indents)
         return 4 if indents.empty?
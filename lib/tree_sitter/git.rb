@@ -0,0 +1,127 @@
+# frozen_string_literal: true
+
+require "open3"
+require "time"
+
+module TreeSitter
+  # Helpers for working with the local git repository.
+  #
+  # @example Only touch code changed on this branch
+  #   ranges = TreeSitter::Git.changed_ranges(".", path: "src/main.rs")
+  #   TreeSitter::QueryRewriter.new(source, tree, only_within: ranges)
+  #     .query('(call_expression function: (identifier) @fn)')
+  #     .replace("@fn") { |node| node.text.sub("old_", "new_") }
+  #     .rewrite
+  #
+  module Git
+    # A commit, as listed by `git log`
+    Commit = Struct.new(:sha, :author, :date, :subject, keyword_init: true)
+
+    # Separates the fields of a commit in `git log` output
+    FIELD_SEPARATOR = "\x1f"
+
+    # Matches the line span on the new side of a unified diff hunk header
+    HUNK_HEADER = /^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@/
+
+    class << self
+      # Lines of a file that differ from another revision
+      #
+      # Runs `git diff` between `base` and the working tree and converts each
+      # hunk into a range of whole lines of the current file. Lines that were
+      # only deleted become an empty range at the position they were removed
+      # from. Untracked files have no diff and yield no ranges.
+      #
+      # @param repo [String] Path inside the repository
+      # @param base [String] The revision to compare against
+      # @param path [String] The file, relative to repo
+      # @return [Array<TreeSitter::Range>] Changed ranges, in order
+      # @raise [TreeSitter::Error] If git fails, or the file does not exist (it was deleted)
+      def changed_ranges(repo, base: "origin/main", path:)
+        full_path = File.expand_path(path, repo)
+        raise TreeSitter::Error, "#{path} does not exist in the working tree" unless File.file?(full_path)
+
+        output = git(repo, "diff", "--unified=0", "--no-color", "--no-ext-diff", base, "--", path)
+        ranges_from_diff(output, File.read(full_path))
+      end
+
+      # Commits that touched a file, newest first
+      #
+      # @param repo [String] Path inside the repository
+      # @param path [String] The file, relative to repo
+      # @param rev [String] The revision to start from
+      # @param max_count [Integer, nil] Stop after this many commits
+      # @return [Array<Commit>]
+      # @raise [TreeSitter::Error] If git fails
+      def commits(repo, path, rev: "HEAD", max_count: nil)
+        format = ["%H", "%an", "%aI", "%s"].join(FIELD_SEPARATOR)
+        args = ["log", "--format=#{format}", "--no-color"]
+        args << "--max-count=#{max_count}" if max_count
+        output = git(repo, *args, rev, "--", path)
+
+        output.each_line.map do |line|
+          sha, author, date, subject = line.chomp.split(FIELD_SEPARATOR, 4)
+          Commit.new(sha: sha, author: author, date: Time.iso8601(date), subject: subject.to_s)
+        end
+      end
+
+      # The contents of a file at a revision
+      #
+      # @param repo [String] Path inside the repository
+      # @param rev [String] The revision
+      # @param path [String] The file, relative to repo
+      # @return [String, nil] The contents, or nil if the file did not exist
+      def file_at(repo, rev, path)
+        git(repo, "show", "#{rev}:./#{path}")
+      rescue TreeSitter::Error
+        nil
+      end
+
+      # Convert the hunks of a unified diff into ranges of the new text
+      #
+      # @param diff [String] Output of `git diff` for a single file
+      # @param source [String] The new version of the file
+      # @return [Array<TreeSitter::Range>]
+      def ranges_from_diff(diff, source)
+        lines = source.lines
+        line_starts = lines.each_with_object([0]) { |line, starts| starts << (starts.last + line.bytesize) }
+
+        diff.b.each_line.filter_map do |line|
+          match = HUNK_HEADER.match(line)
+          next unless match
+
+          first = match[1].to_i
+          count = match[2] ? match[2].to_i : 1
+
+          if count.zero?
+            # Lines were removed after line `first` (1-based), so the
+            # change sits at the start of 0-based row `first`
+            row = [first, lines.length].min
+            point = TreeSitter::Point.new(row, 0)
+            next TreeSitter::Range.new(line_starts[row], line_starts[row], point, point)
+          end
+
+          first_row = first - 1
+          last_row = [first_row + count - 1, lines.length - 1].min
+          next if first_row > last_row
+
+          end_column = lines[last_row].chomp.bytesize
+          TreeSitter::Range.new(
+            line_starts[first_row],
+            line_starts[last_row] + end_column,
+            TreeSitter::Point.new(first_row, 0),
+            TreeSitter::Point.new(last_row, end_column),
+          )
+        end
+      end
+
+      private
+
+      def git(repo, *args)
+        output, errors, status = Open3.capture3("git", "-C", repo, *args)
+        raise TreeSitter::Error, "git #{args.first} failed: #{errors.strip}" unless status.success?
+
+        output
+      end
+    end
+  end
+end
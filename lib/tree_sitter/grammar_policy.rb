@@ -0,0 +1,130 @@
+# frozen_string_literal: true
+
+require "digest"
+
+module TreeSitter
+  # Restricts the grammar libraries TreeSitter.register_language loads.
+  #
+  # Registering a language loads a shared library and runs its
+  # `tree_sitter_<name>` function, so on shared machines only trusted
+  # libraries should be registered. A policy limits them to libraries in
+  # `allowed_dirs` (after resolving symlinks), and checks the SHA-256 of the
+  # languages listed in `sha256`. With `require_checksum`, languages without
+  # a listed checksum are refused too. The allowed directories should not be
+  # writable by anyone untrusted, since the library is read again when it is
+  # loaded.
+  #
+  # @example
+  #   TreeSitter.grammar_policy = {
+  #     allowed_dirs: ["/opt/grammars"],
+  #     sha256: { "rust" => "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" },
+  #   }
+  #   TreeSitter.register_language("rust", "/tmp/evil.so") # raises TreeSitter::GrammarPolicyError
+  #
+  class GrammarPolicy
+    attr_reader :allowed_dirs, :sha256, :require_checksum
+
+    # @param allowed_dirs [Array<String>, nil] Directories libraries must be in (nil allows any)
+    # @param sha256 [Hash{String => String}] Expected SHA-256 hex digests by language name
+    # @param require_checksum [Boolean] True to refuse languages without an expected digest
+    def initialize(allowed_dirs: nil, sha256: {}, require_checksum: false)
+      @allowed_dirs = allowed_dirs&.map { |dir| resolve(dir) }
+      @sha256 = sha256.to_h { |name, digest| [name.to_s, digest.to_s.downcase] }
+      @require_checksum = require_checksum
+    end
+
+    # Check a library against the policy
+    #
+    # @param name [String] The language name
+    # @param library_path [String] Path of the library
+    # @return [Array(String, String)] The resolved path of the library, to load, and its SHA-256 hex digest
+    # @raise [GrammarPolicyError] If the library is missing or not allowed
+    def check(name, library_path)
+      path = File.expand_path(library_path)
+      raise GrammarPolicyError, "Grammar library '#{library_path}' does not exist" unless File.file?(path)
+
+      path = File.realpath(path)
+      if @allowed_dirs && @allowed_dirs.none? { |dir| path.start_with?(dir.end_with?(File::SEPARATOR) ? dir : dir + File::SEPARATOR) }
+        raise GrammarPolicyError, "Grammar library '#{path}' for #{name} is outside the allowed directories"
+      end
+
+      expected = @sha256[name.to_s]
+      raise GrammarPolicyError, "No checksum is listed for the #{name} grammar" if expected.nil? && @require_checksum
+
+      actual = Digest::SHA256.file(path).hexdigest
+      if expected && actual != expected
+        raise GrammarPolicyError, "Checksum mismatch for #{name} grammar '#{path}': expected #{expected}, got #{actual}"
+      end
+
+      [path, actual]
+    end
+
+    private
+
+    def resolve(dir)
+      path = File.expand_path(dir)
+      File.directory?(path) ? File.realpath(path) : path
+    end
+  end
+
+  class << self
+    # @return [GrammarPolicy, nil] The policy libraries are checked against, if any
+    attr_reader :grammar_policy
+
+    # Set the policy for registering languages
+    #
+    # @param policy [GrammarPolicy, Hash, nil] A policy, its keyword arguments, or nil for none
+    # @raise [ArgumentError] If the policy has unknown keys
+    def grammar_policy=(policy)
+      @grammar_policy = case policy
+      when GrammarPolicy, nil then policy
+      when Hash then GrammarPolicy.new(**policy.transform_keys(&:to_sym))
+      else raise ArgumentError, "Expected a GrammarPolicy, Hash or nil, got #{policy.class}"
+      end
+    end
+
+    # Register a language from a shared library
+    #
+    # The library must pass the grammar policy, if one is set. Its SHA-256
+    # is recorded for Language#checksum.
+    #
+    # @param name [String] The language name; the library must export `tree_sitter_<name>`
+    # @param library_path [String] Path of the library
+    # @return [nil]
+    # @raise [GrammarPolicyError] If the library is not allowed
+    # @raise [RuntimeError] If the library or its language function cannot be loaded
+    def register_language(name, library_path)
+      name = name.to_s
+      path, checksum = @grammar_policy ? @grammar_policy.check(name, library_path) : unchecked(library_path.to_s)
+      load_language_library(name, path)
+      grammar_checksums[name] = checksum
+      nil
+    end
+
+    # @param name [String] A registered language name
+    # @return [String, nil] SHA-256 hex digest of its library, taken when it was registered
+    def grammar_checksum(name)
+      grammar_checksums[name.to_s]
+    end
+
+    private :load_language_library
+
+    private
+
+    def grammar_checksums
+      @grammar_checksums ||= {}
+    end
+
+    # Path and digest of a library registered without a policy
+    def unchecked(path)
+      [path, File.file?(path) ? Digest::SHA256.file(path).hexdigest : nil]
+    end
+  end
+
+  class Language
+    # @return [String, nil] SHA-256 hex digest of the library, taken when it was registered
+    def checksum
+      TreeSitter.grammar_checksum(name)
+    end
+  end
+end
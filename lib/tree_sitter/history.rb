@@ -0,0 +1,147 @@
+# frozen_string_literal: true
+
+require "pathname"
+require_relative "git"
+require_relative "language_detector"
+require_relative "structural_diff"
+require_relative "symbols"
+
+module TreeSitter
+  # Git history of a single function, method or type, like
+  # `git log -L :funcname:file` but using the syntax tree to find the symbol.
+  #
+  # The symbol is found in every revision of the file by its qualified name,
+  # so it is followed when it moves around within the file. When the name
+  # is gone, the most similar definition of the same kind is taken instead,
+  # which follows renames; definitions whose own name is still there in the
+  # newer revision are not considered, so a copy of a sibling is not taken
+  # for a rename of it.
+  #
+  # @example
+  #   TreeSitter::History.for_symbol(".", "src/point.rs", symbol: "Point::distance").each do |revision|
+  #     puts "#{revision.commit.sha[0, 8]} #{revision.change} #{revision.commit.subject}"
+  #     puts revision.diff
+  #   end
+  #
+  module History
+    # A commit that changed the symbol
+    #
+    # Change is :added, :modified, :renamed or :reformatted, or :truncated
+    # for the oldest commit looked at when `max_count` cut the history off
+    # there (older commits were not examined, so the symbol may be older).
+    # `diff` is a unified diff of just the symbol's text against the
+    # previous revision.
+    Revision = Struct.new(:commit, :change, :name, :old_name, :text, :range, :diff, keyword_init: true)
+
+    # Minimum similarity for a differently named definition to be taken as
+    # the same symbol before a rename
+    RENAME_THRESHOLD = 0.6
+
+    class << self
+      # The commits that changed a symbol, newest first
+      #
+      # @param repo_path [String] Path inside the repository
+      # @param file [String] The file, relative to repo_path
+      # @param symbol [String] Qualified name of the symbol at `rev`, e.g. "Point::distance"
+      # @param rev [String] The revision to start from
+      # @param language [String, nil] Language of the file (default: detected from its name)
+      # @param max_count [Integer, nil] Look at no more than this many commits of the file
+      # @param context [Integer] Unchanged lines to show around each change in the diffs
+      # @return [Array<Revision>]
+      # @raise [ArgumentError] If the language is unknown or the symbol is not found at `rev`
+      # @raise [TreeSitter::Error] If git fails
+      def for_symbol(repo_path, file, symbol:, rev: "HEAD", language: nil, max_count: nil, context: 3)
+        file = relative_path(repo_path, file)
+        language ||= LanguageDetector.new(registered_only: true).detect(file)
+        raise ArgumentError, "Cannot tell the language of #{file}; pass language:" unless language
+
+        parser = TreeSitter::Parser.new
+        parser.language = language
+
+        commits = Git.commits(repo_path, file, rev: rev, max_count: max_count)
+        versions = commits.map do |commit|
+          source = Git.file_at(repo_path, commit.sha, file)
+          tree = source && parser.parse(source)
+          [commit, tree && Symbols.extract(tree)]
+        end
+
+        newest = versions.first&.last&.find { |definition| definition.qualified_name == symbol }
+        raise ArgumentError, "#{symbol} not found in #{file} at #{rev}" unless newest
+
+        truncated = max_count && commits.length >= max_count
+        trace(versions, newest, context, truncated)
+      end
+
+      private
+
+      # Walk from newest to oldest, following the symbol back until the
+      # revision that introduced it
+      def trace(versions, newest, context, truncated)
+        revisions = []
+        current = newest
+
+        versions.each_with_index do |(commit, definitions), index|
+          older = versions[index + 1]
+          previous = older&.last&.then { |older_definitions| locate(older_definitions, current, definitions) }
+          change = older.nil? && truncated ? :truncated : classify(previous, current)
+          revisions << revision(commit, change, previous, current, context) if change
+          break unless previous
+
+          current = previous
+        end
+
+        revisions
+      end
+
+      # The definition in an older revision that corresponds to `definition`
+      # of the newer revision, whose definitions are `newer`
+      def locate(definitions, definition, newer)
+        same_name = definitions.find do |candidate|
+          candidate.kind == definition.kind && candidate.qualified_name == definition.qualified_name
+        end
+        return same_name if same_name
+
+        # A definition whose name survives was not renamed
+        surviving = newer.to_a.map(&:qualified_name)
+        candidates = definitions.select do |candidate|
+          candidate.kind == definition.kind && !surviving.include?(candidate.qualified_name)
+        end
+        best = candidates.max_by { |candidate| Symbols.similarity(candidate, definition) }
+        best if best && Symbols.similarity(best, definition) >= RENAME_THRESHOLD
+      end
+
+      def classify(previous, current)
+        if previous.nil?
+          :added
+        elsif previous.qualified_name != current.qualified_name
+          :renamed
+        elsif previous.tokens != current.tokens
+          :modified
+        elsif previous.node.text != current.node.text
+          :reformatted
+        end
+      end
+
+      def revision(commit, change, previous, current, context)
+        old_text = previous ? "#{previous.node.text}\n" : ""
+        new_text = "#{current.node.text}\n"
+
+        Revision.new(
+          commit: commit,
+          change: change,
+          name: current.qualified_name,
+          old_name: previous&.qualified_name,
+          text: current.node.text,
+          range: current.node.range,
+          diff: StructuralDiff.new(old_text, new_text).render_inline(context: context),
+        )
+      end
+
+      def relative_path(repo_path, file)
+        return file unless Pathname.new(file).absolute?
+
+        Pathname.new(file).relative_path_from(Pathname.new(File.expand_path(repo_path))).to_s
+      end
+    end
+  end
+end
@@ -17,6 +17,18 @@ module TreeSitter
   #     .insert_sibling("fn new_func() {}")
   #     .rewrite
   #
+  # Insertion points can also be given as anything PositionIndex#resolve
+  # accepts: points, `[row, column]` pairs, Ruby ranges of byte offsets and
+  # Lines. Such a target has no delimiters to look inside, so #at_start_of
+  # and #before insert at its start, #at_end_of and #after at its end, at
+  # the indentation of the line it starts on.
+  #
+  # @example Insert at a line reported by a linter (one-based)
+  #   Inserter.new(source, tree, one_based: true)
+  #     .before(TreeSitter::Lines.new(12..12))
+  #     .insert_statement("// TODO: check the result")
+  #     .rewrite
+  #
   class Inserter
     # Represents a pending insertion
     Insertion = Struct.new(:byte_pos, :content, :newline_before, :newline_after, keyword_init: true)
@@ -28,11 +40,18 @@ module TreeSitter
     # @param source [String] The source code
     # @param tree [TreeSitter::Tree] The parsed syntax tree
     # @param parser [TreeSitter::Parser, nil] Optional parser for re-parsing
-    def initialize(source, tree, parser: nil)
+    # @param column_unit [Symbol] Unit of the columns of `[row, column]` targets
+    #   (:byte, :char or :utf16, see PositionIndex)
+    # @param one_based [Boolean] True if rows and columns of `[row, column]` and Lines targets count from 1
+    # @param only_within [Array<TreeSitter::Range>, nil] Ignore insertions at nodes outside these ranges
+    def initialize(source, tree, parser: nil, column_unit: :byte, one_based: false, only_within: nil)
       @source = source.dup.freeze
       @tree = tree
       @parser = parser
-      @indent_detector = Formatting::IndentationDetector.new(source)
+      @only_within = only_within
+      @column_unit = PositionIndex.validate_unit(column_unit)
+      @one_based = one_based
+      @indent_detector = Formatting::IndentationDetector.new(source, tree: tree, language: parser&.language)
       @insertions = []
       @insertion_point = nil
       @insertion_context = nil
@@ -40,13 +59,16 @@ module TreeSitter
 
     # Set insertion point at the beginning of a node's content (inside the node)
     #
-    # @param node [TreeSitter::Node] Container node (e.g., a block)
+    # @param node [TreeSitter::Node, Object] Container node (e.g., a block), or a position
     # @return [self] For method chaining
     def at_start_of(node)
+      return at_position(:inside_start, node, :start_byte) unless node.is_a?(TreeSitter::Node)
+
       # Find the first child's start, or just after the opening
       # For blocks like { ... }, we want to insert after the opening brace
       @insertion_context = :inside_start
       @insertion_node = node
+      @in_scope = within_scope?(node.range)
       @target_indent_level = @indent_detector.level_at_byte(node.start_byte) + 1
 
       # Find position just after opening delimiter
@@ -55,8 +77,8 @@ module TreeSitter
         @insertion_point = first_child.start_byte
       else
         # Empty block - find end of opening line or after opening brace
-        node_text = @source[node.start_byte...node.end_byte]
-        @insertion_point = if (brace_pos = node_text.index("{"))
+        node_text = @source.byteslice(node.start_byte...node.end_byte)
+        @insertion_point = if (brace_pos = node_text.byteindex("{"))
           node.start_byte + brace_pos + 1
         else
           node.start_byte + 1
@@ -68,16 +90,19 @@ module TreeSitter
 
     # Set insertion point at the end of a node's content (inside the node)
     #
-    # @param node [TreeSitter::Node] Container node
+    # @param node [TreeSitter::Node, Object] Container node, or a position
     # @return [self] For method chaining
     def at_end_of(node)
+      return at_position(:inside_end, node, :end_byte) unless node.is_a?(TreeSitter::Node)
+
       @insertion_context = :inside_end
       @insertion_node = node
+      @in_scope = within_scope?(node.range)
       @target_indent_level = @indent_detector.level_at_byte(node.start_byte) + 1
 
       # Find position just before closing delimiter
-      node_text = @source[node.start_byte...node.end_byte]
-      @insertion_point = if (brace_pos = node_text.rindex("}"))
+      node_text = @source.byteslice(node.start_byte...node.end_byte)
+      @insertion_point = if (brace_pos = node_text.byterindex("}"))
         node.start_byte + brace_pos
       else
         node.end_byte
@@ -88,11 +113,14 @@ module TreeSitter
 
     # Set insertion point before a node (as sibling)
     #
-    # @param node [TreeSitter::Node] Reference node
+    # @param node [TreeSitter::Node, Object] Reference node, or a position
     # @return [self] For method chaining
     def before(node)
+      return at_position(:before, node, :start_byte) unless node.is_a?(TreeSitter::Node)
+
       @insertion_context = :before
       @insertion_node = node
+      @in_scope = within_scope?(node.range)
       @insertion_point = node.start_byte
       @target_indent_level = @indent_detector.level_at_byte(node.start_byte)
       self
@@ -100,11 +128,14 @@ module TreeSitter
 
     # Set insertion point after a node (as sibling)
     #
-    # @param node [TreeSitter::Node] Reference node
+    # @param node [TreeSitter::Node, Object] Reference node, or a position
     # @return [self] For method chaining
     def after(node)
+      return at_position(:after, node, :end_byte) unless node.is_a?(TreeSitter::Node)
+
       @insertion_context = :after
       @insertion_node = node
+      @in_scope = within_scope?(node.range)
       @insertion_point = node.end_byte
       @target_indent_level = @indent_detector.level_at_byte(node.start_byte)
       self
@@ -118,6 +149,7 @@ module TreeSitter
     # @return [self] For method chaining
     def insert_statement(content, newline_before: nil, newline_after: true)
       raise "No insertion point set. Call at_start_of, at_end_of, before, or after first." unless @insertion_point
+      return self unless @in_scope
 
       # Determine newline_before based on context
       newline_before = newline_before? if newline_before.nil?
@@ -140,6 +172,7 @@ module TreeSitter
     # @return [self] For method chaining
     def insert_raw(content)
       raise "No insertion point set. Call at_start_of, at_end_of, before, or after first." unless @insertion_point
+      return self unless @in_scope
 
       @insertions << Insertion.new(
         byte_pos: @insertion_point,
@@ -157,6 +190,7 @@ module TreeSitter
     # @return [self] For method chaining
     def insert_sibling(content, separator: nil)
       raise "No insertion point set. Call before or after first." unless @insertion_point
+      return self unless @in_scope
 
       separator ||= "\n\n" # Default to blank line between top-level items
 
@@ -192,6 +226,7 @@ module TreeSitter
     # @return [self] For method chaining
     def insert_block(header, body, open_brace: " {", close_brace: "}")
       raise "No insertion point set. Call at_start_of, at_end_of, before, or after first." unless @insertion_point
+      return self unless @in_scope
 
       indent = @indent_detector.indent_string_for_level(@target_indent_level)
       body_indent = @indent_detector.indent_string_for_level(@target_indent_level + 1)
@@ -229,11 +264,7 @@ module TreeSitter
 
       result = @source.dup
       sorted.each do |insertion|
-        content = insertion.content
-        content = "\n#{content}" if insertion.newline_before
-        content = "#{content}\n" if insertion.newline_after
-
-        result.insert(insertion.byte_pos, content)
+        result.bytesplice(insertion.byte_pos, 0, insertion_text(insertion))
       end
       result
     end
@@ -251,6 +282,17 @@ module TreeSitter
       [new_source, new_tree]
     end
 
+    # Export the insertions to a ChangeSet, to combine them with the edits of
+    # other tools or save them for later
+    #
+    # @param path [String, nil] The file the source was read from
+    # @return [TreeSitter::ChangeSet]
+    # @raise [TreeSitter::ConflictError] If the edits overlap each other
+    def to_change_set(path: nil)
+      edits = @insertions.map { |insertion| [insertion.byte_pos, insertion.byte_pos, insertion_text(insertion)] }
+      ChangeSet.new.add(path, @source, edits, origin: "inserter")
+    end
+
     # Reset insertion point to allow setting a new one
     #
     # @return [self] For method chaining
@@ -258,12 +300,42 @@ module TreeSitter
       @insertion_point = nil
       @insertion_context = nil
       @insertion_node = nil
+      @in_scope = nil
       @target_indent_level = nil
       self
     end
 
     private
 
+    # Set the insertion point to one end of a target that is not a node
+    def at_position(context, target, edge)
+      range = positions.resolve(target, unit: @column_unit, one_based: @one_based)
+      @insertion_context = context
+      @insertion_node = nil
+      @in_scope = within_scope?(range)
+      @insertion_point = range.public_send(edge)
+      @target_indent_level = @indent_detector.level_at_byte(range.start_byte)
+      self
+    end
+
+    # Insertions are only kept at nodes that intersect one of the only_within ranges
+    def within_scope?(range)
+      @only_within.nil? || @only_within.any? { |scope| scope.intersects?(range) }
+    end
+
+    # The index of the source's line positions, shared by all insertion points
+    def positions
+      @positions ||= PositionIndex.new(@source)
+    end
+
+    # The inserted text, with the newlines around it
+    def insertion_text(insertion)
+      content = insertion.content
+      content = "\n#{content}" if insertion.newline_before
+      content = "#{content}\n" if insertion.newline_after
+      content
+    end
+
     def newline_before?
       case @insertion_context
       when :inside_start
@@ -273,7 +345,7 @@ module TreeSitter
       when :inside_end
         # Before closing brace, check if we need newline
         # Look at what's before the insertion point
-        before_text = @source[0...@insertion_point]
+        before_text = @source.byteslice(0...@insertion_point)
         last_newline = before_text.rindex("\n")
         content_after_newline = last_newline ? before_text[(last_newline + 1)..] : before_text
         # If there's only whitespace after the last newline, we might not need another
@@ -289,6 +361,8 @@ module TreeSitter
     def adjust_content_indentation(content)
       return content if content.strip.empty?
 
+      # The detector parses the content to leave the lines of its multi-line
+      # strings as they are
       @indent_detector.adjust_indentation(content.strip, @target_indent_level, current_level: 0)
     end
 
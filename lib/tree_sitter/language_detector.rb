@@ -0,0 +1,100 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Guesses the tree-sitter language name for a file from its name, extension
+  # or shebang line.
+  #
+  # @example
+  #   detector = TreeSitter::LanguageDetector.new
+  #   detector.detect("src/main.rs")  # => "rust"
+  #   detector.detect("bin/setup")    # => "ruby" (from "#!/usr/bin/env ruby")
+  #
+  # @example Add your own mappings
+  #   detector = TreeSitter::LanguageDetector.new(extensions: { ".toml" => "toml" })
+  #
+  class LanguageDetector
+    # File extensions (lowercase, with the dot) mapped to language names
+    EXTENSIONS = {
+      ".rs" => "rust",
+      ".rb" => "ruby",
+      ".rake" => "ruby",
+      ".gemspec" => "ruby",
+      ".py" => "python",
+      ".pyi" => "python",
+      ".js" => "javascript",
+      ".mjs" => "javascript",
+      ".cjs" => "javascript",
+      ".jsx" => "javascript",
+      ".go" => "go",
+      ".php" => "php",
+      ".java" => "java",
+      ".cs" => "c_sharp",
+      ".json" => "json",
+      ".yaml" => "yaml",
+      ".yml" => "yaml",
+      ".toml" => "toml",
+    }.freeze
+
+    # Exact file names mapped to language names
+    FILENAMES = {
+      "Gemfile" => "ruby",
+      "Rakefile" => "ruby",
+      "Cargo.lock" => "toml",
+    }.freeze
+
+    # Interpreters named on a shebang line mapped to language names
+    INTERPRETERS = {
+      "ruby" => "ruby",
+      "python" => "python",
+      "python3" => "python",
+      "node" => "javascript",
+      "php" => "php",
+    }.freeze
+
+    # Initialize a detector
+    #
+    # @param extensions [Hash{String => String}] Extra extension mappings
+    # @param filenames [Hash{String => String}] Extra file name mappings
+    # @param registered_only [Boolean] Only return languages that have been registered
+    def initialize(extensions: {}, filenames: {}, registered_only: false)
+      @extensions = EXTENSIONS.merge(extensions.transform_keys(&:downcase))
+      @filenames = FILENAMES.merge(filenames)
+      @registered_only = registered_only
+    end
+
+    # Detect the language of a file
+    #
+    # @param path [String] Path to the file
+    # @param source [String, nil] File contents, used for shebang detection (read from disk if nil)
+    # @return [String, nil] The language name, or nil if unknown
+    def detect(path, source: nil)
+      name = @filenames[File.basename(path)] ||
+        @extensions[File.extname(path).downcase] ||
+        detect_shebang(path, source)
+      return if name.nil?
+      return if @registered_only && !TreeSitter.languages.include?(name)
+
+      name
+    end
+    alias_method :call, :detect
+
+    private
+
+    def detect_shebang(path, source)
+      first_line = if source
+        source.each_line.first
+      elsif File.file?(path)
+        File.open(path, &:gets)
+      end
+      return unless first_line&.start_with?("#!")
+
+      words = first_line.delete_prefix("#!").split
+      interpreter = File.basename(words.first.to_s)
+      interpreter = words[1].to_s if interpreter == "env"
+
+      INTERPRETERS[interpreter]
+    rescue SystemCallError, ArgumentError
+      nil
+    end
+  end
+end
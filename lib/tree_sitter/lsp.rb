@@ -0,0 +1,139 @@
+# frozen_string_literal: true
+
+require "json"
+
+module TreeSitter
+  # Conversion between byte-range edits and the Language Server Protocol's
+  # `TextEdit` and `WorkspaceEdit` objects.
+  #
+  # LSP positions are zero-based lines and UTF-16 code unit offsets within
+  # the line; tree-sitter works in UTF-8 byte offsets. Lines end at "\n"
+  # (a "\r" before it is not counted as part of the line).
+  #
+  # The document can be given as a String or as a PositionIndex of it; pass
+  # an index when converting many positions of the same document.
+  #
+  # @example Send a codemod to an editor
+  #   edit = TreeSitter::Rewriter.new(source, tree).replace(node, "run").to_workspace_edit("file:///src/main.rs")
+  #   JSON.generate(edit)
+  #
+  module LSP
+    class << self
+      # @param source [String, PositionIndex] The document
+      # @param byte [Integer] A byte offset in it
+      # @return [Hash] An LSP `Position`: `{ "line" => ..., "character" => ... }`
+      def position(source, byte)
+        line, character = PositionIndex.for(source).position(byte, unit: :utf16)
+        { "line" => line, "character" => character }
+      end
+
+      # @param source [String, PositionIndex] The document
+      # @param position [Hash] An LSP `Position` (string or symbol keys)
+      # @return [Integer] The byte offset; a character past the end of the line means its end
+      def byte_offset(source, position)
+        PositionIndex.for(source).byte_offset(fetch(position, "line"), fetch(position, "character"), unit: :utf16)
+      end
+
+      # @param source [String, PositionIndex] The document
+      # @param start_byte [Integer] Start of the range
+      # @param end_byte [Integer] End of the range
+      # @return [Hash] An LSP `Range`
+      def range(source, start_byte, end_byte)
+        index = PositionIndex.for(source)
+        { "start" => position(index, start_byte), "end" => position(index, end_byte) }
+      end
+
+      # Convert byte-range edits to LSP `TextEdit`s
+      #
+      # @param source [String, PositionIndex] The document the edits were made against
+      # @param edits [Array] Objects with start_byte, end_byte and replacement (e.g. Rewriter::Edit)
+      # @return [Array<Hash>] The text edits, in document order
+      def text_edits(source, edits)
+        index = PositionIndex.for(source)
+        edits.sort_by { |edit| [edit.start_byte, edit.end_byte] }.map do |edit|
+          { "range" => range(index, edit.start_byte, edit.end_byte), "newText" => edit.replacement }
+        end
+      end
+
+      # Convert LSP `TextEdit`s to byte-range edits
+      #
+      # @param source [String, PositionIndex] The document the edits were made against
+      # @param text_edits [Array<Hash>] The text edits (string or symbol keys)
+      # @return [Array<Array(Integer, Integer, String)>] `[start_byte, end_byte, replacement]` triples
+      def byte_edits(source, text_edits)
+        index = PositionIndex.for(source)
+        text_edits.map do |text_edit|
+          range = fetch(text_edit, "range")
+          [
+            byte_offset(index, fetch(range, "start")),
+            byte_offset(index, fetch(range, "end")),
+            fetch(text_edit, "newText").to_s,
+          ]
+        end
+      end
+
+      # The `TextEdit`s for one document
+      #
+      # @param edits [Array<Hash>, Hash, String] A `TextEdit[]` or `WorkspaceEdit`, or its JSON
+      # @param uri [String, nil] The document (may be omitted if the workspace edit only has one)
+      # @return [Array<Hash>]
+      # @raise [ArgumentError] If the document is not in the workspace edit, or it is ambiguous
+      def text_edits_for(edits, uri = nil)
+        edits = JSON.parse(edits) if edits.is_a?(String)
+        return edits if edits.is_a?(Array)
+
+        documents = workspace_documents(edits)
+        if uri
+          documents.fetch(uri.to_s) { raise ArgumentError, "The workspace edit has no edits for #{uri}" }
+        elsif documents.size == 1
+          documents.values.first
+        else
+          raise ArgumentError, "The workspace edit changes #{documents.size} documents; pass uri:"
+        end
+      end
+
+      # The text edits of a `WorkspaceEdit` by document URI
+      #
+      # Both `changes` and `documentChanges` are read; file operations
+      # (create, rename, delete) are ignored.
+      #
+      # @param workspace_edit [Hash] The workspace edit
+      # @return [Hash{String => Array<Hash>}]
+      def workspace_documents(workspace_edit)
+        documents = Hash.new { |hash, key| hash[key] = [] }
+
+        (fetch(workspace_edit, "changes", nil) || {}).each do |uri, text_edits|
+          documents[uri.to_s].concat(text_edits)
+        end
+        (fetch(workspace_edit, "documentChanges", nil) || []).each do |change|
+          document = fetch(change, "textDocument", nil)
+          next unless document
+
+          documents[fetch(document, "uri").to_s].concat(fetch(change, "edits"))
+        end
+        documents.default_proc = nil
+        documents
+      end
+
+      # @param path [String] A file path
+      # @return [String] Its file:// URI
+      def file_uri(path)
+        encoded = File.expand_path(path).gsub(%r{[^A-Za-z0-9\-._~/:@!$&'()*+,;=]}) do |char|
+          char.bytes.map { |byte| format("%%%02X", byte) }.join
+        end
+        "file://#{encoded}"
+      end
+
+      private
+
+      # Read a key that may be a string or a symbol
+      def fetch(hash, key, *default)
+        return hash[key] if hash.key?(key)
+        return hash[key.to_sym] if hash.key?(key.to_sym)
+        return default.first unless default.empty?
+
+        raise ArgumentError, "Missing #{key.inspect} in #{hash.inspect}"
+      end
+    end
+  end
+end
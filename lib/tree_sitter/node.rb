@@ -0,0 +1,75 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Ruby-side additions to the native Node class
+  class Node
+    # Multi-line S-expression of the subtree, in the style of `tree-sitter parse`
+    #
+    # Unlike #to_sexp this can show field names, anonymous nodes, positions
+    # and source text, which makes it handy for debugging, test expectations
+    # and bug reports:
+    #
+    #   (function_item [0, 0] - [0, 12]
+    #     ("fn" [0, 0] - [0, 2])
+    #     name: (identifier [0, 3] - [0, 7] "main")
+    #     ...
+    #
+    # Anonymous nodes are shown with their kind quoted, missing nodes as
+    # `(MISSING kind ...)`, and subtrees cut off by max_depth end in `...`.
+    #
+    # @param fields [Boolean] Prefix children with their field name
+    # @param anonymous [Boolean] Include anonymous nodes (punctuation, keywords)
+    # @param ranges [Boolean] Show the start and end point of each node
+    # @param text [Symbol, false] :leaves to show the text of leaf nodes, :all for every node, false for none
+    # @param max_depth [Integer, nil] Stop descending below this depth (the node itself is depth 0)
+    # @param indent [Integer] Spaces per level of nesting
+    # @return [String]
+    def dump(fields: true, anonymous: true, ranges: true, text: :leaves, max_depth: nil, indent: 2)
+      unless [:leaves, :all, false, nil].include?(text)
+        raise ArgumentError, "text: must be :leaves, :all or false, got #{text.inspect}"
+      end
+
+      options = { fields: fields, anonymous: anonymous, ranges: ranges, text: text, max_depth: max_depth }
+      lines = []
+      dump_lines(self, nil, 0, options, " " * indent, lines)
+      lines.join("\n")
+    end
+
+    private
+
+    def dump_lines(node, field, depth, options, indent, lines)
+      children = node.children.each_with_index.filter_map do |child, index|
+        next unless options[:anonymous] || child.named?
+
+        [child, options[:fields] ? node.field_name_for_child(index) : nil]
+      end
+      truncated = options[:max_depth] && depth >= options[:max_depth] && children.any?
+
+      label = node.named? ? node.kind : node.kind.inspect
+      label = "MISSING #{label}" if node.missing?
+      parts = [label]
+      parts << node.range.to_s if options[:ranges]
+      parts << node.text.inspect if dump_text?(node, options[:text])
+      parts << "..." if truncated
+
+      prefix = field ? "#{field}: " : ""
+      lines << "#{indent * depth}#{prefix}(#{parts.join(" ")}"
+      unless truncated
+        children.each { |child, child_field| dump_lines(child, child_field, depth + 1, options, indent, lines) }
+      end
+      lines[-1] += ")"
+    end
+
+    def dump_text?(node, mode)
+      case mode
+      when :all
+        true
+      when :leaves
+        # The text of an anonymous node is its kind, which is already shown
+        node.child_count.zero? && node.named? && !node.missing?
+      else
+        false
+      end
+    end
+  end
+end
@@ -0,0 +1,68 @@
+# frozen_string_literal: true
+
+require_relative "text_diff"
+
+module TreeSitter
+  # Ruby-side additions to the native Parser class
+  class Parser
+    # Parse a new version of a source text incrementally, given only the old
+    # tree and the new text
+    #
+    # The edits between the old tree's source and `new_source` are computed
+    # with `TextDiff.edits` and applied to a copy of `old_tree`, which is left
+    # untouched. The returned ranges combine what tree-sitter reports as
+    # structurally changed with the spans of text that were edited, in
+    # `new_source` coordinates.
+    #
+    # @param old_tree [TreeSitter::Tree] A tree previously parsed by a parser for the same language
+    # @param new_source [String] The updated source text
+    # @param old_source [String] The text old_tree was parsed from (defaults to old_tree.source)
+    # @param algorithm [Symbol] :myers (one edit per hunk) or :prefix_suffix (a single edit)
+    # @return [Array(TreeSitter::Tree, Array<TreeSitter::Range>)] The new tree and the changed ranges
+    def reparse(old_tree, new_source, old_source: old_tree.source, algorithm: :myers)
+      edits = TextDiff.edits(old_source, new_source, algorithm: algorithm)
+      edited_tree = old_tree.copy
+      edits.each { |edit| edited_tree.edit(edit) }
+
+      new_tree = parse(new_source, edited_tree)
+      return [nil, []] unless new_tree
+
+      changed = edited_tree.changed_ranges(new_tree) + edited_ranges(edits, new_source)
+      [new_tree, merge_ranges(changed)]
+    end
+
+    private
+
+    # Map each edit's inserted span into final (new_source) coordinates.
+    # Edits arrive last-to-first, so walk them first-to-last accumulating the
+    # size change of the ones already passed.
+    def edited_ranges(edits, new_source)
+      delta = 0
+      edits.reverse.map do |edit|
+        start_byte = edit.start_byte + delta
+        end_byte = start_byte + (edit.new_end_byte - edit.start_byte)
+        delta += edit.new_end_byte - edit.old_end_byte
+
+        TreeSitter::Range.new(
+          start_byte,
+          end_byte,
+          TextDiff.point_at(new_source, start_byte),
+          TextDiff.point_at(new_source, end_byte),
+        )
+      end
+    end
+
+    def merge_ranges(ranges)
+      ranges.sort_by(&:start_byte).each_with_object([]) do |range, merged|
+        last = merged.last
+        if last && range.start_byte <= last.end_byte
+          next if range.end_byte <= last.end_byte
+
+          merged[-1] = TreeSitter::Range.new(last.start_byte, range.end_byte, last.start_point, range.end_point)
+        else
+          merged << range
+        end
+      end
+    end
+  end
+end
@@ -0,0 +1,227 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Whole lines of a source, as a target for the editing methods
+  #
+  # A line range covers its lines including their line endings, so removing
+  # it leaves no blank line behind.
+  #
+  # @example Remove lines 10 to 12 (zero-based)
+  #   rewriter.remove(TreeSitter::Lines.new(10..12))
+  #
+  Lines = Struct.new(:range)
+
+  # Converts between byte offsets and line/column positions of a source.
+  #
+  # Tools report positions in different ways: tree-sitter uses zero-based
+  # rows and byte columns, editors and linters often use one-based lines, and
+  # LSP counts columns in UTF-16 code units. Columns are given in one of
+  # UNITS. Positions past the end of a line are clamped to the end of the
+  # line (a "\r" before the "\n" is not part of the line), and rows past the
+  # last line to the end of the source.
+  #
+  # @example
+  #   index = TreeSitter::PositionIndex.new(source)
+  #   index.byte_offset(3, 10, unit: :utf16, one_based: true)
+  #   index.resolve([[2, 0], [2, 8]])  # => TreeSitter::Range
+  #
+  class PositionIndex
+    # Column units: bytes (as in tree-sitter points), characters, or UTF-16 code units
+    UNITS = [:byte, :char, :utf16].freeze
+
+    attr_reader :source
+
+    class << self
+      # @param source [String, PositionIndex] A source text, or an index of one
+      # @return [PositionIndex] An index of the source (the index itself if given one)
+      def for(source)
+        source.is_a?(PositionIndex) ? source : new(source)
+      end
+
+      # @param unit [Symbol] A column unit
+      # @return [Symbol] The unit
+      # @raise [ArgumentError] If it is not one of UNITS
+      def validate_unit(unit)
+        raise ArgumentError, "Unknown column unit #{unit.inspect} (expected one of #{UNITS.join(", ")})" unless UNITS.include?(unit)
+
+        unit
+      end
+    end
+
+    # @param source [String] The source text
+    def initialize(source)
+      @source = source
+      @line_starts = [0]
+      offset = 0
+      while (newline = source.byteindex("\n", offset))
+        offset = newline + 1
+        @line_starts << offset
+      end
+    end
+
+    # @return [Integer] The number of lines
+    def line_count
+      @line_starts.size
+    end
+
+    # The byte offset of a position
+    #
+    # @param row [Integer] The line
+    # @param column [Integer] The column, in `unit`s
+    # @param unit [Symbol] One of UNITS
+    # @param one_based [Boolean] True if rows and columns count from 1
+    # @return [Integer]
+    def byte_offset(row, column, unit: :byte, one_based: false)
+      validate_unit(unit)
+      if one_based
+        row -= 1
+        column -= 1
+      end
+      return 0 if row.negative?
+      return @source.bytesize if row >= line_count
+
+      start = @line_starts[row]
+      text = line_text(row)
+      column = [column, 0].max
+
+      case unit
+      when :byte
+        start + [column, text.bytesize].min
+      when :char
+        start + (text[0, column] || text).bytesize
+      when :utf16
+        offset = start
+        units = 0
+        text.each_char do |char|
+          break if units >= column
+
+          units += char.ord > 0xFFFF ? 2 : 1
+          offset += char.bytesize
+        end
+        offset
+      end
+    end
+
+    # The position of a byte offset
+    #
+    # @param byte [Integer] A byte offset
+    # @param unit [Symbol] One of UNITS, for the column
+    # @param one_based [Boolean] True to count rows and columns from 1
+    # @return [Array(Integer, Integer)] The row and column
+    def position(byte, unit: :byte, one_based: false)
+      validate_unit(unit)
+      row = row_at(byte)
+      prefix = @source.byteslice(@line_starts[row]...byte)
+      column = case unit
+      when :byte then prefix.bytesize
+      when :char then prefix.length
+      when :utf16 then prefix.each_char.sum { |char| char.ord > 0xFFFF ? 2 : 1 }
+      end
+      one_based ? [row + 1, column + 1] : [row, column]
+    end
+
+    # @param byte [Integer] A byte offset
+    # @return [TreeSitter::Point] Its tree-sitter point (byte column)
+    def point(byte)
+      row, column = position(byte)
+      TreeSitter::Point.new(row, column)
+    end
+
+    # @param row [Integer] A zero-based line
+    # @return [Integer] The byte offset the line starts at
+    def line_start(row)
+      @line_starts[row.clamp(0, line_count - 1)]
+    end
+
+    # @param start_byte [Integer] Start of the range
+    # @param end_byte [Integer] End of the range
+    # @return [TreeSitter::Range]
+    def range(start_byte, end_byte)
+      TreeSitter::Range.new(start_byte, end_byte, point(start_byte), point(end_byte))
+    end
+
+    # The byte range of an edit target
+    #
+    # Accepts a Node or Range; a Point (tree-sitter coordinates, always
+    # zero-based with byte columns); a `[row, column]` pair; a pair of points
+    # or positions, or a Ruby Range of them (the end is exclusive); a Ruby
+    # Range of byte offsets; or Lines. Pairs and Lines are read with `unit`
+    # and `one_based`. A single position is an empty range, for insertions.
+    #
+    # @param target [Object] The target
+    # @param unit [Symbol] One of UNITS, for the columns of `[row, column]` pairs
+    # @param one_based [Boolean] True if rows and columns of pairs and Lines count from 1
+    # @return [TreeSitter::Range]
+    # @raise [ArgumentError] If the target is not one of those
+    def resolve(target, unit: :byte, one_based: false)
+      case target
+      when TreeSitter::Node
+        target.range
+      when TreeSitter::Range
+        target
+      when Lines
+        first = target.range.begin || (one_based ? 1 : 0)
+        last = target.range.end || (one_based ? line_count : line_count - 1)
+        last -= 1 if target.range.exclude_end?
+        first, last = [first - 1, last - 1] if one_based
+        end_byte = last + 1 < line_count ? @line_starts[last + 1] : @source.bytesize
+        range(line_start(first), [end_byte, line_start(first)].max)
+      when ::Range
+        if (target.begin.nil? || target.begin.is_a?(Integer)) && (target.end.nil? || target.end.is_a?(Integer))
+          start_byte = target.begin || 0
+          end_byte = target.end ? target.end + (target.exclude_end? ? 0 : 1) : @source.bytesize
+          range(start_byte, end_byte)
+        else
+          span(target.begin, target.end, unit, one_based)
+        end
+      when TreeSitter::Point, Array
+        return span(target[0], target[1], unit, one_based) if position_pair?(target)
+
+        byte = position_byte(target, unit, one_based)
+        range(byte, byte)
+      else
+        raise ArgumentError,
+          "Expected a Node, Range, Point, [row, column], Lines or a Ruby Range, got #{target.class}"
+      end
+    end
+
+    private
+
+    def validate_unit(unit)
+      PositionIndex.validate_unit(unit)
+    end
+
+    def row_at(byte)
+      (@line_starts.bsearch_index { |start| start > byte } || line_count) - 1
+    end
+
+    # The text of a line, without its line ending
+    def line_text(row)
+      line_end = row + 1 < line_count ? @line_starts[row + 1] : @source.bytesize
+      @source.byteslice(@line_starts[row]...line_end).chomp("\n").chomp("\r")
+    end
+
+    def position_pair?(target)
+      target.is_a?(Array) && target.size == 2 && target.all? { |item| item.is_a?(TreeSitter::Point) || item.is_a?(Array) }
+    end
+
+    def span(from, to, unit, one_based)
+      range(position_byte(from, unit, one_based), position_byte(to, unit, one_based))
+    end
+
+    def position_byte(position, unit, one_based)
+      case position
+      when TreeSitter::Point
+        byte_offset(position.row, position.column)
+      when Array
+        unless position.size == 2 && position.all?(Integer)
+          raise ArgumentError, "Expected a [row, column] pair, got #{position.inspect}"
+        end
+
+        byte_offset(*position, unit: unit, one_based: one_based)
+      else
+        raise ArgumentError, "Expected a Point or [row, column] pair, got #{position.class}"
+      end
+    end
+  end
+end
@@ -0,0 +1,230 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Per-pattern statistics for a query run over a corpus of trees.
+  # Useful for finding dead or pathological patterns in large `.scm` files.
+  #
+  # Each pattern is run on its own so that its time can be measured
+  # separately from the rest of the query: the whole query is compiled with
+  # the other patterns disabled, so the pattern behaves exactly as it does in
+  # the full query. Only the time spent in the native query cursor is
+  # measured, not building Ruby objects for the matches.
+  #
+  # @example Profile a highlights file across a repository
+  #   trees = Dir["src/**/*.rs"].map { |path| parser.parse(File.read(path)) }
+  #   report = TreeSitter::QueryProfiler.run(File.read("highlights.scm"), trees)
+  #   report.never_matched.each { |stats| puts stats.source }
+  #   puts report
+  #
+  module QueryProfiler
+    # Statistics for a single pattern of the profiled query
+    PatternStats = Struct.new(
+      :index,
+      :source,
+      :start_byte,
+      :match_count,
+      :capture_count,
+      :time,
+      :limit_exceeded_count,
+      keyword_init: true,
+    ) do
+      # @return [Boolean] True if the pattern matched nothing in the corpus
+      def never_matched?
+        match_count.zero?
+      end
+
+      # @return [Boolean] True if the match limit was hit on at least one tree
+      def hit_match_limit?
+        limit_exceeded_count.positive?
+      end
+    end
+
+    # The result of profiling a query over a set of trees
+    class Report
+      attr_reader :patterns, :tree_count
+
+      # @param patterns [Array<PatternStats>] Statistics, one per pattern
+      # @param tree_count [Integer] Number of trees that were profiled
+      def initialize(patterns, tree_count)
+        @patterns = patterns
+        @tree_count = tree_count
+      end
+
+      # @return [Float] Total seconds spent across all patterns
+      def total_time
+        @patterns.sum(&:time)
+      end
+
+      # @return [Integer] Total matches across all patterns
+      def total_matches
+        @patterns.sum(&:match_count)
+      end
+
+      # @return [Array<PatternStats>] Patterns that never matched
+      def never_matched
+        @patterns.select(&:never_matched?)
+      end
+
+      # @return [Array<PatternStats>] Patterns that hit the match limit
+      def hit_match_limit
+        @patterns.select(&:hit_match_limit?)
+      end
+
+      # @param count [Integer] How many patterns to return
+      # @return [Array<PatternStats>] The slowest patterns, slowest first
+      def slowest(count = 10)
+        @patterns.max_by(count, &:time)
+      end
+
+      # @return [Array<Hash>] Plain data for serialization
+      def to_a
+        @patterns.map(&:to_h)
+      end
+
+      # @return [String] A human-readable table of the report
+      def to_s
+        lines = [format("%-8s %10s %10s %12s  %s", "pattern", "matches", "captures", "time (ms)", "source")]
+        @patterns.each do |stats|
+          flag = if stats.hit_match_limit?
+            "  [match limit]"
+          elsif stats.never_matched?
+            "  [never matched]"
+          end
+          lines << format(
+            "%-8d %10d %10d %12.3f  %s%s",
+            stats.index,
+            stats.match_count,
+            stats.capture_count,
+            stats.time * 1000,
+            first_code_line(stats.source),
+            flag,
+          )
+        end
+        lines << format("%d patterns, %d trees, %.3f ms total", @patterns.length, @tree_count, total_time * 1000)
+        lines.join("\n")
+      end
+
+      private
+
+      # The first line of a pattern's source that is not a comment
+      def first_code_line(source)
+        source.lines.map(&:strip).find { |line| !line.empty? && !line.start_with?(";") }.to_s
+      end
+    end
+
+    class << self
+      # Run every pattern of a query over a set of trees
+      #
+      # @param query [String, TreeSitter::Query] The query source (or a compiled query)
+      # @param trees [Array<TreeSitter::Tree>] Trees to run the query on
+      # @param language [TreeSitter::Language, String, nil] Defaults to the first tree's language
+      # @param match_limit [Integer, nil] Maximum in-progress matches per cursor
+      # @return [Report] Statistics for each pattern
+      def run(query, trees, language: nil, match_limit: nil)
+        trees = Array(trees)
+        language = resolve_language(language, trees)
+        query_source = query.is_a?(TreeSitter::Query) ? query.source : query.to_s
+        full_query = query.is_a?(TreeSitter::Query) ? query : TreeSitter::Query.new(language, query_source)
+
+        patterns = split_patterns(full_query, query_source).each_with_index.map do |(start_byte, source), index|
+          profile_pattern(
+            pattern_query(language, query_source, full_query.pattern_count, index),
+            trees,
+            index: index,
+            source: source,
+            start_byte: start_byte,
+            match_limit: match_limit,
+          )
+        end
+
+        Report.new(patterns, trees.length)
+      end
+
+      private
+
+      def resolve_language(language, trees)
+        case language
+        when TreeSitter::Language
+          language
+        when String
+          TreeSitter.language(language)
+        when nil
+          raise ArgumentError, "Cannot infer the language without any trees" if trees.empty?
+
+          trees.first.language
+        else
+          raise ArgumentError, "Invalid language: #{language.class}"
+        end
+      end
+
+      # Split the query source into one chunk per pattern, using the byte
+      # offsets tree-sitter records for each pattern. A pattern's offset is
+      # past the comments above it, so the comment lines at the end of a
+      # chunk after its last blank line are moved to the next chunk.
+      def split_patterns(query, source)
+        starts = (0...query.pattern_count).map { |i| query.start_byte_for_pattern(i) }
+        return [] if starts.empty?
+
+        ends = starts.drop(1) + [source.bytesize]
+        leading = comments_above(source.byteslice(0...starts.first).lines)
+
+        starts.zip(ends).map do |start_byte, end_byte|
+          lines = source.byteslice(start_byte...end_byte).lines
+          trailing = []
+          trailing.unshift(lines.pop) while lines.last && (lines.last.strip.empty? || lines.last.lstrip.start_with?(";"))
+          following = comments_above(trailing)
+
+          text = (leading + lines + trailing[0...(trailing.length - following.length)]).join.strip
+          leading = following
+          [start_byte, text]
+        end
+      end
+
+      # The comment lines directly above a pattern: those after the last blank line
+      def comments_above(lines)
+        blank = lines.rindex { |line| line.strip.empty? }
+        blank ? lines[(blank + 1)..] : lines
+      end
+
+      # The whole query with every pattern but one disabled
+      #
+      # Disabling a pattern cannot be undone, so each pattern needs its own
+      # compiled copy. The copies of the last query profiled are kept, so
+      # profiling it again (over another batch of trees) compiles nothing.
+      def pattern_query(language, source, pattern_count, index)
+        key = [language.name, source]
+        @pattern_queries = { key => [] } unless @pattern_queries&.key?(key)
+        @pattern_queries[key][index] ||= TreeSitter::Query.new(language, source).tap do |query|
+          pattern_count.times { |other| query.disable_pattern(other) unless other == index }
+        end
+      end
+
+      def profile_pattern(query, trees, index:, source:, start_byte:, match_limit:)
+        stats = PatternStats.new(
+          index: index,
+          source: source,
+          start_byte: start_byte,
+          match_count: 0,
+          capture_count: 0,
+          time: 0.0,
+          limit_exceeded_count: 0,
+        )
+
+        trees.each do |tree|
+          cursor = TreeSitter::QueryCursor.new
+          cursor.match_limit = match_limit if match_limit
+
+          seconds, counts = cursor.profile_matches(query, tree.root_node, tree.source)
+          match_count, capture_count = counts[index]
+
+          stats.time += seconds
+          stats.match_count += match_count
+          stats.capture_count += capture_count
+          stats.limit_exceeded_count += 1 if cursor.did_exceed_match_limit?
+        end
+
+        stats
+      end
+    end
+  end
+end
@@ -17,9 +17,38 @@ module TreeSitter
   #     .remove("@comment")
   #     .rewrite
   #
+  # @example Several queries, each with its own operations
+  #   QueryRewriter.new(source, tree, language)
+  #     .query('(line_comment) @comment').remove("@comment")
+  #     .query({
+  #       unwrap: '(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @m
+  #                  (#eq? @m "unwrap"))) @call',
+  #       dbg: '(macro_invocation macro: (identifier) @m (#eq? @m "dbg")) @call',
+  #     })
+  #     .on_pattern(:unwrap) { |r| r.replace("@call", template: "@recv.expect(\"TODO\")") }
+  #     .on_pattern(:dbg) { |r| r.remove_match }
+  #     .rewrite
+  #
+  # @example Rewrite with a template of the match's captures
+  #   QueryRewriter.new(source, tree, language)
+  #     .query('(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @m)
+  #             (#eq? @m "unwrap")) @call')
+  #     .replace("@call", template: "@recv.try_into()?")
+  #     .rewrite
+  #
   class QueryRewriter
-    # Represents a pending operation on a capture
-    Operation = Struct.new(:type, :capture_name, :transformer, :before, :after, keyword_init: true)
+    # Represents a pending operation on a capture (or on a whole match),
+    # optionally limited to one pattern of the query
+    Operation = Struct.new(
+      :type, :capture_name, :transformer, :template, :reindent, :before, :after, :pattern,
+      keyword_init: true,
+    )
+
+    # A query with the filters and operations that apply to its matches
+    #
+    # Names maps pattern names to pattern indices when the query was given
+    # as a Hash of named patterns.
+    QueryGroup = Struct.new(:pattern, :names, :capture_names, :predicates, :operations, keyword_init: true)
 
     attr_reader :source, :tree, :language, :edits
 
@@ -29,23 +58,37 @@ module TreeSitter
     # @param tree [TreeSitter::Tree] The parsed syntax tree
     # @param language [TreeSitter::Language, String] The language for queries
     # @param parser [TreeSitter::Parser, nil] Optional parser for re-parsing
-    def initialize(source, tree, language = nil, parser: nil)
+    # @param only_within [Array<TreeSitter::Range>, nil] Only edit captured nodes intersecting these ranges
+    # @param within [TreeSitter::Node, TreeSitter::Range, Array, nil] Only run the queries on this
+    #   node or these ranges (the query cursor is limited to them, so the rest of the tree is not searched)
+    def initialize(source, tree, language = nil, parser: nil, only_within: nil, within: nil)
       @source = source.dup.freeze
       @tree = tree
       @language = resolve_language(language)
       @parser = parser
-      @query_pattern = nil
-      @predicates = []
-      @operations = []
+      @only_within = only_within
+      @within = TreeSitter::Range.wrap(within)
+      @groups = []
+      @pattern_filter = nil
       @edits = []
     end
 
-    # Set the query pattern to match against
+    # Add a query to match against
+    #
+    # Filters and operations added afterwards apply to this query's matches
+    # only, so several queries can be chained, each with its own operations.
+    # A Hash of named patterns is combined into one query whose patterns can
+    # be targeted by name with #on_pattern.
     #
-    # @param pattern [String] Tree-sitter query pattern
+    # @param pattern [String, Hash{Symbol, String => String}] Tree-sitter query, or named patterns
     # @return [self] For method chaining
     def query(pattern)
-      @query_pattern = pattern
+      source, names = pattern.is_a?(Hash) ? named_patterns(pattern) : [pattern, nil]
+      group = current_group
+      # Filters and operations given before the first query belong to it
+      group = new_group if group.pattern
+      group.pattern = source
+      group.names = names
       self
     end
 
@@ -54,31 +97,81 @@ module TreeSitter
     # @yield [QueryMatch] Block that returns true for matches to keep
     # @return [self] For method chaining
     def where(&predicate)
-      @predicates << predicate
+      current_group.predicates << predicate
+      self
+    end
+
+    # Limit the operations added in the block to matches of one pattern of
+    # the current query
+    #
+    # @param index_or_name [Integer, Symbol, String] Pattern index, or name given to #query
+    # @yield [QueryRewriter] self, to add operations to
+    # @return [self] For method chaining
+    # @raise [ArgumentError] If no block is given
+    # @example
+    #   rewriter.query("(line_comment) @c (block_comment) @c")
+    #     .on_pattern(1) { |r| r.remove("@c") }
+    def on_pattern(index_or_name)
+      raise ArgumentError, "QueryRewriter#on_pattern needs a block to add the operations to" unless block_given?
+
+      previous = @pattern_filter
+      begin
+        @pattern_filter = index_or_name
+        yield self
+      ensure
+        @pattern_filter = previous
+      end
       self
     end
 
     # Replace captured nodes with new content
     #
     # @param capture_name [String] The @capture to replace (e.g., "@fn_name" or "fn_name")
+    # @param template [String, nil] Replacement built from the match's captures (see QueryTemplate)
+    # @param reindent [Boolean] Indent the lines after the first of the block's text to the
+    #   node's position (the text is taken to be written at column zero)
     # @yield [Node] Block that returns the replacement text (receives the captured node)
     # @yieldreturn [String] The replacement text
     # @return [self] For method chaining
-    def replace(capture_name, &transformer)
-      @operations << Operation.new(
+    # @example
+    #   .replace("@call", template: "{{@name | snake_case}}(@args)")
+    def replace(capture_name, template: nil, reindent: false, &transformer)
+      add_operation(
         type: :replace,
         capture_name: normalize_capture_name(capture_name),
         transformer: transformer || proc { "" },
+        template: template,
+        reindent: reindent,
       )
       self
     end
 
+    # Replace the whole span of each match, from the start of its first
+    # capture to the end of its last
+    #
+    # @param template [String, nil] Replacement built from the match's captures (see QueryTemplate)
+    # @yield [QueryMatch] Block that returns the replacement text
+    # @yieldreturn [String] The replacement text
+    # @return [self] For method chaining
+    def replace_match(template: nil, &transformer)
+      add_operation(type: :replace_match, transformer: transformer || proc { "" }, template: template)
+      self
+    end
+
+    # Remove the whole span of each match
+    #
+    # @return [self] For method chaining
+    def remove_match
+      add_operation(type: :replace_match, transformer: proc { "" })
+      self
+    end
+
     # Remove captured nodes
     #
     # @param capture_name [String] The @capture to remove
     # @return [self] For method chaining
     def remove(capture_name)
-      @operations << Operation.new(
+      add_operation(
         type: :remove,
         capture_name: normalize_capture_name(capture_name),
       )
@@ -88,15 +181,19 @@ module TreeSitter
     # Insert content before captured nodes
     #
     # @param capture_name [String] The @capture reference point
+    # @param template [String, nil] Content built from the match's captures (see QueryTemplate)
+    # @param reindent [Boolean] Indent the lines after the first of the content to the node's position
     # @yield [Node] Block that returns the content to insert
     # @yieldreturn [String] The content to insert
     # @return [self] For method chaining
-    def insert_before(capture_name, content = nil, &content_generator)
+    def insert_before(capture_name, content = nil, template: nil, reindent: false, &content_generator)
       generator = content_generator || proc { content.to_s }
-      @operations << Operation.new(
+      add_operation(
         type: :insert_before,
         capture_name: normalize_capture_name(capture_name),
         transformer: generator,
+        template: template,
+        reindent: reindent,
       )
       self
     end
@@ -104,15 +201,19 @@ module TreeSitter
     # Insert content after captured nodes
     #
     # @param capture_name [String] The @capture reference point
+    # @param template [String, nil] Content built from the match's captures (see QueryTemplate)
+    # @param reindent [Boolean] Indent the lines after the first of the content to the node's position
     # @yield [Node] Block that returns the content to insert
     # @yieldreturn [String] The content to insert
     # @return [self] For method chaining
-    def insert_after(capture_name, content = nil, &content_generator)
+    def insert_after(capture_name, content = nil, template: nil, reindent: false, &content_generator)
       generator = content_generator || proc { content.to_s }
-      @operations << Operation.new(
+      add_operation(
         type: :insert_after,
         capture_name: normalize_capture_name(capture_name),
         transformer: generator,
+        template: template,
+        reindent: reindent,
       )
       self
     end
@@ -125,14 +226,14 @@ module TreeSitter
     # @yield [Node] Optional block that returns [before, after] tuple
     # @return [self] For method chaining
     def wrap(capture_name, before: nil, after: nil, &block)
-      @operations << if block
-        Operation.new(
+      if block
+        add_operation(
           type: :wrap_dynamic,
           capture_name: normalize_capture_name(capture_name),
           transformer: block,
         )
       else
-        Operation.new(
+        add_operation(
           type: :wrap,
           capture_name: normalize_capture_name(capture_name),
           before: before.to_s,
@@ -142,21 +243,11 @@ module TreeSitter
       self
     end
 
-    # Execute the query and collect all matches
+    # Execute the queries and collect all matches
     #
-    # @return [Array<QueryMatch>] All matches found
+    # @return [Array<QueryMatch>] All matches found, query by query
     def matches
-      return [] unless @query_pattern && @language
-
-      ts_query = TreeSitter::Query.new(@language, @query_pattern)
-      cursor = TreeSitter::QueryCursor.new
-
-      all_matches = cursor.matches(ts_query, @tree.root_node, @source)
-
-      # Apply filters
-      @predicates.reduce(all_matches) do |matches, predicate|
-        matches.select(&predicate)
-      end
+      @groups.flat_map { |group| group_matches(group) }
     end
 
     # Apply all accumulated edits
@@ -180,6 +271,35 @@ module TreeSitter
       [new_source, new_tree]
     end
 
+    # Rewrite repeatedly, reparsing in between, until the queries no longer
+    # produce any edits
+    #
+    # For rewrites whose results can match again, such as nested `unwrap()`
+    # chains. Use a RuleSet to run several rewriters together.
+    #
+    # @param max_passes [Integer] Maximum number of passes
+    # @return [RuleSet::Result] The final source and tree, the edits of each pass,
+    #   and whether the source became stable, oscillated or ran out of passes
+    def rewrite_until_stable(max_passes: 10)
+      RuleSet.new(@language, parser: @parser || create_parser_from_tree)
+        .rule("query_rewriter", rewriter: self)
+        .run(@source, @tree, max_passes: max_passes, only_within: @only_within, within: @within)
+    end
+
+    # A rewriter with the same queries, filters and operations for another
+    # version of the source
+    #
+    # @param source [String] The source code to rewrite
+    # @param tree [TreeSitter::Tree] Its syntax tree
+    # @param only_within [Array<TreeSitter::Range>, nil] Only edit captured nodes intersecting these ranges
+    # @param within [TreeSitter::Node, TreeSitter::Range, Array, nil] Only run the queries on these ranges
+    # @return [QueryRewriter]
+    def for_source(source, tree, only_within: nil, within: nil)
+      rewriter = QueryRewriter.new(source, tree, @language, parser: @parser, only_within: only_within, within: within)
+      rewriter.groups = @groups
+      rewriter
+    end
+
     # Get a preview of all edits that would be applied
     #
     # @return [Array<Hash>] Array of edit descriptions
@@ -189,12 +309,27 @@ module TreeSitter
         {
           start_byte: edit[:start_byte],
           end_byte: edit[:end_byte],
-          original: @source[edit[:start_byte]...edit[:end_byte]],
+          original: @source.byteslice(edit[:start_byte]...edit[:end_byte]),
           replacement: edit[:replacement],
         }
       end
     end
 
+    # Export the edits to a ChangeSet, to combine them with the edits of
+    # other tools or save them for later
+    #
+    # @param path [String, nil] The file the source was read from
+    # @return [TreeSitter::ChangeSet]
+    # @raise [TreeSitter::ConflictError] If the edits overlap each other
+    def to_change_set(path: nil)
+      build_edits
+      ChangeSet.new.add(path, @source, @edits, origin: "query_rewriter")
+    end
+
+    protected
+
+    attr_writer :groups
+
     private
 
     def resolve_language(language)
@@ -214,88 +349,230 @@ module TreeSitter
       name.to_s.delete_prefix("@")
     end
 
+    def current_group
+      @groups.last || new_group
+    end
+
+    def new_group
+      group = QueryGroup.new(pattern: nil, names: nil, capture_names: [], predicates: [], operations: [])
+      @groups << group
+      group
+    end
+
+    def add_operation(**attributes)
+      current_group.operations << Operation.new(pattern: @pattern_filter, **attributes)
+    end
+
+    # Combine named patterns into one query source, remembering where each
+    # name's patterns start
+    def named_patterns(patterns)
+      source = +""
+      offsets = patterns.map do |name, pattern|
+        offset = source.bytesize
+        source << pattern.to_s << "\n"
+        [name.to_s, offset]
+      end
+      [source, offsets]
+    end
+
+    def group_matches(group)
+      return [] unless group.pattern && @language
+
+      ts_query = TreeSitter::Query.new(@language, group.pattern)
+      group.capture_names = ts_query.capture_names
+      resolve_pattern_names(group, ts_query)
+      cursor = TreeSitter::QueryCursor.new
+
+      all_matches = if @within
+        scoped_matches(cursor, ts_query)
+      else
+        cursor.matches(ts_query, @tree.root_node, @source)
+      end
+
+      # Apply filters
+      group.predicates.reduce(all_matches) do |matches, predicate|
+        matches.select(&predicate)
+      end
+    end
+
+    # Matches within each of the within ranges; a match found in two
+    # overlapping ranges is kept once
+    def scoped_matches(cursor, ts_query)
+      matches = @within.flat_map do |scope|
+        cursor.set_byte_range(scope.start_byte, scope.end_byte)
+        cursor.matches(ts_query, @tree.root_node, @source)
+      end
+      matches.uniq do |match|
+        [match.pattern_index, match.captures.map { |c| [c.name, c.node.start_byte, c.node.end_byte] }]
+      end
+    end
+
+    # Turn the name offsets recorded by #named_patterns into pattern indices
+    def resolve_pattern_names(group, ts_query)
+      return unless group.names.is_a?(Array)
+
+      offsets = group.names
+      group.names = Hash.new { |hash, key| hash[key] = [] }
+      ts_query.pattern_count.times do |index|
+        start = ts_query.start_byte_for_pattern(index)
+        name, _offset = offsets.reverse.find { |_name, offset| offset <= start }
+        group.names[name] << index
+      end
+      group.names.default_proc = nil
+    end
+
+    def pattern_applies?(group, pattern, match)
+      case pattern
+      when nil
+        true
+      when Integer
+        match.pattern_index == pattern
+      else
+        indices = group.names&.fetch(pattern.to_s, nil)
+        raise ArgumentError, "Unknown pattern name: #{pattern}" unless indices
+
+        indices.include?(match.pattern_index)
+      end
+    end
+
     def build_edits
       @edits = []
-      found_matches = matches
-
-      found_matches.each do |match|
-        @operations.each do |operation|
-          # Find captures matching this operation
-          captures = match.captures.select { |c| c.name == operation.capture_name }
-
-          captures.each do |capture|
-            node = capture.node
-            range = node.range
-
-            case operation.type
-            when :replace
-              replacement = operation.transformer.call(node)
-              @edits << {
-                start_byte: range.start_byte,
-                end_byte: range.end_byte,
-                replacement: replacement.to_s,
-              }
-
-            when :remove
-              @edits << {
-                start_byte: range.start_byte,
-                end_byte: range.end_byte,
-                replacement: "",
-              }
-
-            when :insert_before
-              content = operation.transformer.call(node)
-              @edits << {
-                start_byte: range.start_byte,
-                end_byte: range.start_byte,
-                replacement: content.to_s,
-              }
-
-            when :insert_after
-              content = operation.transformer.call(node)
-              @edits << {
-                start_byte: range.end_byte,
-                end_byte: range.end_byte,
-                replacement: content.to_s,
-              }
-
-            when :wrap
-              @edits << {
-                start_byte: range.start_byte,
-                end_byte: range.start_byte,
-                replacement: operation.before,
-              }
-              @edits << {
-                start_byte: range.end_byte,
-                end_byte: range.end_byte,
-                replacement: operation.after,
-              }
-
-            when :wrap_dynamic
-              before_text, after_text = operation.transformer.call(node)
-              @edits << {
-                start_byte: range.start_byte,
-                end_byte: range.start_byte,
-                replacement: before_text.to_s,
-              }
-              @edits << {
-                start_byte: range.end_byte,
-                end_byte: range.end_byte,
-                replacement: after_text.to_s,
-              }
-            end
+
+      @groups.each do |group|
+        group_matches(group).each do |match|
+          build_match_edits(group, match)
+        end
+      end
+    end
+
+    def build_match_edits(group, match)
+      group.operations.each do |operation|
+        next unless pattern_applies?(group, operation.pattern, match)
+
+        if operation.type == :replace_match
+          nodes = match.captures.map(&:node)
+          next if nodes.empty?
+
+          start_node = nodes.min_by(&:start_byte)
+          end_node = nodes.max_by(&:end_byte)
+          range = TreeSitter::Range.new(
+            start_node.start_byte,
+            end_node.end_byte,
+            start_node.start_point,
+            end_node.end_point,
+          )
+          next unless within_scope?(range)
+
+          @edits << {
+            start_byte: range.start_byte,
+            end_byte: range.end_byte,
+            replacement: operation_text(group, operation, match, start_node, range.start_byte, range.end_byte),
+          }
+          next
+        end
+
+        # Find captures matching this operation
+        captures = match.captures.select { |c| c.name == operation.capture_name }
+
+        captures.each do |capture|
+          node = capture.node
+          range = node.range
+          next unless within_scope?(range)
+
+          case operation.type
+          when :replace
+            replacement = operation_text(group, operation, match, node, range.start_byte, range.end_byte)
+            @edits << {
+              start_byte: range.start_byte,
+              end_byte: range.end_byte,
+              replacement: replacement.to_s,
+            }
+
+          when :remove
+            @edits << {
+              start_byte: range.start_byte,
+              end_byte: range.end_byte,
+              replacement: "",
+            }
+
+          when :insert_before
+            content = operation_text(group, operation, match, node, range.start_byte, range.start_byte)
+            @edits << {
+              start_byte: range.start_byte,
+              end_byte: range.start_byte,
+              replacement: content.to_s,
+            }
+
+          when :insert_after
+            content = operation_text(group, operation, match, node, range.end_byte, range.end_byte)
+            @edits << {
+              start_byte: range.end_byte,
+              end_byte: range.end_byte,
+              replacement: content.to_s,
+            }
+
+          when :wrap
+            @edits << {
+              start_byte: range.start_byte,
+              end_byte: range.start_byte,
+              replacement: operation.before,
+            }
+            @edits << {
+              start_byte: range.end_byte,
+              end_byte: range.end_byte,
+              replacement: operation.after,
+            }
+
+          when :wrap_dynamic
+            before_text, after_text = operation.transformer.call(node)
+            @edits << {
+              start_byte: range.start_byte,
+              end_byte: range.start_byte,
+              replacement: before_text.to_s,
+            }
+            @edits << {
+              start_byte: range.end_byte,
+              end_byte: range.end_byte,
+              replacement: after_text.to_s,
+            }
           end
         end
       end
     end
 
+    # The text a replace or insert operation produces for a node of a match,
+    # to be placed at start_byte...end_byte
+    def operation_text(group, operation, match, node, start_byte, end_byte)
+      unless operation.template
+        argument = operation.type == :replace_match ? match : node
+        text = operation.transformer.call(argument).to_s
+        return text unless operation.reindent
+
+        @indentation ||= Formatting::IndentationDetector.new(@source, tree: @tree, language: @language)
+        return @indentation.reindent(text, start_byte, end_byte: end_byte)
+      end
+
+      line_start = node.start_byte.zero? ? 0 : (@source.byterindex("\n", node.start_byte - 1) || -1) + 1
+      indent = @source.byteslice(line_start...node.start_byte)[/\A[ \t]*/]
+      QueryTemplate.new(operation.template, group.capture_names).render(match, @source, indent: indent, language: @language)
+    end
+
+    # Captured nodes are only edited if they intersect one of the only_within
+    # ranges, and one of the within ranges (a match found in the cursor's
+    # range can have captures outside it)
+    def within_scope?(range)
+      [@only_within, @within].all? do |scopes|
+        scopes.nil? || scopes.any? { |scope| scope.intersects?(range) }
+      end
+    end
+
     def apply_edits
       # Sort by position descending to apply from end to start
       sorted = @edits.sort_by { |e| [-e[:start_byte], -e[:end_byte]] }
 
       result = @source.dup
       sorted.each do |edit|
-        result[edit[:start_byte]...edit[:end_byte]] = edit[:replacement]
+        result.bytesplice(edit[:start_byte]...edit[:end_byte], edit[:replacement])
       end
       result
     end
@@ -0,0 +1,114 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Replacement text built from the captures of a query match
+  #
+  # `@name` is replaced by the text of that capture, and `{{@name | filter}}`
+  # by the text passed through filters (upcase, downcase, capitalize, strip,
+  # snake_case, camel_case, pascal_case). Use the braces for capture names
+  # with dots (`{{@function.name}}`) and `@@` for a literal `@`. A bare `@word`
+  # that is not a capture of the query is left alone.
+  #
+  # A capture that matched several nodes (`(_)* @args`) stands for the
+  # source from the first to the last, so their separators are kept. A
+  # capture that did not match is empty.
+  #
+  # Lines of a multi-line capture are re-indented from the capture's own
+  # indentation to that of the template line it is placed on (except lines
+  # inside multi-line strings), and every template line after the first is
+  # indented like the replaced node.
+  #
+  # @example
+  #   template = TreeSitter::QueryTemplate.new("@recv.try_into()?", ["recv", "call"])
+  #   template.render(match, source)
+  #
+  class QueryTemplate
+    PLACEHOLDER = /@@|\{\{\s*@([\w.]+)((?:\s*\|\s*\w+)*)\s*\}\}|@([A-Za-z_]\w*)/
+
+    FILTERS = ["upcase", "downcase", "capitalize", "strip", "snake_case", "camel_case", "pascal_case"].freeze
+
+    attr_reader :template
+
+    # @param template [String] The template
+    # @param capture_names [Array<String>] The capture names of the query
+    # @raise [ArgumentError] If a `{{...}}` placeholder names an unknown capture or filter
+    def initialize(template, capture_names)
+      @template = template.to_s
+      @capture_names = capture_names.map(&:to_s)
+      validate
+    end
+
+    # @param match [TreeSitter::QueryMatch] The match to take captures from
+    # @param source [String] The source the match was made in
+    # @param indent [String] Indentation of the line the text will be placed on
+    # @param language [String, TreeSitter::Language, nil] Language of the source, for finding its strings
+    # @return [String]
+    def render(match, source, indent: "", language: nil)
+      lines = @template.split("\n", -1)
+      lines.each_with_index.map do |line, index|
+        line_indent = index.zero? ? indent : indent + line[/\A[ \t]*/]
+        rendered = line.gsub(PLACEHOLDER) do
+          placeholder = Regexp.last_match
+          name = placeholder[1] || placeholder[3]
+          if placeholder[0] == "@@"
+            "@"
+          elsif !@capture_names.include?(name)
+            placeholder[0]
+          else
+            filters(placeholder[2]).reduce(capture_text(match, name, source, line_indent, language)) do |text, filter|
+              apply_filter(filter, text)
+            end
+          end
+        end
+        index.zero? || rendered.empty? ? rendered : indent + rendered
+      end.join("\n")
+    end
+
+    private
+
+    def validate
+      @template.scan(PLACEHOLDER) do |braced, filter_list, _bare|
+        next unless braced
+        raise ArgumentError, "Unknown capture @#{braced} in template" unless @capture_names.include?(braced)
+
+        unknown = filters(filter_list) - FILTERS
+        raise ArgumentError, "Unknown template filter: #{unknown.join(", ")}" unless unknown.empty?
+      end
+    end
+
+    def filters(list)
+      list.to_s.split("|").map(&:strip).reject(&:empty?)
+    end
+
+    def apply_filter(filter, text)
+      words = text.gsub(/([A-Z]+)([A-Z][a-z])/, '\1_\2').gsub(/([a-z\d])([A-Z])/, '\1_\2').tr("-", "_").downcase.split("_")
+      case filter
+      when "snake_case" then words.join("_")
+      when "camel_case" then words.first.to_s + words.drop(1).map(&:capitalize).join
+      when "pascal_case" then words.map(&:capitalize).join
+      else text.public_send(filter)
+      end
+    end
+
+    def capture_text(match, name, source, indent, language)
+      nodes = match.captures.select { |capture| capture.name == name }.map(&:node)
+      return "" if nodes.empty?
+
+      start_byte = nodes.map(&:start_byte).min
+      text = source.byteslice(start_byte...nodes.map(&:end_byte).max)
+      line_start = start_byte.zero? ? 0 : (source.byterindex("\n", start_byte - 1) || -1) + 1
+      original = source.byteslice(line_start...start_byte)[/\A[ \t]*/]
+      # Lines of multi-line strings are part of the string's value
+      verbatim = nodes.flat_map { |node| Formatting.verbatim_ranges(node, base: start_byte, language: language) }
+
+      offset = 0
+      text.split("\n", -1).map do |line|
+        line_offset = offset
+        offset += line.bytesize + 1
+        next line if line_offset.zero? || Formatting.verbatim_line?(verbatim, line_offset)
+
+        line.start_with?(original) ? indent + line.delete_prefix(original) : line
+      end.join("\n")
+    end
+  end
+end
@@ -0,0 +1,50 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Ruby-side additions to the native Range class
+  class Range
+    class << self
+      # Ranges from a node, a range, or a list of them
+      #
+      # @param scope [TreeSitter::Node, TreeSitter::Range, Array, nil] What to convert
+      # @return [Array<TreeSitter::Range>, nil] The ranges, or nil for no scope
+      # @raise [ArgumentError] For anything other than nodes and ranges
+      def wrap(scope)
+        return if scope.nil?
+
+        (scope.is_a?(Array) ? scope : [scope]).map do |item|
+          case item
+          when TreeSitter::Node then item.range
+          when TreeSitter::Range then item
+          else raise ArgumentError, "Expected TreeSitter::Node or TreeSitter::Range, got #{item.class}"
+          end
+        end
+      end
+    end
+
+    # Check whether this range overlaps another range or a node
+    #
+    # Non-empty ranges must share at least one byte. An empty range (such as
+    # the position of a deleted line) intersects anything that contains or
+    # touches its position.
+    #
+    # @param other [TreeSitter::Range, TreeSitter::Node] The range or node to compare with
+    # @return [Boolean] True if the two overlap
+    def intersects?(other)
+      other = other.range if other.is_a?(TreeSitter::Node)
+
+      if size.zero? || other.size.zero?
+        start_byte <= other.end_byte && other.start_byte <= end_byte
+      else
+        start_byte < other.end_byte && other.start_byte < end_byte
+      end
+    end
+
+    # The range's points, as tree dumps, exports and the REPL show them
+    #
+    # @return [String] `[start_row, start_column] - [end_row, end_column]`, zero-based
+    def to_s
+      "[#{start_point.row}, #{start_point.column}] - [#{end_point.row}, #{end_point.column}]"
+    end
+  end
+end
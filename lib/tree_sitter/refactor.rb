@@ -12,6 +12,13 @@ module TreeSitter
   #   TreeSitter::Refactor.rename_symbol(source, tree, lang,
   #     from: "old_name", to: "new_name")
   #
+  # @example Rename a variable inside one function only
+  #   TreeSitter::Refactor.rename_symbol(source, tree, lang,
+  #     from: "x", to: "offset", within: function_node)
+  #
+  # The query-based methods take `within:`, a node or a list of ranges; the
+  # query is then only run on those ranges of the tree.
+  #
   module Refactor
     class << self
       # Rename a symbol (function, variable, type) throughout the code
@@ -22,11 +29,12 @@ module TreeSitter
       # @param from [String] Original name
       # @param to [String] New name
       # @param kind [Symbol] Type of symbol (:identifier, :function, :type, :variable)
+      # @param within [Node, Range, Array<Range>, nil] Only rename inside this node or these ranges
       # @return [String] Modified source code
-      def rename_symbol(source, tree, language, from:, to:, kind: :identifier)
+      def rename_symbol(source, tree, language, from:, to:, kind: :identifier, within: nil)
         query_pattern = build_rename_query(kind)
 
-        QueryRewriter.new(source, tree, language)
+        QueryRewriter.new(source, tree, language, within: within)
           .query(query_pattern)
           .where { |m| match_has_text?(m, from) }
           .replace("@name") { to }
@@ -41,8 +49,9 @@ module TreeSitter
       # @param struct_name [String, nil] Name of struct/class (nil for all)
       # @param from [String] Old field name
       # @param to [String] New field name
+      # @param within [Node, Range, Array<Range>, nil] Only rename inside this node or these ranges
       # @return [String] Modified source code
-      def rename_field(source, tree, language, struct_name: nil, from:, to:)
+      def rename_field(source, tree, language, struct_name: nil, from:, to:, within: nil)
         # Query for field declarations and field accesses
         query_pattern = <<~QUERY
           [
@@ -52,7 +61,7 @@ module TreeSitter
           ]
         QUERY
 
-        QueryRewriter.new(source, tree, language)
+        QueryRewriter.new(source, tree, language, within: within)
           .query(query_pattern)
           .where { |m| match_has_text?(m, from) }
           .replace("@name") { to }
@@ -75,7 +84,7 @@ module TreeSitter
         call_reference = parameters.empty? ? "#{name}()" : "#{name}(#{param_list})"
 
         # Build function definition
-        node_text = source[node.start_byte...node.end_byte]
+        node_text = source.byteslice(node.start_byte...node.end_byte)
         param_decl = parameters.map { |p| "#{p}: _" }.join(", ")
         fn_def = "fn #{name}(#{param_decl}) {\n    #{node_text}\n}"
 
@@ -93,16 +102,16 @@ module TreeSitter
       # @param tree [Tree] Parsed syntax tree
       # @param language [Language] Language for queries
       # @param name [String] Variable name to inline
-      # @param scope [Node, nil] Scope to limit inlining (nil for entire tree)
+      # @param within [Node, Range, Array<Range>, nil] Only inline inside this node or these ranges
+      # @param scope [Node, nil] Same as within (kept for compatibility)
       # @return [String] Modified source code
-      def inline_variable(source, tree, language, name:, scope: nil)
+      def inline_variable(source, tree, language, name:, within: nil, scope: nil)
+        within ||= scope
+
         # Find the variable declaration and its value
         decl_query = "(let_declaration pattern: (identifier) @var_name value: (_) @value)"
 
-        query = TreeSitter::Query.new(language, decl_query)
-        cursor = TreeSitter::QueryCursor.new
-        root = scope || tree.root_node
-        matches = cursor.matches(query, root, source)
+        matches = QueryRewriter.new(source, tree, language, within: within).query(decl_query).matches
 
         # Find the declaration for our variable
         decl_match = matches.find do |m|
@@ -120,7 +129,7 @@ module TreeSitter
         # Find all usages and replace
         usage_query = "(identifier) @usage"
 
-        QueryRewriter.new(source, tree, language)
+        QueryRewriter.new(source, tree, language, within: within)
           .query(usage_query)
           .where { |m| match_has_text?(m, name) && !declaration?(m, source) }
           .replace("@usage") { value_text }
@@ -134,9 +143,10 @@ module TreeSitter
       # @param language [Language] Language for queries
       # @param query_pattern [String] Query to match items
       # @param attribute [String] Attribute to add (e.g., "#[derive(Debug)]")
+      # @param within [Node, Range, Array<Range>, nil] Only change items inside this node or these ranges
       # @return [String] Modified source code
-      def add_attribute(source, tree, language, query_pattern:, attribute:)
-        QueryRewriter.new(source, tree, language)
+      def add_attribute(source, tree, language, query_pattern:, attribute:, within: nil)
+        QueryRewriter.new(source, tree, language, within: within)
           .query(query_pattern)
           .insert_before("@item") { "#{attribute}\n" }
           .rewrite
@@ -149,9 +159,10 @@ module TreeSitter
       # @param language [Language] Language for queries
       # @param query_pattern [String] Query to match items to remove
       # @param capture_name [String] Name of capture to remove
+      # @param within [Node, Range, Array<Range>, nil] Only remove items inside this node or these ranges
       # @return [String] Modified source code
-      def remove_matching(source, tree, language, query_pattern:, capture_name: "@item")
-        QueryRewriter.new(source, tree, language)
+      def remove_matching(source, tree, language, query_pattern:, capture_name: "@item", within: nil)
+        QueryRewriter.new(source, tree, language, within: within)
           .query(query_pattern)
           .remove(capture_name)
           .rewrite
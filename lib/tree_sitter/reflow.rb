@@ -0,0 +1,249 @@
+# frozen_string_literal: true
+
+require_relative "formatting"
+require_relative "rewriter"
+require_relative "separated_list"
+
+module TreeSitter
+  # Line-length-aware layout of bracketed, comma-separated lists: call
+  # arguments, parameter lists, array and hash literals, import lists.
+  #
+  # A list that fits within `max_width` (together with the rest of its
+  # line) is put on one line, joining it if it was broken over several.
+  # A list that does not fit is broken into one element per line, indented
+  # one level deeper than the line it starts on, with a trailing comma where
+  # the language allows one. Lists nested in a broken list are laid out in
+  # turn from their new position.
+  #
+  # Lists with comments between their elements keep their layout (nested
+  # lists are still reflowed). Lines of multi-line strings are left alone.
+  #
+  # @example
+  #   TreeSitter::Reflow.fit(tree, max_width: 80)
+  #
+  class Reflow
+    include SeparatedList
+
+    # Columns a tab counts for when measuring lines
+    TAB_WIDTH = 4
+
+    # Node types of reflowable lists, per language
+    #
+    # `no_trailing_comma` lists the types that do not allow a trailing
+    # comma, `no_comma_after` the element types that cannot be followed by
+    # one, `padded` the types that get spaces inside their brackets on one
+    # line, and `singleton_comma` the types that keep the comma after a
+    # single element (one-element tuples).
+    LANGUAGES = {
+      "rust" => {
+        lists: ["arguments", "parameters", "array_expression", "tuple_expression", "use_list", "field_initializer_list"],
+        no_comma_after: ["base_field_initializer"],
+        padded: ["field_initializer_list"],
+        singleton_comma: ["tuple_expression"],
+      },
+      "ruby" => {
+        lists: ["argument_list", "method_parameters", "array", "hash"],
+        no_trailing_comma: ["method_parameters"],
+        no_comma_after: ["block_argument"],
+        padded: ["hash"],
+      },
+      "python" => {
+        lists: ["argument_list", "parameters", "list", "tuple", "dictionary", "set"],
+        singleton_comma: ["tuple"],
+      },
+      "javascript" => {
+        lists: ["arguments", "formal_parameters", "array", "object", "named_imports", "export_clause"],
+        no_comma_after: ["rest_pattern"],
+        padded: ["object", "named_imports", "export_clause"],
+      },
+      "go" => {
+        lists: ["argument_list", "parameter_list", "literal_value"],
+      },
+      "java" => {
+        lists: ["argument_list", "formal_parameters", "array_initializer"],
+        no_trailing_comma: ["argument_list", "formal_parameters"],
+      },
+      "json" => {
+        lists: ["object", "array"],
+        no_trailing_comma: ["object", "array"],
+      },
+    }.freeze
+
+    class << self
+      # @param language [String, TreeSitter::Language] A language
+      # @return [Boolean] True if lists of the language can be reflowed
+      def supported?(language)
+        LANGUAGES.key?(language_name(language))
+      end
+
+      # Reflow the lists of a tree
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param max_width [Integer] Maximum line width, in characters
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [String] The reflowed source
+      # @raise [ArgumentError] If the language is not supported
+      def fit(tree, max_width: 100, language: nil)
+        rewriter = Rewriter.new(tree.source, tree)
+        edits(tree, max_width: max_width, language: language).each do |start_byte, end_byte, text|
+          rewriter.replace(start_byte...end_byte, text)
+        end
+        rewriter.rewrite
+      end
+
+      # The replacements #fit makes
+      #
+      # @param tree [TreeSitter::Tree] The parsed syntax tree
+      # @param max_width [Integer] Maximum line width, in characters
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [Array<Array(Integer, Integer, String)>] `[start_byte, end_byte, text]` for each outermost list that changes
+      # @raise [ArgumentError] If the language is not supported
+      def edits(tree, max_width: 100, language: nil)
+        name = language_name(language || tree.language)
+        config = LANGUAGES.fetch(name) do
+          raise ArgumentError, "Reflow is not supported for #{name}"
+        end
+
+        new(tree, config, max_width).edits
+      end
+
+      private
+
+      def language_name(language)
+        language.is_a?(TreeSitter::Language) ? language.name : language.to_s
+      end
+    end
+
+    # @param tree [TreeSitter::Tree] The parsed syntax tree
+    # @param config [Hash] An entry of LANGUAGES
+    # @param max_width [Integer] Maximum line width
+    def initialize(tree, config, max_width)
+      @tree = tree
+      @source = tree.source
+      @config = config
+      @max_width = max_width
+      @indentation = Formatting::IndentationDetector.new(@source, tree: tree)
+      @verbatim = Formatting.verbatim_ranges(tree)
+    end
+
+    # @return [Array<Array(Integer, Integer, String)>] See Reflow.edits
+    def edits
+      nested_lists(@tree.root_node).filter_map do |list|
+        column = width(@source.byteslice(line_start(list.start_byte)...list.start_byte))
+        indent = indentation_at(list.start_byte)
+        text = render(list, column, indent, suffix_width(list, @tree.root_node, 0))
+        [list.start_byte, list.end_byte, text] unless text == list.text
+      end
+    end
+
+    private
+
+    def list?(node)
+      @config[:lists].include?(node.kind)
+    end
+
+    # The outermost lists in a node, not counting the node itself
+    def nested_lists(node, result = [])
+      node.children.each do |child|
+        list?(child) ? result << child : nested_lists(child, result)
+      end
+      result
+    end
+
+    # Text of a node laid out starting at column, on a line indented by indent
+    def render(node, column, indent, suffix)
+      list?(node) ? render_list(node, column, indent, suffix) : render_node(node, column, indent, suffix)
+    end
+
+    def render_list(list, column, indent, suffix)
+      elements = separated_members(list)
+      return render_node(list, column, indent, suffix) if elements.nil? || elements.empty?
+
+      flat = flat_text(list)
+      return flat if flat && column + width(flat) + suffix <= @max_width
+
+      inner = indent + indent_unit
+      lines = elements.each_with_index.map do |element, index|
+        comma = index < elements.size - 1 || trailing_comma?(list, element)
+        text = render(element, width(inner), inner, comma ? 1 : 0)
+        "#{inner}#{text}#{"," if comma}"
+      end
+      "#{list.child(0).text}\n#{lines.join("\n")}\n#{indent}#{list.child(list.child_count - 1).text}"
+    end
+
+    # The node's own text with its nested lists rendered, and its other
+    # lines moved from the node's original indentation to indent
+    def render_node(node, column, indent, suffix)
+      from = indentation_at(node.start_byte)
+      result = +""
+      position = node.start_byte
+
+      nested_lists(node).each do |list|
+        result << shifted(position, list.start_byte, from, indent)
+        current_line = result[/[^\n]*\z/]
+        line_indent = result.include?("\n") ? current_line[/\A[ \t]*/] : indent
+        line_column = result.include?("\n") ? width(current_line) : column + width(current_line)
+        result << render(list, line_column, line_indent, suffix_width(list, node, suffix))
+        position = list.end_byte
+      end
+      result << shifted(position, node.end_byte, from, indent)
+    end
+
+    # The list on one line, or nil if it contains line breaks
+    def flat_text(node)
+      elements = list?(node) ? separated_members(node) : nil
+      if elements
+        texts = elements.map { |element| flat_text(element) || (return nil) }
+        body = texts.join(", ")
+        body += "," if texts.size == 1 && @config.fetch(:singleton_comma, []).include?(node.kind)
+        padding = !texts.empty? && @config.fetch(:padded, []).include?(node.kind) ? " " : ""
+        return "#{node.child(0).text}#{padding}#{body}#{padding}#{node.child(node.child_count - 1).text}"
+      end
+
+      result = +""
+      position = node.start_byte
+      nested_lists(node).each do |list|
+        result << @source.byteslice(position...list.start_byte)
+        result << (flat_text(list) || (return nil))
+        position = list.end_byte
+      end
+      result << @source.byteslice(position...node.end_byte)
+      result unless result.include?("\n")
+    end
+
+    def trailing_comma?(list, element)
+      !@config.fetch(:no_trailing_comma, []).include?(list.kind) &&
+        !@config.fetch(:no_comma_after, []).include?(element.kind)
+    end
+
+    # Source between two offsets, with the lines after the first moved from
+    # one indentation to another (lines inside multi-line strings are kept)
+    def shifted(start_byte, end_byte, from, to)
+      text = @source.byteslice(start_byte...end_byte)
+      offset = start_byte
+      text.split("\n", -1).each_with_index.map do |line, index|
+        line_start = offset
+        offset += line.bytesize + 1
+        next line if index.zero? || Formatting.verbatim_line?(@verbatim, line_start)
+        next line.lstrip if line.strip.empty?
+
+        line.start_with?(from) ? to + line.delete_prefix(from) : line
+      end.join("\n")
+    end
+
+    # Width of what follows a list on its line, up to the end of node; past
+    # the node's end, `suffix` more columns follow
+    def suffix_width(list, node, suffix)
+      newline = @source.byteindex("\n", list.end_byte)
+      if newline && newline < node.end_byte
+        width(@source.byteslice(list.end_byte...newline).rstrip)
+      else
+        width(@source.byteslice(list.end_byte...node.end_byte).rstrip) + suffix
+      end
+    end
+
+    def width(text)
+      text.length + (text.count("\t") * (TAB_WIDTH - 1))
+    end
+  end
+end
@@ -0,0 +1,251 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Interactive playground for exploring a syntax tree and trying out queries.
+  #
+  # Lines that start with `(` or `[` are treated as queries; a query may span
+  # several lines and is run once its brackets are balanced. Everything else is
+  # a command (see `help`).
+  #
+  # @example Start a session on a file
+  #   tree = parser.parse(source)
+  #   TreeSitter::Repl.new(source, tree).run
+  #
+  class Repl
+    PROMPT = "ts> "
+    CONTINUATION_PROMPT = "..> "
+
+    HELP = <<~HELP
+      Commands:
+        tree [DEPTH]        Show the tree below the current node
+        cd child N          Move to the Nth child (also: cd N)
+        cd named N          Move to the Nth named child
+        cd field NAME       Move to the child stored under field NAME
+        up                  Move to the parent node
+        top                 Move back to the root node
+        fields              List the children of the current node with their fields
+        text                Print the source text of the current node
+        query PATTERN       Run a query against the current node
+        save PATH           Save the last query that produced matches to PATH(.scm)
+        help                Show this message
+        quit                Leave the playground
+      A line starting with "(" or "[" is run as a query.
+    HELP
+
+    HIGHLIGHT_START = "\e[1;33m"
+    HIGHLIGHT_END = "\e[0m"
+
+    attr_reader :source, :tree, :current, :last_query
+
+    # Initialize a new Repl
+    #
+    # @param source [String] The source code that was parsed
+    # @param tree [TreeSitter::Tree] The parsed syntax tree
+    # @param input [IO] Where commands are read from
+    # @param output [IO] Where results are written to
+    # @param color [Boolean, nil] Highlight matches with ANSI colors (defaults to output.tty?)
+    def initialize(source, tree, input: $stdin, output: $stdout, color: nil)
+      @source = source
+      @tree = tree
+      @input = input
+      @output = output
+      @color = color.nil? ? output.respond_to?(:tty?) && output.tty? : color
+      @current = tree.root_node
+      @path = []
+      @last_query = nil
+    end
+
+    # Read and evaluate commands until `quit` or end of input
+    #
+    # @return [void]
+    def run
+      print_tree(@current, max_depth: 2)
+
+      loop do
+        @output.print(PROMPT)
+        line = @input.gets
+        break if line.nil?
+
+        line = line.strip
+        next if line.empty?
+
+        if query_start?(line)
+          run_query(read_query(line))
+        else
+          break if evaluate(line) == :quit
+        end
+      end
+    end
+
+    # Evaluate a single command line
+    #
+    # @param line [String] The command to run
+    # @return [Symbol, nil] :quit when the session should end
+    def evaluate(line)
+      command, *args = line.split(/\s+/)
+
+      case command
+      when "tree" then print_tree(@current, max_depth: args.first&.to_i)
+      when "cd" then change_node(args)
+      when "up" then move_up
+      when "top" then move_to_root
+      when "fields" then print_fields
+      when "text" then @output.puts(@current.text)
+      when "query" then run_query(line.delete_prefix("query").strip)
+      when "save" then save_query(args.first)
+      when "help", "?" then @output.puts(HELP)
+      when "quit", "exit" then return :quit
+      else
+        @output.puts("Unknown command: #{command} (type `help` for a list)")
+      end
+
+      nil
+    end
+
+    # Run a query against the current node and print every match
+    #
+    # @param pattern [String] Tree-sitter query pattern
+    # @return [Array<QueryMatch>] The matches found
+    def run_query(pattern)
+      query = TreeSitter::Query.new(@tree.language, pattern)
+      matches = TreeSitter::QueryCursor.new.matches(query, @current, @source)
+
+      @last_query = pattern unless matches.empty?
+      @output.puts("#{matches.length} match#{"es" unless matches.length == 1}")
+
+      matches.each_with_index do |match, index|
+        @output.puts("match #{index} (pattern #{match.pattern_index})")
+        match.captures.each do |capture|
+          node = capture.node
+          @output.puts("  @#{capture.name} #{node.kind} #{node.range}")
+          excerpt(node).each_line { |l| @output.puts("    #{l.chomp}") }
+        end
+      end
+
+      matches
+    rescue SyntaxError, ArgumentError => e
+      @output.puts(e.message)
+      []
+    end
+
+    private
+
+    def query_start?(line)
+      line.start_with?("(", "[")
+    end
+
+    # Keep reading lines until the brackets of a multi-line query balance
+    def read_query(first_line)
+      pattern = +first_line
+      while unbalanced?(pattern)
+        @output.print(CONTINUATION_PROMPT)
+        line = @input.gets
+        break if line.nil?
+
+        pattern << "\n" << line.chomp
+      end
+      pattern
+    end
+
+    def unbalanced?(pattern)
+      stripped = pattern.gsub(/"(?:[^"\\]|\\.)*"/, "").gsub(/;.*$/, "")
+      stripped.count("(") > stripped.count(")") || stripped.count("[") > stripped.count("]")
+    end
+
+    def change_node(args)
+      selector, value = args.length == 1 ? ["child", args.first] : args
+
+      target = case selector
+      when "child"
+        index = child_index(value, @current.child_count)
+        @current.child(index) if index
+      when "named"
+        index = child_index(value, @current.named_child_count)
+        @current.named_child(index) if index
+      when "field" then @current.child_by_field_name(value.to_s)
+      end
+
+      if target
+        @path << @current
+        @current = target
+        print_tree(@current, max_depth: 1)
+      else
+        @output.puts("No such child: #{args.join(" ")}")
+      end
+    end
+
+    # The index a `cd` argument names, or nil if it is not one below count
+    def child_index(value, count)
+      index = Integer(value.to_s, 10, exception: false)
+      index if index && index >= 0 && index < count
+    end
+
+    def move_up
+      parent = @path.pop || @current.parent
+      if parent
+        @current = parent
+        print_tree(@current, max_depth: 1)
+      else
+        @output.puts("Already at the root")
+      end
+    end
+
+    def move_to_root
+      @path.clear
+      @current = @tree.root_node
+      print_tree(@current, max_depth: 1)
+    end
+
+    def print_fields
+      @current.children.each_with_index do |child, index|
+        field = @current.field_name_for_child(index)
+        label = field ? "#{field}: " : ""
+        @output.puts("#{index}: #{label}#{describe(child)}")
+      end
+    end
+
+    def save_query(path)
+      if path.nil? || path.empty?
+        @output.puts("Usage: save PATH")
+      elsif @last_query.nil?
+        @output.puts("No query with matches to save yet")
+      else
+        path = "#{path}.scm" unless path.end_with?(".scm")
+        File.write(path, "#{@last_query}\n")
+        @output.puts("Saved query to #{path}")
+      end
+    end
+
+    def print_tree(node, max_depth: nil, depth: 0, field: nil)
+      label = field ? "#{field}: " : ""
+      @output.puts("#{"  " * depth}#{label}#{describe(node)}")
+      return if max_depth && depth >= max_depth
+
+      node.children.each_with_index do |child, index|
+        next unless child.named?
+
+        print_tree(child, max_depth: max_depth, depth: depth + 1, field: node.field_name_for_child(index))
+      end
+    end
+
+    def describe(node)
+      description = "(#{node.kind} #{node.range})"
+      description += " #{node.text.inspect}" if node.child_count.zero?
+      description
+    end
+
+    # The source lines containing the node, with the node itself highlighted
+    def excerpt(node)
+      line_start = node.start_byte.zero? ? 0 : (@source.byterindex("\n", node.start_byte - 1) || -1) + 1
+      line_end = @source.byteindex("\n", node.end_byte) || @source.bytesize
+
+      before = @source.byteslice(line_start...node.start_byte)
+      inner = @source.byteslice(node.start_byte...node.end_byte)
+      after = @source.byteslice(node.end_byte...line_end)
+
+      return "#{before}#{inner}#{after}" unless @color
+
+      "#{before}#{HIGHLIGHT_START}#{inner}#{HIGHLIGHT_END}#{after}"
+    end
+  end
+end
@@ -12,6 +12,16 @@ module TreeSitter
   #     .replace(fn_name, "new_name")
   #     .rewrite
   #
+  # Besides nodes and ranges, the editing methods accept anything
+  # PositionIndex#resolve does: points, `[row, column]` pairs, pairs of
+  # them, Ruby ranges of byte offsets and Lines.
+  #
+  # @example Edit at positions reported by a linter (one-based, UTF-16 columns)
+  #   TreeSitter::Rewriter.new(source, tree, column_unit: :utf16, one_based: true)
+  #     .replace([[3, 5], [3, 12]], "value")
+  #     .remove(TreeSitter::Lines.new(10..12))
+  #     .rewrite
+  #
   class Rewriter
     # Represents a single edit operation
     Edit = Struct.new(:start_byte, :end_byte, :replacement, keyword_init: true)
@@ -23,16 +33,23 @@ module TreeSitter
     # @param source [String] The source code to rewrite
     # @param tree [TreeSitter::Tree, nil] Optional parsed tree (will parse if not provided)
     # @param parser [TreeSitter::Parser, nil] Optional parser for re-parsing (needed if tree not provided)
-    def initialize(source, tree = nil, parser: nil)
+    # @param only_within [Array<TreeSitter::Range>, nil] Ignore edits to nodes outside these ranges
+    # @param column_unit [Symbol] Unit of the columns of `[row, column]` targets
+    #   (:byte, :char or :utf16, see PositionIndex)
+    # @param one_based [Boolean] True if rows and columns of `[row, column]` and Lines targets count from 1
+    def initialize(source, tree = nil, parser: nil, only_within: nil, column_unit: :byte, one_based: false)
       @source = source.dup.freeze
       @tree = tree
       @parser = parser
+      @only_within = only_within
+      @column_unit = PositionIndex.validate_unit(column_unit)
+      @one_based = one_based
       @edits = []
     end
 
     # Remove the text at the given node or range
     #
-    # @param node_or_range [TreeSitter::Node, TreeSitter::Range] The node or range to remove
+    # @param node_or_range [TreeSitter::Node, TreeSitter::Range, Object] The node or range to remove
     # @return [self] Returns self for method chaining
     def remove(node_or_range)
       replace(node_or_range, "")
@@ -40,52 +57,62 @@ module TreeSitter
 
     # Replace the text at the given node or range with new content
     #
-    # @param node_or_range [TreeSitter::Node, TreeSitter::Range] The node or range to replace
+    # @param node_or_range [TreeSitter::Node, TreeSitter::Range, Object] The node or range to replace
     # @param content [String] The replacement content
+    # @param reindent [Boolean] Indent the lines after the first to the node's position
+    #   (the content is taken to be written at column zero)
     # @return [self] Returns self for method chaining
-    def replace(node_or_range, content)
+    def replace(node_or_range, content, reindent: false)
       range = normalize_range(node_or_range)
+      return self unless within_scope?(range)
+
       @edits << Edit.new(
         start_byte: range.start_byte,
         end_byte: range.end_byte,
-        replacement: content.to_s,
+        replacement: reindent ? reindented(content, range.start_byte, range.end_byte) : content.to_s,
       )
       self
     end
 
     # Insert text before the given node or range
     #
-    # @param node_or_range [TreeSitter::Node, TreeSitter::Range] The node or range
+    # @param node_or_range [TreeSitter::Node, TreeSitter::Range, Object] The node or range
     # @param content [String] The content to insert
+    # @param reindent [Boolean] Indent the lines after the first to the node's position
     # @return [self] Returns self for method chaining
-    def insert_before(node_or_range, content)
+    def insert_before(node_or_range, content, reindent: false)
       range = normalize_range(node_or_range)
+      return self unless within_scope?(range)
+
       @edits << Edit.new(
         start_byte: range.start_byte,
         end_byte: range.start_byte,
-        replacement: content.to_s,
+        replacement: reindent ? reindented(content, range.start_byte, range.start_byte) : content.to_s,
       )
       self
     end
 
     # Insert text after the given node or range
     #
-    # @param node_or_range [TreeSitter::Node, TreeSitter::Range] The node or range
+    # @param node_or_range [TreeSitter::Node, TreeSitter::Range, Object] The node or range
     # @param content [String] The content to insert
+    # @param reindent [Boolean] Indent the lines after the first to the end of the node's line
     # @return [self] Returns self for method chaining
-    def insert_after(node_or_range, content)
+    def insert_after(node_or_range, content, reindent: false)
       range = normalize_range(node_or_range)
+      return self unless within_scope?(range)
+
       @edits << Edit.new(
         start_byte: range.end_byte,
         end_byte: range.end_byte,
-        replacement: content.to_s,
+        replacement: reindent ? reindented(content, range.end_byte, range.end_byte) : content.to_s,
       )
       self
     end
 
     # Wrap the node or range with before and after text
     #
-    # @param node_or_range [TreeSitter::Node, TreeSitter::Range] The node or range to wrap
+    # @param node_or_range [TreeSitter::Node, TreeSitter::Range, Object] The node or range to wrap
     # @param before_text [String] Text to insert before
     # @param after_text [String] Text to insert after
     # @return [self] Returns self for method chaining
@@ -95,6 +122,39 @@ module TreeSitter
       self
     end
 
+    # Add edits from another tool given as LSP text edits, e.g. the
+    # `WorkspaceEdit` of a code action
+    #
+    # @param edits [Array<Hash>, Hash, String] A `TextEdit[]` or `WorkspaceEdit` (or its JSON)
+    # @param uri [String, nil] This document's URI, to pick its edits out of a workspace edit
+    # @return [self] Returns self for method chaining
+    # @raise [TreeSitter::ConflictError] If an edit overlaps one already made
+    def apply_lsp_edits(edits, uri: nil)
+      incoming = LSP.byte_edits(positions, LSP.text_edits_for(edits, uri))
+      # Let ChangeSet check the new edits against the existing ones
+      ChangeSet.new
+        .add(nil, @source, @edits, origin: "rewriter")
+        .add(nil, @source, incoming, origin: "lsp")
+
+      incoming.each do |start_byte, end_byte, replacement|
+        edit = Edit.new(start_byte: start_byte, end_byte: end_byte, replacement: replacement)
+        # An edit this rewriter already makes is not made twice
+        @edits << edit unless @edits.include?(edit)
+      end
+      self
+    end
+
+    # @return [Array<Hash>] The edits as LSP `TextEdit`s (UTF-16 positions)
+    def to_text_edits
+      LSP.text_edits(positions, @edits)
+    end
+
+    # @param uri [String] The URI of the document being rewritten
+    # @return [Hash] The edits as an LSP `WorkspaceEdit`
+    def to_workspace_edit(uri)
+      { "changes" => { uri.to_s => to_text_edits } }
+    end
+
     # Apply all accumulated edits and return the new source code
     #
     # Edits are applied in reverse order (from end to start) to preserve
@@ -108,7 +168,7 @@ module TreeSitter
 
       result = @source.dup
       sorted.each do |edit|
-        result[edit.start_byte...edit.end_byte] = edit.replacement
+        result.bytesplice(edit.start_byte...edit.end_byte, edit.replacement)
       end
       result
     end
@@ -127,20 +187,46 @@ module TreeSitter
       [new_source, new_tree]
     end
 
+    # Export the edits to a ChangeSet, to combine them with the edits of
+    # other tools or save them for later
+    #
+    # @param path [String, nil] The file the source was read from
+    # @return [TreeSitter::ChangeSet]
+    # @raise [TreeSitter::ConflictError] If the edits overlap each other
+    def to_change_set(path: nil)
+      ChangeSet.new.add(path, @source, @edits, origin: "rewriter")
+    end
+
     private
 
-    def normalize_range(node_or_range)
-      case node_or_range
+    def normalize_range(target)
+      case target
       when TreeSitter::Node
-        node_or_range.range
+        target.range
       when TreeSitter::Range
-        node_or_range
+        target
       else
-        raise ArgumentError,
-          "Expected TreeSitter::Node or TreeSitter::Range, got #{node_or_range.class}"
+        positions.resolve(target, unit: @column_unit, one_based: @one_based)
       end
     end
 
+    # The index of the source's line positions, shared by all conversions
+    def positions
+      @positions ||= PositionIndex.new(@source)
+    end
+
+    # The detector parses the content in the source's language to leave the
+    # lines of its multi-line strings as they are
+    def reindented(content, start_byte, end_byte)
+      @indentation ||= Formatting::IndentationDetector.new(@source, tree: @tree, language: @parser&.language)
+      @indentation.reindent(content.to_s, start_byte, end_byte: end_byte)
+    end
+
+    # Edits are only kept for nodes that intersect one of the only_within ranges
+    def within_scope?(range)
+      @only_within.nil? || @only_within.any? { |scope| scope.intersects?(range) }
+    end
+
     def create_parser_from_tree
       return unless @tree
 
@@ -0,0 +1,217 @@
+# frozen_string_literal: true
+
+require "digest"
+require_relative "text_diff"
+
+module TreeSitter
+  # QueryRewriter rules applied over and over until the source stops
+  # changing.
+  #
+  # Some rewrites produce new matches: rewriting the outer `unwrap()` of
+  # `a.unwrap().unwrap()` leaves the inner one, and flattening a nested
+  # conditional can expose another. Each pass runs every rule in turn,
+  # reparsing after a rule that changed the source (incrementally, from the
+  # previous tree). The run stops when a pass changes nothing, when a pass
+  # produces a source that was already seen (the rules oscillate), or after
+  # `max_passes`.
+  #
+  # Within one rule, an edit that overlaps another one earlier in the source
+  # (usually a match nested in another match) is left for the next pass.
+  #
+  # @example
+  #   result = TreeSitter::RuleSet.new("rust")
+  #     .rule(:try) do |r|
+  #       r.query('(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @m)
+  #                (#eq? @m "unwrap")) @call')
+  #         .replace("@call", template: "@recv?")
+  #     end
+  #     .run(source, tree)
+  #   result.source
+  #   result.pass_count
+  #
+  class RuleSet
+    # A named rule: either a block that configures a fresh QueryRewriter,
+    # or a QueryRewriter whose queries and operations are reused
+    Rule = Struct.new(:name, :rewriter, :configure, keyword_init: true)
+
+    # The edits one rule made, as `{ start_byte:, end_byte:, original:,
+    # replacement: }` hashes against the source as it was when the rule ran
+    RuleEdits = Struct.new(:rule, :edits, keyword_init: true)
+
+    # A pass that changed the source
+    Pass = Struct.new(:number, :changes, keyword_init: true) do
+      # @return [Array<Hash>] The edits of all rules in this pass
+      def edits
+        changes.flat_map(&:edits)
+      end
+    end
+
+    # The outcome of #run
+    #
+    # Status is :stable (the last pass changed nothing), :oscillating (a
+    # pass produced a source that was seen before) or :max_passes (the
+    # source was still changing). Passes lists the passes that changed the
+    # source.
+    Result = Struct.new(:source, :tree, :passes, :status, keyword_init: true) do
+      # @return [Boolean] True if the rules reached a fixpoint
+      def stable?
+        status == :stable
+      end
+
+      # @return [Integer] The number of passes that changed the source
+      def pass_count
+        passes.size
+      end
+    end
+
+    attr_reader :rules
+
+    # @param language [TreeSitter::Language, String] The language for queries
+    # @param parser [TreeSitter::Parser, nil] Parser for re-parsing between rules
+    def initialize(language, parser: nil)
+      @language = language
+      @parser = parser
+      @rules = []
+    end
+
+    # Add a rule
+    #
+    # @param name [String, Symbol, nil] Name reported with the rule's edits
+    # @param rewriter [QueryRewriter, nil] A rewriter to reuse the queries and operations of
+    # @yield [QueryRewriter] A fresh rewriter for the current source, to add queries and operations to
+    # @return [self] For method chaining
+    def rule(name = nil, rewriter: nil, &configure)
+      raise ArgumentError, "Give a rewriter or a block" unless rewriter.nil? ^ configure.nil?
+
+      @rules << Rule.new(name: (name || "rule #{@rules.size + 1}").to_s, rewriter: rewriter, configure: configure)
+      self
+    end
+
+    # Apply the rules until the source stops changing
+    #
+    # @param source [String] The source code to rewrite
+    # @param tree [TreeSitter::Tree] Its syntax tree
+    # @param max_passes [Integer] Maximum number of passes
+    # @param only_within [Array<TreeSitter::Range>, nil] Only edit nodes intersecting these
+    #   ranges; they are moved along with the edits of each pass
+    # @param within [TreeSitter::Node, TreeSitter::Range, Array, nil] Only run the queries on
+    #   this node or these ranges; they are moved along with the edits of each pass
+    # @return [Result]
+    def run(source, tree, max_passes: 10, only_within: nil, within: nil)
+      within = TreeSitter::Range.wrap(within)
+      seen = [Digest::SHA256.hexdigest(source)]
+      passes = []
+
+      max_passes.times do |index|
+        changes = []
+        @rules.each do |rule|
+          edits = non_overlapping(rewriter_for(rule, source, tree, only_within, within).preview_edits)
+          new_source = apply_edits(source, edits)
+          next if new_source == source
+
+          changes << RuleEdits.new(rule: rule.name, edits: edits)
+          only_within = move_scopes(only_within, edits, new_source)
+          within = move_scopes(within, edits, new_source)
+          tree = reparse(tree, source, new_source)
+          source = new_source
+        end
+        return Result.new(source: source, tree: tree, passes: passes, status: :stable) if changes.empty?
+
+        passes << Pass.new(number: index + 1, changes: changes)
+        digest = Digest::SHA256.hexdigest(source)
+        return Result.new(source: source, tree: tree, passes: passes, status: :oscillating) if seen.include?(digest)
+
+        seen << digest
+      end
+
+      Result.new(source: source, tree: tree, passes: passes, status: :max_passes)
+    end
+
+    private
+
+    def rewriter_for(rule, source, tree, only_within, within)
+      if rule.rewriter
+        rule.rewriter.for_source(source, tree, only_within: only_within, within: within)
+      else
+        rewriter = QueryRewriter.new(source, tree, @language, parser: @parser, only_within: only_within, within: within)
+        rule.configure.call(rewriter)
+        rewriter
+      end
+    end
+
+    # Keep the outermost of overlapping edits; insertions at the boundary of
+    # a replacement are kept, and come before it
+    def non_overlapping(edits)
+      reach = 0
+      edits.each_with_index
+        .sort_by { |edit, index| [edit[:start_byte], edit[:start_byte] == edit[:end_byte] ? 0 : 1, -edit[:end_byte], index] }
+        .each_with_object([]) do |(edit, _index), kept|
+          next if edit[:start_byte] < reach
+
+          kept << edit
+          reach = [reach, edit[:end_byte]].max
+        end
+    end
+
+    def apply_edits(source, edits)
+      result = +""
+      position = 0
+      edits.each do |edit|
+        result << source.byteslice(position...edit[:start_byte])
+        result << edit[:replacement]
+        position = edit[:end_byte]
+      end
+      result << source.byteslice(position..)
+    end
+
+    def reparse(tree, old_source, new_source)
+      parser = @parser || create_parser(tree)
+      raise "No parser available for re-parsing" unless parser
+
+      new_tree, = parser.reparse(tree, new_source, old_source: old_source) if tree
+      new_tree || parser.parse(new_source)
+    end
+
+    def create_parser(tree)
+      name = @language.is_a?(String) ? @language : (@language || tree&.language)&.name
+      return unless name
+
+      parser = TreeSitter::Parser.new
+      parser.language = name
+      parser
+    end
+
+    # Move the only_within ranges to where their text is after the edits;
+    # a range boundary inside an edited span is moved to include all of it
+    def move_scopes(scopes, edits, new_source)
+      return unless scopes
+
+      sorted = edits.sort_by { |edit| edit[:start_byte] }
+      scopes.map do |scope|
+        start_byte = moved_offset(scope.start_byte, sorted, :start)
+        end_byte = moved_offset(scope.end_byte, sorted, :end)
+        TreeSitter::Range.new(
+          start_byte,
+          end_byte,
+          TextDiff.point_at(new_source, start_byte),
+          TextDiff.point_at(new_source, end_byte),
+        )
+      end
+    end
+
+    def moved_offset(offset, edits, side)
+      shift = 0
+      edits.each do |edit|
+        break if edit[:start_byte] >= offset
+
+        if edit[:end_byte] > offset
+          new_start = edit[:start_byte] + shift
+          return side == :start ? new_start : new_start + edit[:replacement].bytesize
+        end
+
+        shift += edit[:replacement].bytesize - (edit[:end_byte] - edit[:start_byte])
+      end
+      offset + shift
+    end
+  end
+end
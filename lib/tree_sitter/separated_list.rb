@@ -0,0 +1,127 @@
+# frozen_string_literal: true
+
+require_relative "formatting"
+
+module TreeSitter
+  # Editing of bracketed, comma-separated lists: JSON and TOML arrays and
+  # objects, call arguments, parameter lists and the like.
+  #
+  # Mixed into classes that have the source in `@source` and a
+  # Formatting::IndentationDetector for it in `@indentation`. Edits are
+  # `[start_byte, end_byte, replacement]` triples.
+  module SeparatedList
+    private
+
+    # The members of a bracketed list, or nil if it is not one: the first
+    # and last children are not brackets, or there are comments or
+    # separators other than commas between the members
+    def separated_members(container)
+      return if container.child_count < 2
+      return if container.child(0).named? || container.child(container.child_count - 1).named?
+
+      inner = container.children[1...-1]
+      return if inner.any? { |child| child.extra? || (!child.named? && child.kind != ",") }
+
+      members = inner.select(&:named?)
+      members if inner.count { |child| child.kind == "," } >= members.size - 1
+    end
+
+    # Remove one member of a bracketed collection along with its separator
+    def remove_member_edits(container, members, member)
+      index = members.index(member)
+      following = members[index + 1]
+      preceding = index.positive? ? members[index - 1] : nil
+
+      if following
+        if first_on_line?(member) && following.start_point.row > member.start_point.row
+          [[line_start(member.start_byte), line_start(following.start_byte), ""]]
+        else
+          [[member.start_byte, following.start_byte, ""]]
+        end
+      elsif preceding
+        [[preceding.end_byte, member.end_byte, ""]]
+      else
+        [[container.child(0).end_byte, container.child(container.child_count - 1).start_byte, ""]]
+      end
+    end
+
+    # Add a member after the last one, following the collection's layout:
+    # one member per line, or all on one line
+    def add_to_collection_edits(container, members, text, empty: nil)
+      last = members.last
+      if last.nil?
+        open = container.child(0).end_byte
+        close = container.child(container.child_count - 1).start_byte
+        # Keep whatever is inside an empty collection unless it is blank
+        close = open unless @source.byteslice(open...close).strip.empty?
+        return [[open, close, empty ? empty.call(text) : text]]
+      end
+
+      comma = trailing_comma(container, last)
+      if last.start_point.row > container.start_point.row
+        indent = member_indent(container, members)
+        if comma
+          [[comma.end_byte, comma.end_byte, "\n#{indent}#{text},"]]
+        else
+          [[last.end_byte, last.end_byte, ",\n#{indent}#{text}"]]
+        end
+      elsif comma
+        [[comma.end_byte, comma.end_byte, " #{text},"]]
+      else
+        [[last.end_byte, last.end_byte, ", #{text}"]]
+      end
+    end
+
+    # Indentation of a member added after the last one
+    def member_indent(container, members)
+      last = members.last
+      if last.nil?
+        indentation_at(container.start_byte) + indent_unit
+      elsif last.start_point.row == container.start_point.row
+        indentation_at(container.start_byte)
+      elsif first_on_line?(last)
+        indentation_at(last.start_byte)
+      else
+        indentation_at(last.start_byte) + indent_unit
+      end
+    end
+
+    # The "," right after a member, if there is one
+    def trailing_comma(container, member)
+      container.children.find { |child| child.start_byte >= member.end_byte && !child.extra? }.then do |token|
+        token if token && token.kind == ","
+      end
+    end
+
+    # --- Source helpers ---
+
+    def line_start(byte)
+      return 0 if byte.zero?
+
+      (@source.byterindex("\n", byte - 1) || -1) + 1
+    end
+
+    # Offset just past the newline ending the line that contains byte
+    def next_line_start(byte)
+      newline = @source.byteindex("\n", byte)
+      newline ? newline + 1 : @source.bytesize
+    end
+
+    def first_on_line?(node)
+      @source.byteslice(line_start(node.start_byte)...node.start_byte).strip.empty?
+    end
+
+    def indentation_at(byte)
+      @indentation.indentation_at_byte(byte)
+    end
+
+    def indent_unit
+      @indentation.indent_string
+    end
+
+    # Indent every line but the first
+    def indent_continuation(text, indent)
+      text.gsub("\n", "\n#{indent}")
+    end
+  end
+end
@@ -0,0 +1,359 @@
+# frozen_string_literal: true
+
+require_relative "text_diff"
+
+module TreeSitter
+  # Compares two versions of a file token by token instead of line by line.
+  #
+  # Both versions are parsed and their leaf nodes are aligned with a Myers
+  # diff on token text, so whitespace and line breaks never count as changes:
+  # code that was only reformatted is reported as equal, and only the tokens
+  # that were really added or removed are highlighted. Without a language the
+  # comparison falls back to whole lines.
+  #
+  # @example
+  #   diff = TreeSitter::StructuralDiff.new(old_source, new_source, language: "rust")
+  #   diff.changed?                        # => true
+  #   puts diff.render_side_by_side(width: 160, color: true)
+  #
+  class StructuralDiff
+    # A leaf node (or, in line mode, a whole line)
+    Token = Struct.new(:text, :start_byte, :end_byte, :row, keyword_init: true)
+
+    # One displayed row: a line of the old file, the new file, or both.
+    # Status is :equal, :changed, :deleted or :inserted.
+    Row = Struct.new(:old_line, :new_line, :status, keyword_init: true)
+
+    DELETED_COLOR = "\e[1;31m"
+    INSERTED_COLOR = "\e[1;32m"
+    HEADER_COLOR = "\e[1m"
+    RESET = "\e[0m"
+    TAB_WIDTH = 4
+
+    attr_reader :old_source, :new_source, :language
+
+    # Initialize a new StructuralDiff
+    #
+    # @param old_source [String] The old version of the file
+    # @param new_source [String] The new version of the file
+    # @param language [String, TreeSitter::Language, nil] Language to parse with (nil for a line diff)
+    # @param max_cost [Integer] Above this many added and removed tokens, compare lines instead
+    def initialize(old_source, new_source, language: nil, max_cost: TextDiff::MAX_COST)
+      @old_source = old_source
+      @new_source = new_source
+      @language = language.is_a?(TreeSitter::Language) ? language.name : language
+      @max_cost = max_cost
+      @old_lines = old_source.lines
+      @new_lines = new_source.lines
+      compute
+    end
+
+    # @return [Boolean] True when the files were compared by syntax tree tokens;
+    #   false without a language, or when the token diff exceeded max_cost
+    def structural?
+      @structural
+    end
+
+    # @return [Boolean] True if any token was added or removed
+    def changed?
+      !@old_changed.empty? || !@new_changed.empty?
+    end
+
+    # @return [Array<Token>] Tokens of the old file that were removed
+    def deleted_tokens
+      @old_changed.sort.map { |index| @old_tokens[index] }
+    end
+
+    # @return [Array<Token>] Tokens of the new file that were added
+    def inserted_tokens
+      @new_changed.sort.map { |index| @new_tokens[index] }
+    end
+
+    # @return [Array<Row>] Old and new lines paired up for display
+    def rows
+      @rows ||= build_rows
+    end
+
+    # Render as a unified diff, with changed tokens highlighted
+    #
+    # @param color [Boolean] Use ANSI colors
+    # @param context [Integer] Unchanged lines to show around each change
+    # @return [String]
+    def render_inline(color: false, context: 3)
+      output = []
+
+      each_hunk(context) do |hunk_rows|
+        output << header(hunk_rows, color)
+        hunk_rows.each do |row|
+          case row.status
+          when :equal
+            lines, number = row.new_line ? [@new_lines, row.new_line] : [@old_lines, row.old_line]
+            output << " #{display_line(lines, number, [], nil, nil)}"
+          else
+            if row.old_line
+              ranges = @old_highlights.fetch(row.old_line, [])
+              output << "-#{display_line(@old_lines, row.old_line, ranges, color && DELETED_COLOR, nil)}"
+            end
+            if row.new_line
+              ranges = @new_highlights.fetch(row.new_line, [])
+              output << "+#{display_line(@new_lines, row.new_line, ranges, color && INSERTED_COLOR, nil)}"
+            end
+          end
+        end
+      end
+
+      output.join("\n") + (output.empty? ? "" : "\n")
+    end
+
+    # Render the two versions next to each other, with changed tokens highlighted
+    #
+    # @param width [Integer] Total width of the output in columns
+    # @param color [Boolean] Use ANSI colors
+    # @param context [Integer] Unchanged lines to show around each change
+    # @return [String]
+    def render_side_by_side(width: 120, color: false, context: 3)
+      number_width = [@old_lines.length, @new_lines.length].max.to_s.length
+      column_width = [((width - 3) / 2) - number_width - 1, 10].max
+      output = []
+
+      each_hunk(context) do |hunk_rows|
+        output << header(hunk_rows, color)
+        hunk_rows.each do |row|
+          left = side(@old_lines, row.old_line, @old_highlights, color && DELETED_COLOR, column_width, number_width)
+          right = side(@new_lines, row.new_line, @new_highlights, color && INSERTED_COLOR, column_width, number_width)
+          output << "#{left} #{marker(row.status)} #{right}".rstrip
+        end
+      end
+
+      output.join("\n") + (output.empty? ? "" : "\n")
+    end
+
+    private
+
+    def compute
+      hunks = nil
+      if @language
+        @old_tokens = syntax_tokens(@old_source)
+        @new_tokens = syntax_tokens(@new_source)
+        if @old_tokens && @new_tokens
+          hunks = TextDiff.diff(@old_tokens.map(&:text), @new_tokens.map(&:text), max_cost: @max_cost)
+        end
+      end
+
+      # The Myers trace grows with the square of the changes, so large
+      # rewrites are compared line by line
+      @structural = !hunks.nil?
+      unless @structural
+        @old_tokens = line_tokens(@old_lines)
+        @new_tokens = line_tokens(@new_lines)
+        hunks = TextDiff.diff(@old_tokens.map(&:text), @new_tokens.map(&:text), max_cost: @max_cost) ||
+          [TextDiff::Hunk.new(old_start: 0, old_length: @old_tokens.length, new_start: 0, new_length: @new_tokens.length)]
+      end
+
+      @old_changed = Set.new
+      @new_changed = Set.new
+      hunks.each do |hunk|
+        hunk.old_length.times { |i| @old_changed << (hunk.old_start + i) }
+        hunk.new_length.times { |i| @new_changed << (hunk.new_start + i) }
+      end
+
+      @anchors = equal_pairs(hunks)
+      @old_highlights = highlights(@old_tokens, @old_changed, @old_lines)
+      @new_highlights = highlights(@new_tokens, @new_changed, @new_lines)
+    end
+
+    # Leaf tokens, or nil if the source cannot be parsed
+    def syntax_tokens(source)
+      parser = TreeSitter::Parser.new
+      parser.language = @language
+      tree = parser.parse(source)
+      leaf_tokens(tree.root_node) if tree
+    end
+
+    def line_tokens(lines)
+      offset = 0
+      lines.each_with_index.map do |line, row|
+        token = Token.new(text: line.chomp, start_byte: offset, end_byte: offset + line.chomp.bytesize, row: row)
+        offset += line.bytesize
+        token
+      end
+    end
+
+    def leaf_tokens(node, tokens = [])
+      if node.child_count.zero?
+        unless node.start_byte == node.end_byte
+          tokens << Token.new(text: node.text, start_byte: node.start_byte, end_byte: node.end_byte,
+            row: node.start_point.row)
+        end
+      else
+        node.children.each { |child| leaf_tokens(child, tokens) }
+      end
+      tokens
+    end
+
+    # Token index pairs that the diff left unchanged
+    def equal_pairs(hunks)
+      pairs = []
+      old_index = 0
+      new_index = 0
+
+      (hunks + [nil]).each do |hunk|
+        old_stop = hunk ? hunk.old_start : @old_tokens.length
+        while old_index < old_stop
+          pairs << [old_index, new_index]
+          old_index += 1
+          new_index += 1
+        end
+        next unless hunk
+
+        old_index += hunk.old_length
+        new_index += hunk.new_length
+      end
+
+      pairs
+    end
+
+    # Byte ranges (relative to the line start) to highlight on each line
+    def highlights(tokens, changed, lines)
+      line_starts = lines.each_with_object([0]) { |line, starts| starts << (starts.last + line.bytesize) }
+      result = {}
+
+      changed.each do |index|
+        token = tokens[index]
+        row = token.row
+        loop do
+          line_start = line_starts[row]
+          line_end = line_start + lines[row].to_s.chomp.bytesize
+          from = [token.start_byte, line_start].max - line_start
+          to = [token.end_byte, line_end].min - line_start
+          # An entry marks the line as changed even when there is nothing to
+          # color, e.g. an added blank line in a line diff
+          ranges = (result[row] ||= [])
+          ranges << (from...to) if to > from
+
+          row += 1
+          break if row >= lines.length || line_starts[row] >= token.end_byte
+        end
+      end
+
+      result
+    end
+
+    # Pair old and new lines using the rows of unchanged tokens as anchors
+    def build_rows
+      rows = []
+      last_old = -1
+      last_new = -1
+
+      line_anchors = @anchors.map do |old_index, new_index|
+        [@old_tokens[old_index].row, @new_tokens[new_index].row]
+      end
+
+      line_anchors.each do |old_row, new_row|
+        next if old_row <= last_old || new_row <= last_new
+
+        fill_rows(rows, (last_old + 1)...old_row, (last_new + 1)...new_row)
+        rows << Row.new(old_line: old_row, new_line: new_row, status: row_status(old_row, new_row))
+        last_old = old_row
+        last_new = new_row
+      end
+
+      fill_rows(rows, (last_old + 1)...@old_lines.length, (last_new + 1)...@new_lines.length)
+      rows
+    end
+
+    def fill_rows(rows, old_range, new_range)
+      old_rows = old_range.to_a
+      new_rows = new_range.to_a
+
+      [old_rows.length, new_rows.length].max.times do |i|
+        old_row = old_rows[i]
+        new_row = new_rows[i]
+        rows << Row.new(old_line: old_row, new_line: new_row, status: row_status(old_row, new_row))
+      end
+    end
+
+    def row_status(old_row, new_row)
+      old_changed = old_row && @old_highlights.key?(old_row)
+      new_changed = new_row && @new_highlights.key?(new_row)
+
+      if old_changed && new_changed
+        :changed
+      elsif old_changed
+        new_row ? :changed : :deleted
+      elsif new_changed
+        old_row ? :changed : :inserted
+      else
+        :equal
+      end
+    end
+
+    # Yield runs of rows around changes, separated like diff hunks
+    def each_hunk(context)
+      changed = rows.each_index.reject { |i| rows[i].status == :equal }
+      return if changed.empty?
+
+      ranges = changed.map { |i| [[i - context, 0].max, [i + context, rows.length - 1].min] }
+      merged = ranges.each_with_object([]) do |(first, last), acc|
+        if acc.any? && first <= acc.last[1] + 1
+          acc.last[1] = [acc.last[1], last].max
+        else
+          acc << [first, last]
+        end
+      end
+
+      merged.each { |first, last| yield rows[first..last] }
+    end
+
+    def header(hunk_rows, color)
+      old_first = hunk_rows.filter_map(&:old_line).first
+      new_first = hunk_rows.filter_map(&:new_line).first
+      text = "@@ -#{(old_first || 0) + 1} +#{(new_first || 0) + 1} @@"
+      color ? "#{HEADER_COLOR}#{text}#{RESET}" : text
+    end
+
+    def marker(status)
+      case status
+      when :changed then "|"
+      when :deleted then "<"
+      when :inserted then ">"
+      else " "
+      end
+    end
+
+    def side(lines, line_number, highlights, color, width, number_width)
+      return " " * (number_width + 1 + width) if line_number.nil?
+
+      text = display_line(lines, line_number, highlights.fetch(line_number, []), color, width)
+      "#{(line_number + 1).to_s.rjust(number_width)} #{text}"
+    end
+
+    # A source line with tabs expanded, optionally cut and padded to width,
+    # and with the highlighted byte ranges colored
+    def display_line(lines, line_number, ranges, color, width)
+      line = lines[line_number].to_s.chomp
+      cells = []
+      byte = 0
+
+      line.each_char do |char|
+        marked = ranges.any? { |range| range.cover?(byte) }
+        if char == "\t"
+          (TAB_WIDTH - (cells.length % TAB_WIDTH)).times { cells << [" ", marked] }
+        else
+          cells << [char, marked]
+        end
+        byte += char.bytesize
+      end
+
+      cells = cells.first(width) if width
+      text = cells.chunk { |_char, marked| marked }.map do |marked, chunk|
+        segment = chunk.map(&:first).join
+        next segment unless marked
+
+        color ? "#{color}#{segment}#{RESET}" : segment
+      end.join
+
+      width ? text + (" " * (width - cells.length)) : text
+    end
+  end
+end
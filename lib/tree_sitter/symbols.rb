@@ -0,0 +1,281 @@
+# frozen_string_literal: true
+
+require_relative "text_diff"
+
+module TreeSitter
+  # Finds the named definitions in a tree (functions, methods, classes,
+  # structs, modules, ...) and gives each a qualified name such as
+  # `Point::distance` (Rust) or `Point#distance` (Ruby).
+  #
+  # Bodies of functions are not searched, so local helpers nested inside a
+  # function are not reported as separate symbols.
+  #
+  # @example
+  #   TreeSitter::Symbols.extract(tree).map(&:qualified_name)
+  #   # => ["Point", "Point::new", "Point::distance", "main"]
+  #
+  module Symbols
+    # A named definition
+    #
+    # `signature` is the definition up to its body with whitespace
+    # normalized; `tokens` and `body_tokens` are the texts of its leaf nodes.
+    Definition = Struct.new(
+      :kind,
+      :name,
+      :qualified_name,
+      :node,
+      :signature,
+      :tokens,
+      :body_tokens,
+      keyword_init: true,
+    ) do
+      # @return [Boolean] True for definitions that hold other definitions (classes, impls, ...)
+      def container?
+        Symbols::CONTAINER_KINDS.include?(kind)
+      end
+
+      # @return [String] The qualified name of the enclosing definition ("" at the top level)
+      def scope
+        qualified_name.delete_suffix(name).sub(/(::|[.#])\z/, "")
+      end
+    end
+
+    # Kinds whose members get qualified with their name
+    CONTAINER_KINDS = [:class, :module, :namespace, :impl, :trait, :interface].freeze
+
+    # Containers whose functions are methods
+    TYPE_KINDS = [:class, :impl, :trait, :interface, :struct].freeze
+
+    # Node types that define symbols, per language
+    #
+    # `definitions` maps node types to kinds, `name_fields` overrides the
+    # field holding the name, `separators` overrides the separator between a
+    # container name and a member name, and `scope_separators` overrides it
+    # for the members of other node types (Ruby's `class << self`).
+    LANGUAGES = {
+      "rust" => {
+        definitions: {
+          "function_item" => :function,
+          "function_signature_item" => :function,
+          "struct_item" => :struct,
+          "enum_item" => :enum,
+          "union_item" => :struct,
+          "trait_item" => :trait,
+          "impl_item" => :impl,
+          "mod_item" => :module,
+          "type_item" => :type,
+          "macro_definition" => :macro,
+        },
+        name_fields: { "impl_item" => "type" },
+        separator: "::",
+      },
+      "ruby" => {
+        definitions: {
+          "method" => :function,
+          "singleton_method" => :function,
+          "class" => :class,
+          "module" => :module,
+        },
+        separator: "::",
+        separators: { "method" => "#", "singleton_method" => "." },
+        scope_separators: { "singleton_class" => { "method" => "." } },
+      },
+      "python" => {
+        definitions: {
+          "function_definition" => :function,
+          "class_definition" => :class,
+        },
+        separator: ".",
+      },
+      "javascript" => {
+        definitions: {
+          "function_declaration" => :function,
+          "generator_function_declaration" => :function,
+          "class_declaration" => :class,
+          "method_definition" => :function,
+        },
+        separator: ".",
+      },
+      "go" => {
+        definitions: {
+          "function_declaration" => :function,
+          "method_declaration" => :method,
+          "type_spec" => :type,
+        },
+        separator: ".",
+      },
+      "java" => {
+        definitions: {
+          "class_declaration" => :class,
+          "interface_declaration" => :interface,
+          "enum_declaration" => :class,
+          "record_declaration" => :class,
+          "method_declaration" => :function,
+          "constructor_declaration" => :function,
+        },
+        separator: ".",
+      },
+      "c_sharp" => {
+        definitions: {
+          "namespace_declaration" => :namespace,
+          "class_declaration" => :class,
+          "interface_declaration" => :interface,
+          "struct_declaration" => :class,
+          "record_declaration" => :class,
+          "enum_declaration" => :enum,
+          "method_declaration" => :function,
+          "constructor_declaration" => :function,
+        },
+        separator: ".",
+      },
+      "php" => {
+        definitions: {
+          "function_definition" => :function,
+          "class_declaration" => :class,
+          "interface_declaration" => :interface,
+          "trait_declaration" => :trait,
+          "method_declaration" => :function,
+        },
+        separator: "::",
+      },
+    }.freeze
+
+    # Fields that end a definition's signature when it has no body field
+    SIGNATURE_FIELDS = ["parameters", "return_type", "result", "superclass", "name"].freeze
+
+    class << self
+      # @param language [String, TreeSitter::Language] A language
+      # @return [Boolean] True if symbols can be extracted for the language
+      def supported?(language)
+        LANGUAGES.key?(language_name(language))
+      end
+
+      # Find every definition in a tree, in source order
+      #
+      # @param tree [TreeSitter::Tree] The parsed source
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [Array<Definition>]
+      # @raise [ArgumentError] If the language is not supported
+      def extract(tree, language: nil)
+        name = language_name(language || tree.language)
+        config = LANGUAGES.fetch(name) do
+          raise ArgumentError, "Symbols are not supported for #{name}"
+        end
+
+        collect(tree.root_node, config, [], [], config.fetch(:separators, {}))
+      end
+
+      # Find a definition by qualified name
+      #
+      # @param tree [TreeSitter::Tree] The parsed source
+      # @param qualified_name [String] E.g. "Point::distance"
+      # @param language [String, TreeSitter::Language, nil] Defaults to the tree's language
+      # @return [Definition, nil] The first definition with that name
+      def find(tree, qualified_name, language: nil)
+        extract(tree, language: language).find { |definition| definition.qualified_name == qualified_name }
+      end
+
+      # How alike two definitions are, ignoring their names
+      #
+      # This is the Dice coefficient over the longest common subsequence of
+      # their tokens, so 1.0 means identical apart from the name.
+      #
+      # @param a [Definition] A definition
+      # @param b [Definition] Another definition
+      # @return [Float] Between 0.0 and 1.0
+      def similarity(a, b)
+        a_tokens = a.tokens - [a.name]
+        b_tokens = b.tokens - [b.name]
+        return 1.0 if a_tokens.empty? && b_tokens.empty?
+
+        hunks = TextDiff.diff(a_tokens, b_tokens, max_cost: TextDiff::MAX_COST)
+        return 0.0 unless hunks
+
+        common = a_tokens.length - hunks.sum(&:old_length)
+        2.0 * common / (a_tokens.length + b_tokens.length)
+      end
+
+      # The texts of the leaf nodes under a node, optionally limited to a byte range
+      #
+      # @param node [TreeSitter::Node] The node
+      # @param from [Integer] First byte to include
+      # @param to [Integer] Byte to stop at
+      # @return [Array<String>]
+      def tokens(node, from = node.start_byte, to = node.end_byte, result = [])
+        return result if node.end_byte <= from || node.start_byte >= to
+
+        if node.child_count.zero?
+          result << node.text unless node.start_byte == node.end_byte
+        else
+          node.children.each { |child| tokens(child, from, to, result) }
+        end
+        result
+      end
+
+      private
+
+      def language_name(language)
+        language.is_a?(TreeSitter::Language) ? language.name : language.to_s
+      end
+
+      # Walk named nodes, descending into containers but not into other definitions
+      def collect(node, config, scopes, result, separators)
+        node.named_children.each do |child|
+          kind = config[:definitions][child.kind]
+          name = kind && definition_name(child, config)
+
+          unless name
+            scope_separators = config.fetch(:scope_separators, {}).fetch(child.kind, {})
+            collect(child, config, scopes, result, separators.merge(scope_separators))
+            next
+          end
+
+          kind = :method if kind == :function && TYPE_KINDS.include?(scopes.last&.kind)
+          qualifier = receiver_type(child) || scopes.last&.qualified_name
+          separator = separators.fetch(child.kind, config[:separator])
+          definition = build_definition(child, kind, name, qualifier ? "#{qualifier}#{separator}#{name}" : name)
+          result << definition
+
+          collect(child, config, scopes + [definition], result, config.fetch(:separators, {})) if definition.container?
+        end
+        result
+      end
+
+      def definition_name(node, config)
+        field = config.fetch(:name_fields, {}).fetch(node.kind, "name")
+        name = node.child_by_field_name(field)&.text&.gsub(/\s+/, " ")
+        # `impl<T> Display for Point<T>` holds the methods of `Point`
+        node.kind == "impl_item" ? name&.sub(/<.*>\z/m, "") : name
+      end
+
+      # Go methods are declared outside their type: `func (p *Point) Distance()`
+      def receiver_type(node)
+        return unless node.kind == "method_declaration"
+
+        receiver = node.child_by_field_name("receiver")
+        parameter = receiver&.named_children&.find { |child| child.kind == "parameter_declaration" }
+        type = parameter&.child_by_field_name("type")
+        type&.text&.delete("*")&.sub(/\[.*\]\z/m, "")&.strip
+      end
+
+      def build_definition(node, kind, name, qualified_name)
+        body = node.child_by_field_name("body")
+        signature_end = if body
+          body.start_byte
+        else
+          SIGNATURE_FIELDS.filter_map { |field| node.child_by_field_name(field)&.end_byte }.max || node.end_byte
+        end
+
+        Definition.new(
+          kind: kind,
+          name: name,
+          qualified_name: qualified_name,
+          node: node,
+          signature: tokens(node, node.start_byte, signature_end).join(" "),
+          tokens: tokens(node),
+          body_tokens: tokens(node, signature_end, node.end_byte),
+        )
+      end
+    end
+  end
+end
@@ -0,0 +1,272 @@
+# frozen_string_literal: true
+
+module TreeSitter
+  # Computes `InputEdit`s that turn one version of a source text into another,
+  # so an existing tree can be edited and re-parsed incrementally.
+  #
+  # All offsets are in bytes, and point columns are byte columns, matching
+  # what tree-sitter expects.
+  #
+  # @example
+  #   edits = TreeSitter::TextDiff.edits(old_source, new_source)
+  #   edits.each { |edit| tree.edit(edit) }
+  #   new_tree = parser.parse(new_source, tree)
+  #
+  module TextDiff
+    # A run of differing items: `old_length` items of the old sequence starting
+    # at `old_start` were replaced by `new_length` items of the new sequence
+    # starting at `new_start`
+    Hunk = Struct.new(:old_start, :old_length, :new_start, :new_length, keyword_init: true)
+
+    # Above this many differing lines the Myers diff gives up and the whole
+    # changed region becomes a single edit
+    MAX_COST = 2_000
+
+    class << self
+      # Compute the edits between two texts
+      #
+      # The common prefix and suffix are always skipped. With the `:myers`
+      # algorithm the region in between is diffed line by line so that
+      # separate hunks become separate edits; `:prefix_suffix` turns the whole
+      # region into one edit.
+      #
+      # Edits are returned last-to-first, which is the order they must be
+      # passed to `Tree#edit`: each one is expressed against the text with all
+      # of the edits after it already applied.
+      #
+      # @param old_source [String] The text the tree was parsed from
+      # @param new_source [String] The updated text
+      # @param algorithm [Symbol] :myers or :prefix_suffix
+      # @return [Array<TreeSitter::InputEdit>] Edits to apply (empty if the texts are equal)
+      def edits(old_source, new_source, algorithm: :myers)
+        return [] if old_source == new_source
+
+        old_bytes = old_source.b
+        new_bytes = new_source.b
+        prefix = common_prefix_length(old_bytes, new_bytes)
+        suffix = common_suffix_length(old_bytes, new_bytes, prefix)
+        old_end = old_bytes.bytesize - suffix
+        new_end = new_bytes.bytesize - suffix
+
+        spans = if algorithm == :myers
+          hunk_spans(old_bytes, new_bytes, prefix, old_end, new_end)
+        end
+        spans ||= [[prefix, old_end, prefix, new_end]]
+
+        spans.reverse.map do |old_start, old_stop, new_start, new_stop|
+          build_edit(old_bytes, old_start, old_stop, new_bytes.byteslice(new_start...new_stop))
+        end
+      end
+
+      # Myers' O(ND) difference algorithm over two sequences
+      #
+      # @param old_items [Array] The old sequence
+      # @param new_items [Array] The new sequence (items are compared with ==)
+      # @param max_cost [Integer, nil] Give up after this many insertions and deletions
+      # @return [Array<Hunk>, nil] The differing runs, or nil if max_cost was exceeded
+      def diff(old_items, new_items, max_cost: nil)
+        trace = shortest_edit_trace(old_items, new_items, max_cost)
+        return if trace.nil?
+
+        hunks_from_operations(backtrack(trace, old_items.length, new_items.length))
+      end
+
+      # Convert a byte offset into a row/column point
+      #
+      # @param source [String] The text
+      # @param byte [Integer] Byte offset into the text
+      # @return [TreeSitter::Point] Zero-based row and byte column
+      def point_at(source, byte)
+        head = source.byteslice(0, byte).b
+        line_start = head.byterindex("\n")
+        column = line_start ? byte - line_start - 1 : byte
+
+        TreeSitter::Point.new(head.count("\n"), column)
+      end
+
+      private
+
+      # Diff the changed middle region line by line and turn each hunk into a
+      # byte span in both texts. Returns nil when a single edit is just as good.
+      def hunk_spans(old_bytes, new_bytes, prefix, old_end, new_end)
+        old_lines = old_bytes.byteslice(prefix...old_end).lines
+        new_lines = new_bytes.byteslice(prefix...new_end).lines
+        return if old_lines.length <= 1 && new_lines.length <= 1
+
+        hunks = diff(old_lines, new_lines, max_cost: MAX_COST)
+        return if hunks.nil? || hunks.length <= 1
+
+        old_offsets = line_offsets(old_lines, prefix)
+        new_offsets = line_offsets(new_lines, prefix)
+
+        hunks.map do |hunk|
+          old_start = old_offsets[hunk.old_start]
+          old_stop = old_offsets[hunk.old_start + hunk.old_length]
+          new_start = new_offsets[hunk.new_start]
+          new_stop = new_offsets[hunk.new_start + hunk.new_length]
+
+          # Tighten each hunk to the bytes that actually differ
+          old_text = old_bytes.byteslice(old_start...old_stop)
+          new_text = new_bytes.byteslice(new_start...new_stop)
+          head = common_prefix_length(old_text, new_text)
+          tail = common_suffix_length(old_text, new_text, head)
+
+          [old_start + head, old_stop - tail, new_start + head, new_stop - tail]
+        end
+      end
+
+      def line_offsets(lines, base)
+        lines.each_with_object([base]) { |line, offsets| offsets << (offsets.last + line.bytesize) }
+      end
+
+      # The edit replacing old_source[start_byte...old_end_byte] with inserted.
+      # The new end point is derived from the inserted text, since the text
+      # before the edit is still the old text when it is applied.
+      def build_edit(old_source, start_byte, old_end_byte, inserted)
+        start_point = point_at(old_source, start_byte)
+        last_newline = inserted.byterindex("\n")
+        new_end_point = if last_newline
+          TreeSitter::Point.new(start_point.row + inserted.count("\n"), inserted.bytesize - last_newline - 1)
+        else
+          TreeSitter::Point.new(start_point.row, start_point.column + inserted.bytesize)
+        end
+
+        TreeSitter::InputEdit.new(
+          start_byte,
+          old_end_byte,
+          start_byte + inserted.bytesize,
+          start_point,
+          point_at(old_source, old_end_byte),
+          new_end_point,
+        )
+      end
+
+      # Forward pass of Myers' algorithm. trace[d] holds the furthest-reaching
+      # x for diagonals -(d + 1)..(d + 1) before step d.
+      def shortest_edit_trace(old_items, new_items, max_cost)
+        n = old_items.length
+        m = new_items.length
+        max = n + m
+        max = [max, max_cost].min if max_cost
+        offset = max + 1
+        v = Array.new((2 * max) + 3, 0)
+        trace = []
+
+        (0..max).each do |d|
+          trace << v[(offset - d - 1)..(offset + d + 1)]
+
+          (-d..d).step(2) do |k|
+            x = if k == -d || (k != d && v[offset + k - 1] < v[offset + k + 1])
+              v[offset + k + 1]
+            else
+              v[offset + k - 1] + 1
+            end
+            y = x - k
+
+            while x < n && y < m && old_items[x] == new_items[y]
+              x += 1
+              y += 1
+            end
+
+            v[offset + k] = x
+            return trace if x >= n && y >= m
+          end
+        end
+
+        nil
+      end
+
+      # Walk the trace backwards, producing :equal, :delete and :insert operations
+      def backtrack(trace, x, y)
+        operations = []
+
+        (trace.length - 1).downto(0) do |d|
+          v = trace[d]
+          k = x - y
+          previous_k = if k == -d || (k != d && v[k - 1 + d + 1] < v[k + 1 + d + 1])
+            k + 1
+          else
+            k - 1
+          end
+          previous_x = v[previous_k + d + 1]
+          previous_y = previous_x - previous_k
+
+          while x > previous_x && y > previous_y
+            operations << :equal
+            x -= 1
+            y -= 1
+          end
+
+          if d.positive?
+            operations << (x == previous_x ? :insert : :delete)
+            x = previous_x
+            y = previous_y
+          end
+        end
+
+        operations.reverse
+      end
+
+      def hunks_from_operations(operations)
+        hunks = []
+        old_index = 0
+        new_index = 0
+        current = nil
+
+        operations.each do |operation|
+          if operation == :equal
+            current = nil
+            old_index += 1
+            new_index += 1
+            next
+          end
+
+          unless current
+            current = Hunk.new(old_start: old_index, old_length: 0, new_start: new_index, new_length: 0)
+            hunks << current
+          end
+
+          if operation == :delete
+            current.old_length += 1
+            old_index += 1
+          else
+            current.new_length += 1
+            new_index += 1
+          end
+        end
+
+        hunks
+      end
+
+      # Binary search over byte slices so the comparisons happen in C rather
+      # than one byte at a time in Ruby
+      def common_prefix_length(a, b)
+        low = 0
+        high = [a.bytesize, b.bytesize].min
+        while low < high
+          mid = (low + high + 1) / 2
+          if a.byteslice(0, mid) == b.byteslice(0, mid)
+            low = mid
+          else
+            high = mid - 1
+          end
+        end
+        low
+      end
+
+      def common_suffix_length(a, b, prefix)
+        low = 0
+        high = [a.bytesize, b.bytesize].min - prefix
+        while low < high
+          mid = (low + high + 1) / 2
+          if a.byteslice(a.bytesize - mid, mid) == b.byteslice(b.bytesize - mid, mid)
+            low = mid
+          else
+            high = mid - 1
+          end
+        end
+        low
+      end
+    end
+  end
+end
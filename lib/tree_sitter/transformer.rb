@@ -14,6 +14,15 @@ module TreeSitter
   #     .move(fn_node, after: other_fn_node)
   #     .rewrite
   #
+  # Except for #reorder_children, the nodes can also be given as anything
+  # PositionIndex#resolve accepts: points, `[row, column]` pairs, pairs of
+  # them, Ruby ranges of byte offsets and Lines.
+  #
+  # @example Move lines 3 to 5 before line 1 (zero-based)
+  #   Transformer.new(source, tree)
+  #     .move(TreeSitter::Lines.new(3..5), before: TreeSitter::Lines.new(1..1), separator: "")
+  #     .rewrite
+  #
   class Transformer
     # Represents a pending structural operation
     Operation = Struct.new(:type, :params, keyword_init: true)
@@ -25,60 +34,92 @@ module TreeSitter
     # @param source [String] The source code to transform
     # @param tree [TreeSitter::Tree] The parsed syntax tree
     # @param parser [TreeSitter::Parser, nil] Optional parser for re-parsing
-    def initialize(source, tree, parser: nil)
+    # @param column_unit [Symbol] Unit of the columns of `[row, column]` targets
+    #   (:byte, :char or :utf16, see PositionIndex)
+    # @param one_based [Boolean] True if rows and columns of `[row, column]` and Lines targets count from 1
+    # @param only_within [Array<TreeSitter::Range>, nil] Ignore operations that edit nodes outside these ranges
+    def initialize(source, tree, parser: nil, column_unit: :byte, one_based: false, only_within: nil)
       @source = source.dup.freeze
       @tree = tree
       @parser = parser
+      @only_within = only_within
+      @column_unit = PositionIndex.validate_unit(column_unit)
+      @one_based = one_based
       @operations = []
     end
 
     # Move a node to a new location (removes from original, inserts at target)
     #
-    # @param node [TreeSitter::Node] The node to move
-    # @param before [TreeSitter::Node, nil] Insert before this node
-    # @param after [TreeSitter::Node, nil] Insert after this node
+    # @param node [TreeSitter::Node, Object] The node to move
+    # @param before [TreeSitter::Node, Object, nil] Insert before this node
+    # @param after [TreeSitter::Node, Object, nil] Insert after this node
     # @param separator [String] Separator to use (default: newline)
+    # @param reindent [Boolean] Shift the node's lines to the indentation of the target
     # @return [self] For method chaining
-    def move(node, before: nil, after: nil, separator: "\n")
+    def move(node, before: nil, after: nil, separator: "\n", reindent: false)
       raise ArgumentError, "Must specify either before: or after:" if before.nil? && after.nil?
       raise ArgumentError, "Cannot specify both before: and after:" if before && after
 
+      node = target(node)
+      destination = target(before || after)
+      return self unless within_scope?(node, destination)
+
       @operations << Operation.new(
         type: :move,
-        params: { node: node, before: before, after: after, separator: separator },
+        params: {
+          node: node,
+          before: before && destination,
+          after: after && destination,
+          separator: separator,
+          reindent: reindent,
+        },
       )
       self
     end
 
     # Copy a node to a new location (original remains)
     #
-    # @param node [TreeSitter::Node] The node to copy
-    # @param before [TreeSitter::Node, nil] Insert before this node
-    # @param after [TreeSitter::Node, nil] Insert after this node
+    # @param node [TreeSitter::Node, Object] The node to copy
+    # @param before [TreeSitter::Node, Object, nil] Insert before this node
+    # @param after [TreeSitter::Node, Object, nil] Insert after this node
     # @param separator [String] Separator to use (default: newline)
+    # @param reindent [Boolean] Shift the node's lines to the indentation of the target
     # @return [self] For method chaining
-    def copy(node, before: nil, after: nil, separator: "\n")
+    def copy(node, before: nil, after: nil, separator: "\n", reindent: false)
       raise ArgumentError, "Must specify either before: or after:" if before.nil? && after.nil?
       raise ArgumentError, "Cannot specify both before: and after:" if before && after
 
+      destination = target(before || after)
+      return self unless within_scope?(destination)
+
       @operations << Operation.new(
         type: :copy,
-        params: { node: node, before: before, after: after, separator: separator },
+        params: {
+          node: target(node),
+          before: before && destination,
+          after: after && destination,
+          separator: separator,
+          reindent: reindent,
+        },
       )
       self
     end
 
     # Swap two nodes
     #
-    # @param node_a [TreeSitter::Node] First node
-    # @param node_b [TreeSitter::Node] Second node
+    # @param node_a [TreeSitter::Node, Object] First node
+    # @param node_b [TreeSitter::Node, Object] Second node
+    # @param reindent [Boolean] Shift each node's lines to the indentation of the other's position
     # @return [self] For method chaining
-    def swap(node_a, node_b)
+    def swap(node_a, node_b, reindent: false)
+      node_a = target(node_a)
+      node_b = target(node_b)
       validate_non_overlapping(node_a, node_b)
+      return self unless within_scope?(node_a, node_b)
 
       @operations << Operation.new(
         type: :swap,
-        params: { node_a: node_a, node_b: node_b },
+        params: { node_a: node_a, node_b: node_b, reindent: reindent },
       )
       self
     end
@@ -90,6 +131,8 @@ module TreeSitter
     # @return [self] For method chaining
     # @example Reverse first three children: reorder_children(parent, [2, 1, 0, 3, 4])
     def reorder_children(parent, order)
+      return self unless within_scope?(parent)
+
       @operations << Operation.new(
         type: :reorder,
         params: { parent: parent, order: order },
@@ -99,12 +142,16 @@ module TreeSitter
 
     # Extract node content to a new location with a reference
     #
-    # @param node [TreeSitter::Node] Node to extract
-    # @param to [TreeSitter::Node] Where to place extracted content (inserted after)
+    # @param node [TreeSitter::Node, Object] Node to extract
+    # @param to [TreeSitter::Node, Object] Where to place extracted content (inserted after)
     # @param reference [String] Reference to leave in place of original
     # @yield [String] Optional block to transform extracted content
     # @return [self] For method chaining
     def extract(node, to:, reference:, &wrapper)
+      node = target(node)
+      to = target(to)
+      return self unless within_scope?(node, to)
+
       @operations << Operation.new(
         type: :extract,
         params: { node: node, to: to, reference: reference, wrapper: wrapper },
@@ -114,11 +161,14 @@ module TreeSitter
 
     # Duplicate a node immediately after itself
     #
-    # @param node [TreeSitter::Node] Node to duplicate
+    # @param node [TreeSitter::Node, Object] Node to duplicate
     # @param separator [String] Separator between original and copy
     # @yield [String] Optional block to transform the copy
     # @return [self] For method chaining
     def duplicate(node, separator: "\n", &transformer)
+      node = target(node)
+      return self unless within_scope?(node)
+
       @operations << Operation.new(
         type: :duplicate,
         params: { node: node, separator: separator, transformer: transformer },
@@ -147,8 +197,36 @@ module TreeSitter
       [new_source, new_tree]
     end
 
+    # Export the operations as edits to a ChangeSet, to combine them with the edits of
+    # other tools or save them for later
+    #
+    # @param path [String, nil] The file the source was read from
+    # @return [TreeSitter::ChangeSet]
+    # @raise [TreeSitter::ConflictError] If the edits overlap each other
+    def to_change_set(path: nil)
+      ChangeSet.new.add(path, @source, build_edits, origin: "transformer")
+    end
+
     private
 
+    # A node, or the TreeSitter::Range of any other target
+    def target(value)
+      return value if value.is_a?(TreeSitter::Node)
+
+      positions.resolve(value, unit: @column_unit, one_based: @one_based)
+    end
+
+    # Operations are only kept if every node they edit intersects one of the
+    # only_within ranges
+    def within_scope?(*nodes)
+      @only_within.nil? || nodes.all? { |node| @only_within.any? { |scope| scope.intersects?(node) } }
+    end
+
+    # The index of the source's line positions, shared by all targets
+    def positions
+      @positions ||= PositionIndex.new(@source)
+    end
+
     def build_edits
       edits = []
 
@@ -176,34 +254,25 @@ module TreeSitter
       node_a = params[:node_a]
       node_b = params[:node_b]
 
-      text_a = node_text(node_a)
-      text_b = node_text(node_b)
+      reindent = params[:reindent]
+
+      text_a = placed_text(node_text(node_b), node_b, node_a.start_byte, node_a.end_byte, reindent)
+      text_b = placed_text(node_text(node_a), node_a, node_b.start_byte, node_b.end_byte, reindent)
 
       [
-        { start_byte: node_a.start_byte, end_byte: node_a.end_byte, replacement: text_b },
-        { start_byte: node_b.start_byte, end_byte: node_b.end_byte, replacement: text_a },
+        { start_byte: node_a.start_byte, end_byte: node_a.end_byte, replacement: text_a },
+        { start_byte: node_b.start_byte, end_byte: node_b.end_byte, replacement: text_b },
       ]
     end
 
     def build_move_edits(params)
       node = params[:node]
-      before = params[:before]
-      after = params[:after]
-      separator = params[:separator]
-
-      text = node_text(node)
-      edits = []
 
       # Remove from original location
-      edits << { start_byte: node.start_byte, end_byte: node.end_byte, replacement: "" }
+      edits = [{ start_byte: node.start_byte, end_byte: node.end_byte, replacement: "" }]
 
       # Insert at new location
-      if before
-        edits << { start_byte: before.start_byte, end_byte: before.start_byte, replacement: text + separator }
-      elsif after
-        edits << { start_byte: after.end_byte, end_byte: after.end_byte, replacement: separator + text }
-      end
-
+      edits.concat(build_copy_edits(params))
       edits
     end
 
@@ -213,12 +282,23 @@ module TreeSitter
       after = params[:after]
       separator = params[:separator]
 
+      reindent = params[:reindent]
+
       text = node_text(node)
 
       if before
-        [{ start_byte: before.start_byte, end_byte: before.start_byte, replacement: text + separator }]
+        replacement = placed_text(text + separator, node, before.start_byte, before.start_byte, reindent)
+        [{ start_byte: before.start_byte, end_byte: before.start_byte, replacement: replacement }]
       elsif after
-        [{ start_byte: after.end_byte, end_byte: after.end_byte, replacement: separator + text }]
+        replacement = placed_text(
+          separator + text,
+          node,
+          after.end_byte,
+          after.end_byte,
+          reindent,
+          offset: separator.bytesize,
+        )
+        [{ start_byte: after.end_byte, end_byte: after.end_byte, replacement: replacement }]
       else
         []
       end
@@ -286,13 +366,26 @@ module TreeSitter
 
       result = @source.dup
       sorted.each do |edit|
-        result[edit[:start_byte]...edit[:end_byte]] = edit[:replacement]
+        result.bytesplice(edit[:start_byte]...edit[:end_byte], edit[:replacement])
       end
       result
     end
 
     def node_text(node)
-      @source[node.start_byte...node.end_byte]
+      @source.byteslice(node.start_byte...node.end_byte)
+    end
+
+    # Text from a node, re-indented for where it is placed if requested;
+    # the node's text starts `offset` bytes into the text
+    def placed_text(text, from_node, start_byte, end_byte, reindent, offset: 0)
+      return text unless reindent
+
+      @indentation ||= Formatting::IndentationDetector.new(@source, tree: @tree)
+      from = @indentation.indentation_at_byte(from_node.start_byte)
+      # A range is not a node; take the strings it overlaps from the whole tree
+      scope = from_node.is_a?(TreeSitter::Node) ? from_node : @tree.root_node
+      verbatim = Formatting.verbatim_ranges(scope, base: from_node.start_byte - offset, language: @tree.language)
+      @indentation.reindent(text, start_byte, end_byte: end_byte, from: from, verbatim: verbatim)
     end
 
     def validate_non_overlapping(*nodes)
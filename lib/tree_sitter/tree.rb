@@ -0,0 +1,335 @@
+# frozen_string_literal: true
+
+require "cgi/escape"
+require "json"
+require "rexml/document"
+
+module TreeSitter
+  # Ruby-side additions to the native Tree class: graph, visual and XML
+  # exports for design docs, onboarding material, grammar bug reports and
+  # external analysis tools.
+  #
+  # @example
+  #   File.write("tree.dot", tree.to_dot)           # dot -Tsvg tree.dot > tree.svg
+  #   File.write("tree.html", tree.to_html(title: "main.rs"))
+  #   File.write("tree.xml", tree.to_xml)
+  #
+  class Tree
+    # Replacements of the characters #to_xml escapes as entities
+    XML_ESCAPES = { "&" => "&amp;", "<" => "&lt;", ">" => "&gt;", '"' => "&quot;", "\r" => "&#13;" }.freeze
+
+    # Page used by #to_html
+    HTML_TEMPLATE = <<~HTML
+      <!DOCTYPE html>
+      <html>
+      <head>
+      <meta charset="utf-8">
+      <title>%<title>s</title>
+      <style>
+        body { margin: 0; display: flex; height: 100vh; font: 13px/1.5 ui-monospace, Menlo, Consolas, monospace; }
+        #source, #tree { flex: 1; overflow: auto; margin: 0; padding: 12px; }
+        #source { border-right: 1px solid #ccc; white-space: pre; background: #fafafa; }
+        #tree details { margin-left: 16px; }
+        #tree > details { margin-left: 0; }
+        #tree .leaf { margin-left: 32px; }
+        #tree summary { cursor: pointer; }
+        .node:hover { background: #e8f0fe; }
+        .anonymous .kind { color: #888; }
+        .field { color: #6f42c1; }
+        .range { color: #999; }
+        .text { color: #22863a; }
+        .error > .kind, .missing > .kind { color: #c00; font-weight: bold; }
+        .has-error > .kind::after { content: " \\26A0"; color: #c00; }
+        .badge { color: #fff; background: #c00; border-radius: 3px; padding: 0 3px; }
+        mark { background: #ffe58f; }
+      </style>
+      </head>
+      <body>
+      <pre id="source"></pre>
+      <div id="tree">
+      %<tree>s
+      </div>
+      <script>
+        const source = %<source>s;
+        const pre = document.getElementById("source");
+        function show(start, end) {
+          pre.textContent = "";
+          const mark = document.createElement("mark");
+          mark.textContent = source.slice(start, end);
+          pre.append(source.slice(0, start), mark, source.slice(end));
+          if (end > start) mark.scrollIntoView({ block: "nearest" });
+        }
+        pre.textContent = source;
+        document.querySelectorAll("[data-start]").forEach((element) => {
+          element.addEventListener("mouseenter", () => show(+element.dataset.start, +element.dataset.end));
+          element.addEventListener("mouseleave", () => { pre.textContent = source; });
+        });
+      </script>
+      </body>
+      </html>
+    HTML
+    private_constant :HTML_TEMPLATE
+
+    # Graphviz DOT graph of the tree
+    #
+    # Each node is labeled with its kind and range, leaf nodes with their
+    # text, and edges with field names. ERROR nodes are drawn in red and
+    # MISSING nodes dashed.
+    #
+    # @param anonymous [Boolean] Include anonymous nodes (punctuation, keywords)
+    # @param max_depth [Integer, nil] Stop descending below this depth
+    # @return [String]
+    def to_dot(anonymous: false, max_depth: nil)
+      lines = ["digraph tree {", '  node [shape=box, fontname="monospace"];']
+
+      each_export_node(anonymous, max_depth) do |node, id, parent_id, field|
+        label = [node.kind, node.range.to_s]
+        label << node.text.inspect if export_leaf?(node)
+        attributes = ["label=#{dot_string(label.join("\n"))}"]
+        attributes << 'color="red", fontcolor="red"' if node.error?
+        attributes << "style=dashed" if node.missing?
+        attributes << "shape=ellipse" unless node.named?
+        lines << "  n#{id} [#{attributes.join(", ")}];"
+
+        next unless parent_id
+
+        edge = "  n#{parent_id} -> n#{id}"
+        edge += " [label=#{dot_string(field)}]" if field
+        lines << "#{edge};"
+      end
+
+      lines << "}"
+      "#{lines.join("\n")}\n"
+    end
+
+    # Mermaid flowchart of the tree, for Markdown documents
+    #
+    # @param anonymous [Boolean] Include anonymous nodes (punctuation, keywords)
+    # @param max_depth [Integer, nil] Stop descending below this depth
+    # @return [String]
+    def to_mermaid(anonymous: false, max_depth: nil)
+      lines = ["graph TD"]
+      errors = []
+
+      each_export_node(anonymous, max_depth) do |node, id, parent_id, field|
+        label = mermaid_escape("#{node.kind} #{node.range}")
+        label += "<br/>#{mermaid_escape(node.text.inspect)}" if export_leaf?(node)
+        declaration = "n#{id}[\"#{label}\"]"
+        errors << "n#{id}" if node.error? || node.missing?
+
+        lines << if parent_id.nil?
+          "  #{declaration}"
+        elsif field
+          "  n#{parent_id} -->|#{mermaid_escape(field)}| #{declaration}"
+        else
+          "  n#{parent_id} --> #{declaration}"
+        end
+      end
+
+      unless errors.empty?
+        lines << "  classDef error fill:#fdd,stroke:#c00,color:#900"
+        lines << "  class #{errors.join(",")} error"
+      end
+      "#{lines.join("\n")}\n"
+    end
+
+    # Self-contained HTML page showing the source next to a collapsible tree
+    #
+    # Hovering a node highlights its text in the source. Each node shows its
+    # kind, field name and range; ERROR and MISSING nodes and the nodes that
+    # contain them are flagged.
+    #
+    # @param anonymous [Boolean] Include anonymous nodes (punctuation, keywords)
+    # @param title [String] Page title
+    # @return [String]
+    def to_html(anonymous: true, title: "Syntax tree")
+      char_offsets = export_char_offsets
+      items = []
+      depth = 0
+
+      each_export_node(anonymous, nil) do |node, _id, _parent_id, field, node_depth|
+        while depth > node_depth
+          items << "</details>"
+          depth -= 1
+        end
+
+        classes = ["node"]
+        classes << "anonymous" unless node.named?
+        classes << "error" if node.error?
+        classes << "missing" if node.missing?
+        classes << "has-error" if node.has_error? && !node.error?
+        label = +""
+        label << "<span class=\"field\">#{CGI.escapeHTML(field)}:</span> " if field
+        label << "<span class=\"badge\">MISSING</span> " if node.missing?
+        label << "<span class=\"kind\">#{CGI.escapeHTML(node.named? ? node.kind : node.kind.inspect)}</span>"
+        label << " <span class=\"range\">#{node.range}</span>"
+        label << " <span class=\"text\">#{CGI.escapeHTML(node.text.inspect)}</span>" if export_leaf?(node)
+        data = "data-start=\"#{char_offsets[node.start_byte]}\" data-end=\"#{char_offsets[node.end_byte]}\""
+
+        if node.child_count.zero?
+          items << "<div class=\"#{classes.join(" ")} leaf\" #{data}>#{label}</div>"
+        else
+          items << "<details open><summary class=\"#{classes.join(" ")}\" #{data}>#{label}</summary>"
+          depth += 1
+        end
+      end
+      items.concat(["</details>"] * depth)
+
+      # "</" would end the script element early
+      script_source = JSON.generate(source).gsub("</", "<\\/")
+      format(HTML_TEMPLATE, title: CGI.escapeHTML(title), source: script_source, tree: items.join("\n"))
+    end
+
+    # srcML-style XML document of the tree
+    #
+    # Named nodes become elements named after their kind, with their field
+    # name and position as attributes. Everything else, including anonymous
+    # nodes and whitespace, is kept as text, so the text content of the
+    # document is exactly the source. Control characters XML does not allow
+    # become `<escape char="0x0c"/>` elements, as in srcML:
+    #
+    #   <unit language="rust"><source_file ...><function_item ...>fn <identifier
+    #   field="name" start_byte="3" end_byte="7" start="0:3" end="0:7">main</identifier>...
+    #
+    # Use Tree.source_from_xml to turn a (possibly edited) document back into source.
+    #
+    # @param positions [Boolean] Add start_byte, end_byte, start and end ("row:column") attributes
+    # @param declaration [Boolean] Start with an `<?xml ...?>` declaration
+    # @return [String]
+    def to_xml(positions: true, declaration: true)
+      root = root_node
+      xml = +""
+      xml << %(<?xml version="1.0" encoding="UTF-8"?>\n) if declaration
+      xml << %(<unit language="#{xml_escape(language.name)}">)
+      xml << xml_escape(source.byteslice(0, root.start_byte))
+      append_xml(root, nil, positions, xml)
+      xml << xml_escape(source.byteslice(root.end_byte..))
+      xml << "</unit>\n"
+    end
+
+    class << self
+      # The source text of a document produced by Tree#to_xml
+      #
+      # Markup is dropped and the text inside the `unit` element is
+      # concatenated, with entities, CDATA sections and `escape` elements
+      # decoded.
+      #
+      # @param xml [String] The XML document
+      # @return [String] The source code
+      # @raise [ArgumentError] If the document is not well-formed or has no unit element
+      def source_from_xml(xml)
+        unit = REXML::XPath.first(REXML::Document.new(xml), "//unit")
+        raise ArgumentError, "Not a tree-sitter XML document (no <unit> element)" unless unit
+
+        xml_text(unit)
+      rescue REXML::ParseException => e
+        raise ArgumentError, "Not a well-formed XML document: #{e.message.lines.first&.strip}"
+      end
+
+      private
+
+      def xml_text(element, result = +"")
+        element.children.each do |child|
+          case child
+          when REXML::Text # CDATA sections too
+            result << child.value
+          when REXML::Element
+            if child.name == "escape" && (char = child.attributes["char"])
+              result << Integer(char).chr(Encoding::UTF_8)
+            else
+              xml_text(child, result)
+            end
+          end
+        end
+        result
+      end
+    end
+
+    private
+
+    # Depth-first walk yielding each node with a numeric id, its parent's id,
+    # its field name and its depth
+    def each_export_node(anonymous, max_depth)
+      next_id = 0
+      stack = [[root_node, nil, nil, 0]]
+
+      until stack.empty?
+        node, parent_id, field, depth = stack.pop
+        id = next_id
+        next_id += 1
+        yield node, id, parent_id, field, depth
+        next if max_depth && depth >= max_depth
+
+        children = node.children.each_with_index.filter_map do |child, index|
+          [child, id, node.field_name_for_child(index), depth + 1] if anonymous || child.named?
+        end
+        stack.concat(children.reverse)
+      end
+    end
+
+    def export_leaf?(node)
+      node.child_count.zero? && node.named? && !node.missing?
+    end
+
+    def dot_string(text)
+      "\"#{text.gsub("\\") { "\\\\" }.gsub('"', '\"').gsub("\n", "\\n")}\""
+    end
+
+    def mermaid_escape(text)
+      text.gsub('"', "#quot;").gsub("<", "#lt;").gsub(">", "#gt;")
+    end
+
+    # JavaScript string offset (in UTF-16 code units) of every byte offset
+    def export_char_offsets
+      offsets = []
+      index = 0
+      source.each_char do |char|
+        char.bytesize.times { offsets << index }
+        index += char.ord > 0xFFFF ? 2 : 1
+      end
+      offsets << index
+      offsets
+    end
+
+    def append_xml(node, field, positions, xml)
+      name = xml_name(node.kind)
+      attributes = []
+      attributes << %(field="#{xml_escape(field)}") if field
+      if positions
+        attributes << %(start_byte="#{node.start_byte}" end_byte="#{node.end_byte}")
+        attributes << %(start="#{node.start_point.row}:#{node.start_point.column}")
+        attributes << %(end="#{node.end_point.row}:#{node.end_point.column}")
+      end
+      attributes << 'missing="true"' if node.missing?
+      xml << "<#{[name, *attributes].join(" ")}>"
+
+      # Text between named children (whitespace, punctuation, keywords) is
+      # copied as is
+      cursor = node.start_byte
+      node.children.each_with_index do |child, index|
+        next unless child.named?
+
+        xml << xml_escape(source.byteslice(cursor, child.start_byte - cursor)) if child.start_byte > cursor
+        append_xml(child, node.field_name_for_child(index), positions, xml)
+        cursor = [cursor, child.end_byte].max
+      end
+      xml << xml_escape(source.byteslice(cursor, node.end_byte - cursor)) if node.end_byte > cursor
+      xml << "</#{name}>"
+    end
+
+    # Node kinds are almost always valid element names; replace anything that isn't
+    def xml_name(kind)
+      name = kind.gsub(/[^A-Za-z0-9_.-]/, "_")
+      name.match?(/\A[A-Za-z_]/) ? name : "_#{name}"
+    end
+
+    # Carriage returns are escaped too, since XML parsers would turn "\r\n"
+    # into "\n"; characters XML 1.0 does not allow, not even as references,
+    # become escape elements
+    def xml_escape(text)
+      text.to_s.gsub(/[&<>"\r\x00-\x08\v\f\x0E-\x1F]/) do |char|
+        XML_ESCAPES.fetch(char) { %(<escape char="0x#{format("%02x", char.ord)}"/>) }
+      end
+    end
+  end
+end
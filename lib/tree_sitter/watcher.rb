@@ -0,0 +1,241 @@
+# frozen_string_literal: true
+
+require "rbconfig"
+require_relative "language_detector"
+require_relative "parser"
+
+module TreeSitter
+  # Watches files and keeps an up-to-date syntax tree for each of them.
+  #
+  # When a file changes it is re-parsed incrementally from the previous tree
+  # with `Parser#reparse`. Each change is yielded as
+  # `[path, tree, changed_ranges]`; a deleted file yields a nil tree.
+  #
+  # On Linux the `rb-inotify` gem is used when it is installed; everywhere
+  # else (or when it is missing) files are polled for changes.
+  #
+  # @example Keep an outline panel current
+  #   watcher = TreeSitter::Watcher.new(["src"], detector: TreeSitter::LanguageDetector.new)
+  #   watcher.run do |path, tree, changed_ranges|
+  #     refresh_outline(path, tree) if tree
+  #   end
+  #
+  class Watcher
+    # What the watcher remembers about each file
+    FileState = Struct.new(:source, :tree, :mtime, :size, keyword_init: true)
+
+    attr_reader :paths, :interval, :backend
+
+    # Initialize a new Watcher
+    #
+    # @param paths [Array<String>, String] Files and directories to watch (directories recursively)
+    # @param detector [#call] Returns the language name for a path, or nil to ignore the file;
+    #   it is asked again about a file only when the file's mtime changes
+    # @param interval [Float] Seconds between polls (and between stop checks with inotify)
+    # @param backend [Symbol, nil] :inotify or :polling (default: inotify when available)
+    def initialize(paths, detector: LanguageDetector.new(registered_only: true), interval: 0.5, backend: nil)
+      @paths = Array(paths).map { |path| File.expand_path(path) }
+      @detector = detector
+      @interval = interval
+      @backend = backend || (inotify_available? ? :inotify : :polling)
+      @files = {}
+      @languages = {}
+      @parsers = {}
+      @stopped = false
+    end
+
+    # @return [Hash{String => TreeSitter::Tree}] The current tree of every tracked file
+    def trees
+      @files.transform_values(&:tree)
+    end
+
+    # @param path [String] A tracked file
+    # @return [TreeSitter::Tree, nil] Its current tree
+    def tree_for(path)
+      @files[File.expand_path(path)]&.tree
+    end
+
+    # Check every watched path once, parsing new files and re-parsing changed ones
+    #
+    # The first call parses everything; each new file is reported with its
+    # whole root range as changed.
+    #
+    # @yield [path, tree, changed_ranges] For each file that changed
+    # @return [Array<Array(String, TreeSitter::Tree, Array<TreeSitter::Range>)>] The changes
+    def poll(&block)
+      changes = (watched_files | @files.keys).filter_map { |path| refresh(path) }
+      changes.each { |change| block&.call(*change) }
+      changes
+    end
+
+    # Check a single file and return its change, if any
+    #
+    # A file that is gone (or is no longer a regular file) is treated as
+    # deleted, also when it disappears while it is being read.
+    #
+    # @param path [String] The file to check
+    # @return [Array(String, TreeSitter::Tree, Array<TreeSitter::Range>), nil]
+    def refresh(path)
+      path = File.expand_path(path)
+      state = @files[path]
+
+      stat = File.stat(path)
+      return forget(path) unless stat.file?
+      return if state && state.mtime == stat.mtime && state.size == stat.size
+
+      source = File.read(path)
+
+      if state
+        unless source == state.source
+          parser = parser_for(state.tree.language.name)
+          tree, changed_ranges = parser.reparse(state.tree, source, old_source: state.source)
+          # Without recording the mtime and size, so that the next poll tries again
+          return unless tree
+
+          state.source = source
+          state.tree = tree
+        end
+        state.mtime = stat.mtime
+        state.size = stat.size
+        return unless tree
+      else
+        language = language_for(path, stat.mtime)
+        return unless language
+
+        tree = parser_for(language).parse(source)
+        return unless tree
+
+        changed_ranges = [tree.root_node.range]
+        @files[path] = FileState.new(source: source, tree: tree, mtime: stat.mtime, size: stat.size)
+      end
+
+      [path, tree, changed_ranges]
+    rescue Errno::ENOENT
+      # Deleted after it was listed, as editors' temporary files often are
+      forget(path)
+    end
+
+    # Parse everything, then block and yield changes until #stop is called
+    #
+    # @yield [path, tree, changed_ranges] For each file that changed
+    # @return [void]
+    def run(&block)
+      raise ArgumentError, "Watcher#run needs a block" unless block
+
+      @stopped = false
+      poll(&block)
+
+      if @backend == :inotify
+        run_inotify(&block)
+      else
+        run_polling(&block)
+      end
+    end
+
+    # Make #run return after its current iteration
+    #
+    # @return [void]
+    def stop
+      @stopped = true
+    end
+
+    private
+
+    # Stop tracking a file; a tracked file is reported as deleted
+    def forget(path)
+      @languages.delete(path)
+      [path, nil, []] if @files.delete(path)
+    end
+
+    def parser_for(language)
+      @parsers[language] ||= TreeSitter::Parser.new.tap { |parser| parser.language = language }
+    end
+
+    def watched_files
+      @paths.flat_map do |path|
+        if File.directory?(path)
+          Dir.glob("**/*", base: path).map { |relative| File.join(path, relative) }.select do |file|
+            File.file?(file) && (@files.key?(file) || language_for(file))
+          end
+        elsif File.file?(path) || @files.key?(path)
+          [path]
+        else
+          []
+        end
+      end
+    end
+
+    # The detector's language for a file, remembered until its mtime changes;
+    # nil if the file is gone
+    def language_for(path, mtime = nil)
+      mtime ||= File.mtime(path)
+      cached_mtime, language = @languages[path]
+      return language if cached_mtime == mtime
+
+      language = @detector.call(path)
+      @languages[path] = [mtime, language]
+      language
+    rescue Errno::ENOENT
+      nil
+    end
+
+    def run_polling(&block)
+      until @stopped
+        sleep(@interval)
+        poll(&block)
+      end
+    end
+
+    def run_inotify(&block)
+      notifier = INotify::Notifier.new
+
+      watched_directories.each do |directory, recursive|
+        flags = [:close_write, :moved_to, :moved_from, :create, :delete]
+        flags << :recursive if recursive
+        notifier.watch(directory, *flags) do |event|
+          path = event.absolute_name
+          next unless tracked?(path)
+
+          change = refresh(path)
+          block.call(*change) if change
+        end
+      end
+
+      until @stopped
+        ready = IO.select([notifier.to_io], nil, nil, @interval)
+        notifier.process if ready
+      end
+    ensure
+      notifier&.close
+    end
+
+    # Directories to hand to inotify. Single files are watched through their
+    # parent directory so that editors which save by renaming are noticed.
+    def watched_directories
+      @paths.map do |path|
+        File.directory?(path) ? [path, true] : [File.dirname(path), false]
+      end.uniq
+    end
+
+    def tracked?(path)
+      return true if @files.key?(path)
+
+      @paths.any? do |watched|
+        if File.directory?(watched)
+          path.start_with?("#{watched}/") && File.file?(path) && language_for(path)
+        else
+          path == watched
+        end
+      end
+    end
+
+    def inotify_available?
+      return false unless RbConfig::CONFIG["host_os"].include?("linux")
+
+      require "rb-inotify"
+      true
+    rescue LoadError
+      false
+    end
+  end
+end
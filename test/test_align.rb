@@ -0,0 +1,105 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestAlign < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+  end
+
+  def test_aligns_assignment_runs
+    source = <<~RUST
+      fn main() {
+          let x = 1;
+          let total   = 2;
+          let ab = 3;
+
+          let alone = 4;
+          let b = 5;
+          // comment
+          let longer_name = 6;
+      }
+    RUST
+    tree = @parser.parse(source)
+
+    assert_equal(<<~RUST, TreeSitter::Align.align(tree, "(let_declaration) @let", on: "="))
+      fn main() {
+          let x     = 1;
+          let total = 2;
+          let ab    = 3;
+
+          let alone = 4;
+          let b     = 5;
+          // comment
+          let longer_name = 6;
+      }
+    RUST
+  end
+
+  def test_aligns_struct_fields
+    source = <<~RUST
+      fn main() {
+          let p = Point {
+              x: 1,
+              longer: 2,
+          };
+      }
+    RUST
+    tree = @parser.parse(source)
+    fields = tree.root_node.child(0).child_by_field_name("body").named_children.first
+      .child_by_field_name("value").child_by_field_name("body").named_children
+
+    assert_includes(TreeSitter::Align.align(tree, fields, on: ":"), "        x     : 1,\n        longer: 2,\n")
+
+    result = TreeSitter::Align.align(tree, fields, on: :value)
+
+    assert_includes(result, "        x:      1,\n        longer: 2,\n")
+    assert_empty(TreeSitter::Align.edits(@parser.parse(result), "(field_initializer) @field", on: :value))
+  end
+
+  def test_aligns_on_a_field
+    source = <<~RUST
+      struct Point {
+          x: i32,
+          name: String,
+      }
+    RUST
+    tree = @parser.parse(source)
+
+    result = TreeSitter::Align.align(tree, "(field_declaration) @field", on: :type)
+
+    assert_includes(result, "    x:    i32,\n    name: String,\n")
+  end
+
+  def test_aligns_ruby_hash_rockets
+    register_language("ruby")
+    source = "config = {\n  :a => 1,\n  :longer => 2,\n}\n"
+
+    assert_equal(
+      "config = {\n  :a      => 1,\n  :longer => 2,\n}\n",
+      TreeSitter::Align.align(parse("ruby", source), "(pair) @pair", on: "=>"),
+    )
+  end
+
+  def test_aligns_go_struct_field_types
+    register_language("go")
+    source = "package main\n\ntype Point struct {\n\tX int\n\tName string\n}\n"
+
+    assert_equal(
+      "package main\n\ntype Point struct {\n\tX    int\n\tName string\n}\n",
+      TreeSitter::Align.align(parse("go", source), "(field_declaration) @field", on: :type),
+    )
+  end
+
+  private
+
+  def parse(language, source)
+    parser = TreeSitter::Parser.new
+    parser.language = language
+    parser.parse(source)
+  end
+end
@@ -0,0 +1,118 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "tmpdir"
+
+class TestChangeSet < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @source = <<~RUST
+      fn add(a: i32, b: i32) -> i32 {
+          a + b
+      }
+    RUST
+    @tree = @parser.parse(@source)
+    @function = @tree.root_node.child(0)
+    @name = @function.child_by_field_name("name")
+  end
+
+  def test_combines_edits_from_several_tools
+    changes = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum").to_change_set(path: "lib.rs")
+    changes.merge!(
+      TreeSitter::Inserter.new(@source, @tree).after(@function).insert_sibling("fn zero() -> i32 { 0 }")
+        .to_change_set(path: "lib.rs"),
+    )
+
+    assert_equal(2, changes.size)
+    assert_equal(["rewriter", "inserter"], changes.map(&:origin))
+    assert_equal(<<~RUST, changes.result("lib.rs"))
+      fn sum(a: i32, b: i32) -> i32 {
+          a + b
+      }
+
+      fn zero() -> i32 { 0 }
+    RUST
+  end
+
+  def test_matches_the_tool_output
+    params = @function.child_by_field_name("parameters").named_children
+    transformer = TreeSitter::Transformer.new(@source, @tree).swap(params[0], params[1])
+
+    assert_equal(transformer.rewrite, transformer.to_change_set.result(nil))
+
+    query_rewriter = TreeSitter::QueryRewriter.new(@source, @tree, "rust")
+      .query("(identifier) @id")
+      .wrap("@id", before: "(", after: ")")
+
+    assert_equal(query_rewriter.rewrite, query_rewriter.to_change_set.result(nil))
+  end
+
+  def test_conflicting_edits
+    changes = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum").to_change_set
+    other = TreeSitter::Rewriter.new(@source, @tree).replace(@function, "").to_change_set
+
+    error = assert_raises(TreeSitter::ConflictError) { changes.merge!(other) }
+    assert_includes(error.message, "3...6")
+    assert_equal(1, changes.size)
+  end
+
+  def test_identical_edits_are_kept_once
+    changes = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum").to_change_set
+    changes.merge!(
+      TreeSitter::QueryRewriter.new(@source, @tree, "rust")
+        .query("(function_item name: (identifier) @name)")
+        .replace("@name") { "sum" }
+        .to_change_set,
+    )
+
+    assert_equal(1, changes.size)
+    assert_includes(changes.result(nil), "fn sum(")
+  end
+
+  def test_insertions_at_the_same_point
+    changes = TreeSitter::ChangeSet.new
+    changes.add("a.rs", @source, [[0, 0, "// a\n"], [0, 0, "// b\n"]], origin: "first")
+
+    assert(changes.result("a.rs").start_with?("// a\n// b\nfn add"))
+    assert_raises(TreeSitter::ConflictError) { changes.add("a.rs", @source, [[0, 0, "// c\n"]], origin: "second") }
+  end
+
+  def test_base_must_match
+    changes = TreeSitter::ChangeSet.new.add("a.rs", @source, [[0, 2, "pub fn"]])
+
+    assert_raises(TreeSitter::ConflictError) { changes.add("a.rs", "fn other() {}\n", [[0, 0, "// x\n"]]) }
+    assert_raises(ArgumentError) { changes.add("b.rs", "x", [[0, 5, "y"]]) }
+  end
+
+  def test_json_round_trip
+    changes = TreeSitter::ChangeSet.new
+      .add("a.rs", @source, [[3, 6, "sum"]], origin: "rewriter")
+      .add("b.rs", "let x = \"é\";\n", [[8, 12, "\"ü\""]])
+    loaded = TreeSitter::ChangeSet.from_json(changes.to_json)
+
+    assert_equal(changes.results, loaded.results)
+    assert_equal(changes.map(&:origin), loaded.map(&:origin))
+    assert_equal("let x = \"ü\";\n", loaded.result("b.rs"))
+    assert_raises(ArgumentError) { TreeSitter::ChangeSet.from_json("{}") }
+  end
+
+  def test_apply_and_revert
+    Dir.mktmpdir do |dir|
+      File.write(File.join(dir, "lib.rs"), @source)
+      changes = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum").to_change_set(path: "lib.rs")
+
+      changes.apply!(root: dir)
+
+      assert_includes(File.read(File.join(dir, "lib.rs")), "fn sum(")
+      assert_raises(TreeSitter::ConflictError) { changes.apply!(root: dir) }
+
+      changes.revert!(root: dir)
+
+      assert_equal(@source, File.read(File.join(dir, "lib.rs")))
+    end
+  end
+end
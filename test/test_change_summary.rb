@@ -0,0 +1,152 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestChangeSummary < Minitest::Test
+  include TestHelper
+
+  OLD_SOURCE = <<~RUST
+    struct Point {
+        x: f64,
+        y: f64,
+    }
+
+    impl Point {
+        fn distance(&self, other: &Point) -> f64 {
+            ((self.x - other.x).powi(2) + (self.y - other.y).powi(2)).sqrt()
+        }
+
+        fn norm(&self) -> f64 {
+            (self.x * self.x + self.y * self.y).sqrt()
+        }
+    }
+
+    fn helper(a: i32) -> i32 {
+        a * 2 + 1
+    }
+
+    fn unused() {}
+  RUST
+
+  NEW_SOURCE = <<~RUST
+    struct Point {
+        x: f64,
+        y: f64,
+    }
+
+    impl Point {
+        fn distance(&self, other: &Self) -> f64 {
+            ((self.x - other.x).powi(2) + (self.y - other.y).powi(2)).sqrt()
+        }
+
+        fn norm(&self) -> f64 {
+            self.distance(&Point { x: 0.0, y: 0.0 })
+        }
+    }
+
+    fn double_plus_one(a: i32) -> i32 {
+        a * 2 + 1
+    }
+
+    fn midpoint(a: f64, b: f64) -> f64 {
+        (a + b) / 2.0
+    }
+  RUST
+
+  def setup
+    register_language("rust")
+  end
+
+  def test_symbol_level_changes
+    summary = TreeSitter::ChangeSummary.between(OLD_SOURCE, NEW_SOURCE, lang: "rust")
+
+    assert_equal(
+      [
+        "modified `Point::distance` signature",
+        "modified `Point::norm` body",
+        "renamed function `helper` to `double_plus_one`",
+        "added function `midpoint`",
+        "removed function `unused`",
+      ],
+      summary.map(&:to_s),
+    )
+    refute_predicate(summary, :formatting_only?)
+  end
+
+  def test_change_details
+    summary = TreeSitter::ChangeSummary.between(OLD_SOURCE, NEW_SOURCE, lang: "rust")
+    rename = summary.of_type(:renamed).first
+
+    assert_equal(:function, rename.kind)
+    assert_equal("helper", rename.old_name)
+    assert_equal("double_plus_one", rename.new_definition.name)
+    assert_equal(:method, summary.of_type(:signature_changed).first.kind)
+  end
+
+  def test_formatting_only_change
+    old_source = "fn add(a: i32, b: i32) -> i32 { a + b }\n"
+    new_source = "fn add(a: i32, b: i32) -> i32 {\n    a + b\n}\n"
+
+    summary = TreeSitter::ChangeSummary.between(old_source, new_source, lang: "rust")
+
+    assert_predicate(summary, :formatting_only?)
+    assert_equal(["reformatted `add`"], summary.map(&:to_s))
+  end
+
+  def test_identical_sources
+    summary = TreeSitter::ChangeSummary.between(OLD_SOURCE, OLD_SOURCE, lang: "rust")
+
+    assert_empty(summary)
+    refute_predicate(summary, :formatting_only?)
+    assert_equal("", summary.to_s)
+  end
+
+  def test_members_follow_a_renamed_container
+    old_source = <<~RUST
+      struct Point {
+          x: f64,
+      }
+
+      impl Point {
+          fn new(x: f64) -> Self {
+              Self { x }
+          }
+
+          fn get(&self) -> f64 {
+              self.x
+          }
+      }
+    RUST
+    new_source = old_source.gsub("Point", "Vec2").sub("self.x\n", "self.x * 2.0\n")
+
+    summary = TreeSitter::ChangeSummary.between(old_source, new_source, lang: "rust")
+
+    assert_equal(
+      [
+        "renamed struct `Point` to `Vec2`",
+        "renamed impl `Point` to `Vec2`",
+        "modified `Vec2::get` body",
+      ],
+      summary.map(&:to_s),
+    )
+  end
+
+  def test_rename_with_a_body_change
+    summary = TreeSitter::ChangeSummary.between(
+      "fn helper(a: i32) -> i32 {\n    a * 2 + 1\n}\n",
+      "fn double_plus_one(a: i32) -> i32 {\n    a * 2 + 2\n}\n",
+      lang: "rust",
+    )
+
+    assert_equal(
+      ["renamed function `helper` to `double_plus_one`", "modified `double_plus_one` body"],
+      summary.map(&:to_s),
+    )
+  end
+
+  def test_dissimilar_functions_are_not_renames
+    summary = TreeSitter::ChangeSummary.between("fn a() {}\n", "fn b(x: i32) -> i32 { x * x + 1 }\n", lang: "rust")
+
+    assert_equal(["added function `b`", "removed function `a`"], summary.map(&:to_s))
+  end
+end
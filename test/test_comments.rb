@@ -0,0 +1,139 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestComments < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+  end
+
+  def test_rewraps_consecutive_line_comments
+    source = <<~RUST
+      fn main() {
+          // This is a long comment that should be wrapped because it goes past the width limit.
+          // Short.
+          let x = 1; // trailing comments are left alone even when they are long
+      }
+    RUST
+    result = TreeSitter::Comments.reflow(@parser.parse(source), width: 40)
+
+    assert_equal(<<~RUST, result)
+      fn main() {
+          // This is a long comment that
+          // should be wrapped because it goes
+          // past the width limit. Short.
+          let x = 1; // trailing comments are left alone even when they are long
+      }
+    RUST
+    assert_equal(result, TreeSitter::Comments.reflow(@parser.parse(result), width: 40))
+  end
+
+  def test_keeps_lists_and_code_blocks_in_doc_comments
+    source = <<~RUST
+      /// Adds numbers.
+      ///
+      /// - first item which is rather long and needs wrapping
+      /// - second
+      ///
+      /// ```
+      /// let very_long_line_of_code_that_stays_as_it_is = add(1, 2);
+      /// ```
+      fn add() {}
+    RUST
+
+    assert_equal(<<~RUST, TreeSitter::Comments.reflow(@parser.parse(source), width: 40))
+      /// Adds numbers.
+      ///
+      /// - first item which is rather long
+      ///   and needs wrapping
+      /// - second
+      ///
+      /// ```
+      /// let very_long_line_of_code_that_stays_as_it_is = add(1, 2);
+      /// ```
+      fn add() {}
+    RUST
+  end
+
+  def test_normalizes_block_comments
+    source = <<~RUST
+      fn main() {
+          /* Block comment
+             over two lines */
+          let x = 1;
+      }
+    RUST
+    tree = @parser.parse(source)
+
+    assert_equal(<<~RUST, TreeSitter::Comments.reflow(tree, normalize: true))
+      fn main() {
+          // Block comment over two lines
+          let x = 1;
+      }
+    RUST
+    assert_equal(source, TreeSitter::Comments.reflow(tree))
+  end
+
+  def test_keeps_plain_block_comments
+    source = <<~RUST
+      /*
+      let total = compute_the_total(first_value, second_value);
+      print(total);
+      */
+      fn main() {}
+    RUST
+
+    assert_equal(source, TreeSitter::Comments.reflow(@parser.parse(source), width: 30))
+  end
+
+  def test_rewraps_doc_block_comments_with_their_own_prefix
+    starred = <<~RUST
+      /**
+       * Adds two numbers together and returns the sum of them.
+       */
+      fn add() {}
+    RUST
+    aligned = <<~RUST
+      /** Adds two numbers together
+          and returns the sum of them. */
+      fn add() {}
+    RUST
+
+    assert_equal(<<~RUST, TreeSitter::Comments.reflow(@parser.parse(starred), width: 40))
+      /**
+       * Adds two numbers together and returns
+       * the sum of them.
+       */
+      fn add() {}
+    RUST
+    assert_equal(<<~RUST, TreeSitter::Comments.reflow(@parser.parse(aligned), width: 36))
+      /** Adds two numbers together and
+          returns the sum of them. */
+      fn add() {}
+    RUST
+  end
+
+  def test_xml_tag_lines_are_paragraph_breaks
+    register_language("c_sharp")
+    parser = TreeSitter::Parser.new
+    parser.language = "c_sharp"
+    source = <<~CS
+      /// <summary>
+      /// Adds two numbers together and returns the sum.
+      /// </summary>
+      class Calculator {}
+    CS
+
+    assert_equal(<<~CS, TreeSitter::Comments.reflow(parser.parse(source), width: 30))
+      /// <summary>
+      /// Adds two numbers together
+      /// and returns the sum.
+      /// </summary>
+      class Calculator {}
+    CS
+  end
+end
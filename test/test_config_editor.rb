@@ -0,0 +1,228 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestConfigEditor < Minitest::Test
+  include TestHelper
+
+  PACKAGE_JSON = <<~JSON
+    {
+      // build settings
+      "name": "demo",
+      "version": "1.0.0",
+      "keywords": ["a", "b"],
+      "scripts": {
+        "test": "rake"
+      }
+    }
+  JSON
+
+  CARGO_TOML = <<~TOML
+    # Package manifest
+    [package]
+    name = "demo"
+    version = "0.1.0" # bump on release
+
+    [dependencies]
+    serde = { version = "1.0", features = ["derive"] }
+    regex = '1.5'
+
+    [[bin]]
+    name = "demo"
+    path = "src/main.rs"
+  TOML
+
+  WORKFLOW_YAML = <<~YAML
+    # CI config
+    name: build
+    env:
+      RUBY_VERSION: "3.3"
+    jobs:
+      test:
+        runs-on: 'ubuntu-latest'
+        steps:
+          - uses: actions/checkout@v4
+          - run: make test
+        tags: [ci, ruby]
+  YAML
+
+  def setup
+    register_language("json")
+    register_language("toml")
+    register_language("yaml")
+  end
+
+  def json
+    TreeSitter::ConfigEditor.new(PACKAGE_JSON, lang: "json")
+  end
+
+  def toml
+    TreeSitter::ConfigEditor.new(CARGO_TOML, lang: "toml")
+  end
+
+  def yaml
+    TreeSitter::ConfigEditor.new(WORKFLOW_YAML, lang: "yaml")
+  end
+
+  def test_unsupported_language
+    assert_raises(ArgumentError) { TreeSitter::ConfigEditor.new("a=1", lang: "ini") }
+  end
+
+  # --- JSON ---
+
+  def test_json_get
+    editor = json
+
+    assert_equal("demo", editor.get("name"))
+    assert_equal(["a", "b"], editor.get("keywords"))
+    assert_equal("b", editor.get("keywords.1"))
+    assert_equal({ "test" => "rake" }, editor.get("scripts"))
+    assert_nil(editor.get("missing"))
+    assert(editor.key?("scripts.test"))
+  end
+
+  def test_json_set_existing
+    assert_equal(PACKAGE_JSON.sub("1.0.0", "2.0.0"), json.set("version", "2.0.0").source)
+  end
+
+  def test_json_set_new_keys
+    source = json.set("scripts.build", "make").set("engines", { "node" => ">=18" }).source
+
+    assert_equal(<<~JSON, source)
+      {
+        // build settings
+        "name": "demo",
+        "version": "1.0.0",
+        "keywords": ["a", "b"],
+        "scripts": {
+          "test": "rake",
+          "build": "make"
+        },
+        "engines": {
+          "node": ">=18"
+        }
+      }
+    JSON
+  end
+
+  def test_json_delete
+    assert_equal(PACKAGE_JSON.sub(%(  "version": "1.0.0",\n), ""), json.delete("version").source)
+    assert_equal(<<~JSON, json.delete("scripts").source)
+      {
+        // build settings
+        "name": "demo",
+        "version": "1.0.0",
+        "keywords": ["a", "b"]
+      }
+    JSON
+    assert_raises(KeyError) { json.delete("missing") }
+  end
+
+  def test_json_append_and_rename
+    source = json.append("keywords", "c").rename_key("name", "title").source
+
+    assert_includes(source, %("keywords": ["a", "b", "c"]))
+    assert_includes(source, %("title": "demo"))
+    assert_includes(source, "// build settings")
+  end
+
+  # --- TOML ---
+
+  def test_toml_get
+    editor = toml
+
+    assert_equal("demo", editor.get("package.name"))
+    assert_equal("1.0", editor.get("dependencies.serde.version"))
+    assert_equal(["derive"], editor.get("dependencies.serde.features"))
+    assert_equal("1.5", editor.get("dependencies.regex"))
+    assert_equal("src/main.rs", editor.get("bin.0.path"))
+  end
+
+  def test_toml_set_keeps_quoting_and_comments
+    source = toml
+      .set("package.version", "0.2.0")
+      .set("dependencies.regex", "1.6")
+      .set("dependencies.serde.version", "1.1")
+      .source
+
+    assert_includes(source, %(version = "0.2.0" # bump on release))
+    assert_includes(source, "regex = '1.6'")
+    assert_includes(source, %(serde = { version = "1.1", features = ["derive"] }))
+  end
+
+  def test_toml_add_keys
+    source = toml.set("dependencies.anyhow", "1.0").set("workspace.members", ["a"]).source
+
+    assert_includes(source, %(regex = '1.5'\nanyhow = "1.0"\n\n[[bin]]))
+    assert(source.end_with?(%(path = "src/main.rs"\n\n[workspace]\nmembers = ["a"]\n)))
+  end
+
+  def test_toml_delete
+    assert_equal(CARGO_TOML.sub(%(version = "0.1.0" # bump on release\n), ""), toml.delete("package.version").source)
+    assert_equal(
+      CARGO_TOML.sub(%([dependencies]\nserde = { version = "1.0", features = ["derive"] }\nregex = '1.5'\n\n), ""),
+      toml.delete("dependencies").source,
+    )
+  end
+
+  def test_toml_append_and_rename
+    source = toml.append("dependencies.serde.features", "std").rename_key("dependencies.regex", "fancy-regex").source
+
+    assert_includes(source, %(features = ["derive", "std"]))
+    assert_includes(source, "fancy-regex = '1.5'")
+  end
+
+  # --- YAML ---
+
+  def test_yaml_get
+    editor = yaml
+
+    assert_equal("build", editor.get("name"))
+    assert_equal("3.3", editor.get("env.RUBY_VERSION"))
+    assert_equal("make test", editor.get("jobs.test.steps.1.run"))
+    assert_equal({ "uses" => "actions/checkout@v4" }, editor.get("jobs.test.steps.0"))
+    assert_equal(["ci", "ruby"], editor.get("jobs.test.tags"))
+  end
+
+  def test_yaml_set_keeps_quoting
+    source = yaml
+      .set("env.RUBY_VERSION", "3.4")
+      .set("jobs.test.runs-on", "macos-latest")
+      .set("name", "true")
+      .source
+
+    assert_includes(source, %(RUBY_VERSION: "3.4"))
+    assert_includes(source, "runs-on: 'macos-latest'")
+    assert_includes(source, %(name: "true"))
+  end
+
+  def test_yaml_add_keys
+    source = yaml.set("env.DEBUG", true).set("jobs.test.services", { "db" => "postgres" }).source
+
+    assert_includes(source, %(env:\n  RUBY_VERSION: "3.3"\n  DEBUG: true\njobs:))
+    assert(source.end_with?("    tags: [ci, ruby]\n    services:\n      db: postgres\n"))
+  end
+
+  def test_yaml_append
+    source = yaml.append("jobs.test.steps", { "run" => "make lint" }).append("jobs.test.tags", "lint").source
+
+    assert_includes(source, "      - run: make test\n      - run: make lint\n    tags: [ci, ruby, lint]\n")
+    assert_equal("make lint", TreeSitter::ConfigEditor.new(source, lang: "yaml").get("jobs.test.steps.2.run"))
+  end
+
+  def test_yaml_delete_and_rename
+    source = yaml.delete("jobs.test.runs-on").delete("jobs.test.steps.0").rename_key("env", "environment").source
+
+    assert_equal(<<~YAML, source)
+      # CI config
+      name: build
+      environment:
+        RUBY_VERSION: "3.3"
+      jobs:
+        test:
+          steps:
+            - run: make test
+          tags: [ci, ruby]
+    YAML
+  end
+end
@@ -197,4 +197,62 @@ class TestFormatting < Minitest::Test
     # Should default to spaces with size 4
     assert_equal(:spaces, result[:style])
   end
+
+  def test_reindent
+    source = "fn main() {\n    run();\n}\n"
+    detector = TreeSitter::Formatting::IndentationDetector.new(source)
+
+    assert_equal("if ready {\n        go();\n\n    }", detector.reindent("if ready {\n    go();\n  \n}", 16))
+    assert_equal("a\n    b", detector.reindent("a\n        b", 16, from: "        "))
+    assert_equal("a;\n    ", detector.reindent("a;\n", 16))
+  end
+
+  def test_indent_leaves_multiline_strings_alone
+    register_language("rust")
+    parser = TreeSitter::Parser.new
+    parser.language = "rust"
+    source = "fn main() {\n    let s = \"a\n  b\";\n    run();\n}\n"
+    function = parser.parse(source).root_node.child(0)
+    detector = TreeSitter::Formatting::IndentationDetector.new(source)
+
+    indented = detector.indent(function.text, verbatim: function)
+
+    assert_equal("    fn main() {\n        let s = \"a\n  b\";\n        run();\n    }", indented)
+    verbatim = TreeSitter::Formatting.verbatim_ranges(parser.parse(indented).root_node, base: 0)
+    assert_equal(function.text, detector.dedent(indented, verbatim: verbatim))
+  end
+
+  def test_indent_parses_the_content_in_the_trees_language
+    register_language("rust")
+    parser = TreeSitter::Parser.new
+    parser.language = "rust"
+    source = "fn main() {\n    let s = \"a\n  b\";\n    run();\n}\n"
+    tree = parser.parse(source)
+    function = tree.root_node.child(0)
+    detector = TreeSitter::Formatting::IndentationDetector.new(source, tree: tree)
+
+    indented = detector.indent(function.text)
+
+    assert_equal("    fn main() {\n        let s = \"a\n  b\";\n        run();\n    }", indented)
+    assert_equal(function.text, detector.dedent(indented))
+    assert_equal("let s = \"a\n  b\";", detector.adjust_indentation("    let s = \"a\n  b\";", 0))
+  end
+
+  def test_verbatim_kinds_are_listed_per_language
+    assert_equal(["interpreted_string_literal", "raw_string_literal"], TreeSitter::Formatting.verbatim_kinds("go"))
+    assert_includes(TreeSitter::Formatting.verbatim_kinds(TreeSitter.language("rust")), "raw_string_literal")
+    assert_equal(TreeSitter::Formatting::DEFAULT_VERBATIM_KINDS, TreeSitter::Formatting.verbatim_kinds("lua"))
+  end
+
+  def test_retab
+    register_language("rust")
+    parser = TreeSitter::Parser.new
+    parser.language = "rust"
+    source = "fn main() {\n    let s = \"a\n    b\";\n    if x {\n        run();\n    }\n}\n"
+    tabbed = "fn main() {\n\tlet s = \"a\n    b\";\n\tif x {\n\t\trun();\n\t}\n}\n"
+
+    assert_equal(tabbed, TreeSitter::Formatting::IndentationDetector.new(source, tree: parser.parse(source)).retab(:tabs))
+    assert_equal(source, TreeSitter::Formatting::IndentationDetector.new(tabbed, tree: parser.parse(tabbed)).retab(:spaces))
+    assert_raises(ArgumentError) { TreeSitter::Formatting::IndentationDetector.new(source).retab(:mixed) }
+  end
 end
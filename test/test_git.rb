@@ -0,0 +1,101 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "open3"
+require "tmpdir"
+
+class TestGit < Minitest::Test
+  include TestHelper
+
+  SOURCE = <<~RUST
+    fn main() {
+        let a = 1;
+        let b = 2;
+    }
+  RUST
+
+  def test_ranges_from_diff
+    diff = <<~DIFF
+      diff --git a/main.rs b/main.rs
+      --- a/main.rs
+      +++ b/main.rs
+      @@ -2 +2 @@ fn main() {
+      -    let a = 0;
+      +    let a = 1;
+      @@ -4,0 +4,1 @@
+    DIFF
+
+    ranges = TreeSitter::Git.ranges_from_diff(diff, SOURCE)
+
+    assert_equal([[12, 26], [42, 43]], ranges.map { |range| [range.start_byte, range.end_byte] })
+    assert_equal([1, 0], [ranges[0].start_point.row, ranges[0].start_point.column])
+    assert_equal([1, 14], [ranges[0].end_point.row, ranges[0].end_point.column])
+  end
+
+  def test_deleted_lines_become_empty_ranges
+    ranges = TreeSitter::Git.ranges_from_diff("@@ -3,2 +2,0 @@\n", SOURCE)
+
+    assert_equal(1, ranges.length)
+    assert_equal(0, ranges[0].size)
+    assert_equal(27, ranges[0].start_byte)
+  end
+
+  def test_changed_ranges_from_repository
+    Dir.mktmpdir do |dir|
+      File.write(File.join(dir, "main.rs"), SOURCE)
+      git(dir, "init", "--quiet")
+      git(dir, "add", "main.rs")
+      git(dir, "commit", "--quiet", "-m", "Initial")
+      File.write(File.join(dir, "main.rs"), SOURCE.sub("let b = 2;", "let b = 3;"))
+
+      ranges = TreeSitter::Git.changed_ranges(dir, base: "HEAD", path: "main.rs")
+
+      assert_equal([[27, 41]], ranges.map { |range| [range.start_byte, range.end_byte] })
+    end
+  end
+
+  def test_changed_ranges_raises_on_unknown_revision
+    Dir.mktmpdir do |dir|
+      git(dir, "init", "--quiet")
+      File.write(File.join(dir, "main.rs"), SOURCE)
+
+      assert_raises(TreeSitter::Error) do
+        TreeSitter::Git.changed_ranges(dir, base: "no-such-revision", path: "main.rs")
+      end
+    end
+  end
+
+  def test_changed_ranges_raises_for_a_deleted_file
+    Dir.mktmpdir do |dir|
+      File.write(File.join(dir, "main.rs"), SOURCE)
+      git(dir, "init", "--quiet")
+      git(dir, "add", "main.rs")
+      git(dir, "commit", "--quiet", "-m", "Initial")
+      File.delete(File.join(dir, "main.rs"))
+
+      error = assert_raises(TreeSitter::Error) do
+        TreeSitter::Git.changed_ranges(dir, base: "HEAD", path: "main.rs")
+      end
+      assert_match(/does not exist/, error.message)
+    end
+  end
+
+  def test_range_intersects
+    line = TreeSitter::Range.new(12, 26, TreeSitter::Point.new(1, 0), TreeSitter::Point.new(1, 14))
+    empty = TreeSitter::Range.new(26, 26, TreeSitter::Point.new(1, 14), TreeSitter::Point.new(1, 14))
+    after = TreeSitter::Range.new(27, 41, TreeSitter::Point.new(2, 0), TreeSitter::Point.new(2, 14))
+
+    assert(line.intersects?(empty))
+    refute(line.intersects?(after))
+    refute(after.intersects?(empty))
+  end
+
+  private
+
+  def git(dir, *args)
+    _output, errors, status = Open3.capture3(
+      "git", "-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", *args
+    )
+    raise "git #{args.first} failed: #{errors}" unless status.success?
+  end
+end
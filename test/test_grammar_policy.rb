@@ -0,0 +1,55 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "tmpdir"
+
+class TestGrammarPolicy < Minitest::Test
+  include TestHelper
+
+  def setup
+    @path = ENV.fetch("TREE_SITTER_RUST_PATH")
+    @digest = Digest::SHA256.file(@path).hexdigest
+  end
+
+  def teardown
+    TreeSitter.grammar_policy = nil
+  end
+
+  def test_refuses_libraries_outside_allowed_dirs
+    Dir.mktmpdir do |dir|
+      TreeSitter.grammar_policy = { allowed_dirs: [dir] }
+
+      error = assert_raises(TreeSitter::GrammarPolicyError) { TreeSitter.register_language("rust", @path) }
+      assert_match(/outside the allowed directories/, error.message)
+    end
+  end
+
+  def test_refuses_checksum_mismatch
+    TreeSitter.grammar_policy = { sha256: { "rust" => "0" * 64 } }
+
+    error = assert_raises(TreeSitter::GrammarPolicyError) { TreeSitter.register_language("rust", @path) }
+    assert_match(/Checksum mismatch/, error.message)
+  end
+
+  def test_require_checksum
+    TreeSitter.grammar_policy = { require_checksum: true }
+
+    assert_raises(TreeSitter::GrammarPolicyError) { TreeSitter.register_language("rust", @path) }
+  end
+
+  def test_registers_allowed_library_and_records_it
+    TreeSitter.grammar_policy = {
+      allowed_dirs: [File.dirname(@path)],
+      sha256: { rust: @digest.upcase },
+    }
+    TreeSitter.register_language("rust", @path)
+    language = TreeSitter.language("rust")
+
+    assert_equal(File.realpath(@path), language.library_path)
+    assert_equal(@digest, language.checksum)
+  end
+
+  def test_rejects_unknown_policy_keys
+    assert_raises(ArgumentError) { TreeSitter.grammar_policy = { allowed: ["/opt"] } }
+  end
+end
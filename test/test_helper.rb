@@ -21,6 +21,9 @@ module TestHelper
     "php" => "php",
     "java" => "java",
     "c_sharp" => "c_sharp",
+    "json" => "json",
+    "yaml" => "yaml",
+    "toml" => "toml",
   }.freeze
 
   class << self
@@ -0,0 +1,136 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "open3"
+require "tmpdir"
+
+class TestHistory < Minitest::Test
+  include TestHelper
+
+  VERSIONS = [
+    ["Add geometry", <<~RUST],
+      fn distance(a: f64, b: f64) -> f64 {
+          (a - b).abs()
+      }
+
+      fn other() {}
+    RUST
+    ["Move distance to the end", <<~RUST],
+      fn other() {}
+
+      fn distance(a: f64, b: f64) -> f64 {
+          (a - b).abs()
+      }
+    RUST
+    ["Double the distance", <<~RUST],
+      fn other() {}
+
+      fn distance(a: f64, b: f64) -> f64 {
+          (a - b).abs() * 2.0
+      }
+    RUST
+    ["Rename distance to dist", <<~RUST],
+      fn other() {}
+
+      fn dist(a: f64, b: f64) -> f64 {
+          (a - b).abs() * 2.0
+      }
+    RUST
+    ["Change other", <<~RUST],
+      fn other() {
+          println!("other");
+      }
+
+      fn dist(a: f64, b: f64) -> f64 {
+          (a - b).abs() * 2.0
+      }
+    RUST
+  ].freeze
+
+  def setup
+    register_language("rust")
+  end
+
+  def test_commits_that_changed_the_symbol
+    with_repository do |dir|
+      history = TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "dist")
+
+      assert_equal(
+        [
+          [:renamed, "Rename distance to dist"],
+          [:modified, "Double the distance"],
+          [:added, "Add geometry"],
+        ],
+        history.map { |revision| [revision.change, revision.commit.subject] },
+      )
+      assert_equal("distance", history.first.old_name)
+    end
+  end
+
+  def test_diff_of_just_the_symbol
+    with_repository do |dir|
+      modified = TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "dist")[1]
+
+      assert_includes(modified.diff, "-    (a - b).abs()\n")
+      assert_includes(modified.diff, "+    (a - b).abs() * 2.0\n")
+      refute_includes(modified.diff, "other")
+    end
+  end
+
+  def test_starting_revision
+    with_repository do |dir|
+      history = TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "distance", rev: "HEAD~2")
+
+      assert_equal([:modified, :added], history.map(&:change))
+    end
+  end
+
+  def test_copy_of_a_sibling_is_added_not_renamed
+    versions = [
+      ["Add alpha", "fn alpha(x: i32) -> i32 {\n    x * 2 + 1\n}\n"],
+      ["Copy alpha to beta", "fn alpha(x: i32) -> i32 {\n    x * 2 + 1\n}\n\nfn beta(x: i32) -> i32 {\n    x * 2 + 1\n}\n"],
+    ]
+    with_repository(versions) do |dir|
+      history = TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "beta")
+
+      assert_equal([[:added, "Copy alpha to beta"]], history.map { |revision| [revision.change, revision.commit.subject] })
+    end
+  end
+
+  def test_max_count_reports_the_oldest_commit_as_truncated
+    with_repository do |dir|
+      history = TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "dist", max_count: 2)
+
+      assert_equal([[:truncated, "Rename distance to dist"]], history.map { |revision| [revision.change, revision.commit.subject] })
+    end
+  end
+
+  def test_unknown_symbol
+    with_repository do |dir|
+      assert_raises(ArgumentError) do
+        TreeSitter::History.for_symbol(dir, "geometry.rs", symbol: "missing")
+      end
+    end
+  end
+
+  private
+
+  def with_repository(versions = VERSIONS)
+    Dir.mktmpdir do |dir|
+      git(dir, "init", "--quiet")
+      versions.each do |subject, source|
+        File.write(File.join(dir, "geometry.rs"), source)
+        git(dir, "add", "geometry.rs")
+        git(dir, "commit", "--quiet", "-m", subject)
+      end
+      yield dir
+    end
+  end
+
+  def git(dir, *args)
+    _output, errors, status = Open3.capture3(
+      "git", "-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", *args
+    )
+    raise "git #{args.first} failed: #{errors}" unless status.success?
+  end
+end
@@ -230,4 +230,46 @@ class TestInserter < Minitest::Test
     assert_includes(new_source, "fn test()")
     assert_includes(new_source, "fn other()")
   end
+
+  def test_multibyte_source
+    source = "fn main() {\n    let s = \"héllo wörld\";\n}\n"
+    tree = @parser.parse(source)
+    body = tree.root_node.child(0).child_by_field_name("body")
+
+    result = TreeSitter::Inserter.new(source, tree).at_end_of(body).insert_statement("done();").rewrite
+
+    assert_includes(result, "let s = \"héllo wörld\";")
+    assert_operator(result.index("wörld"), :<, result.index("done();"))
+    assert_operator(result.index("done();"), :<, result.rindex("}"))
+  end
+
+  def test_positions_as_insertion_points
+    source = "fn main() {\n    let a = 1;\n    let b = 2;\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::Inserter.new(source, tree, one_based: true)
+      .before(TreeSitter::Lines.new(3..3))
+      .insert_statement("check(a);")
+      .reset_position
+      .after([2, 15])
+      .insert_raw(" // one")
+      .rewrite
+
+    assert_equal("fn main() {\n    let a = 1; // one\n    check(a);\n    let b = 2;\n}\n", result)
+  end
+
+  def test_only_within
+    source = "fn first() {\n    a();\n}\n\nfn second() {\n    b();\n}\n"
+    tree = @parser.parse(source)
+    first, second = tree.root_node.named_children.map { |function| function.child_by_field_name("body") }
+    inserter = TreeSitter::Inserter.new(source, tree, only_within: [first.range])
+
+    result = inserter
+      .at_end_of(second).insert_statement("skipped();")
+      .at_end_of(first).insert_statement("kept();")
+      .rewrite
+
+    assert_includes(result, "kept();")
+    refute_includes(result, "skipped();")
+  end
 end
@@ -0,0 +1,37 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestLanguageDetector < Minitest::Test
+  def setup
+    @detector = TreeSitter::LanguageDetector.new
+  end
+
+  def test_detect_by_extension
+    assert_equal("rust", @detector.detect("src/main.rs"))
+    assert_equal("c_sharp", @detector.detect("Program.CS"))
+    assert_nil(@detector.detect("notes.txt"))
+  end
+
+  def test_detect_by_filename
+    assert_equal("ruby", @detector.detect("/app/Gemfile"))
+  end
+
+  def test_detect_by_shebang
+    assert_equal("ruby", @detector.detect("bin/tool", source: "#!/usr/bin/env ruby\nputs 1\n"))
+    assert_equal("python", @detector.detect("bin/tool", source: "#!/usr/bin/python3\n"))
+    assert_nil(@detector.detect("bin/tool", source: "#!/bin/sh\n"))
+  end
+
+  def test_custom_extensions
+    detector = TreeSitter::LanguageDetector.new(extensions: { ".TOML" => "toml" })
+
+    assert_equal("toml", detector.call("Cargo.toml"))
+  end
+
+  def test_registered_only
+    detector = TreeSitter::LanguageDetector.new(extensions: { ".zz" => "zz_unregistered" }, registered_only: true)
+
+    assert_nil(detector.detect("a.zz"))
+  end
+end
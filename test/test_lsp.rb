@@ -0,0 +1,104 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestLSP < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @source = <<~RUST
+      fn add(a: i32, b: i32) -> i32 {
+          a + b
+      }
+    RUST
+    @tree = @parser.parse(@source)
+    @name = @tree.root_node.child(0).child_by_field_name("name")
+  end
+
+  def test_positions_count_utf16_code_units
+    source = "let s = \"😀é\"; x\nnext\n"
+
+    assert_equal({ "line" => 0, "character" => 15 }, TreeSitter::LSP.position(source, 18))
+    assert_equal(18, TreeSitter::LSP.byte_offset(source, { "line" => 0, "character" => 15 }))
+    assert_equal({ "line" => 1, "character" => 2 }, TreeSitter::LSP.position(source, 22))
+    assert_equal(22, TreeSitter::LSP.byte_offset(source, { line: 1, character: 2 }))
+  end
+
+  def test_byte_offset_clamps_to_the_line
+    source = "ab\r\ncd"
+
+    assert_equal(2, TreeSitter::LSP.byte_offset(source, { "line" => 0, "character" => 99 }))
+    assert_equal(6, TreeSitter::LSP.byte_offset(source, { "line" => 5, "character" => 0 }))
+  end
+
+  def test_accepts_a_position_index
+    source = "let s = \"😀é\"; x\nnext\n"
+    index = TreeSitter::PositionIndex.new(source)
+    edits = [TreeSitter::Rewriter::Edit.new(start_byte: 18, end_byte: 22, replacement: "y")]
+
+    assert_same(index, TreeSitter::PositionIndex.for(index))
+    assert_equal({ "line" => 0, "character" => 15 }, TreeSitter::LSP.position(index, 18))
+    assert_equal(TreeSitter::LSP.text_edits(source, edits), TreeSitter::LSP.text_edits(index, edits))
+    assert_equal([[18, 22, "y"]], TreeSitter::LSP.byte_edits(index, TreeSitter::LSP.text_edits(index, edits)))
+  end
+
+  def test_rewriter_to_workspace_edit
+    edit = TreeSitter::Rewriter.new(@source, @tree)
+      .replace(@name, "sum")
+      .to_workspace_edit("file:///src/lib.rs")
+
+    assert_equal(
+      {
+        "changes" => {
+          "file:///src/lib.rs" => [
+            {
+              "range" => { "start" => { "line" => 0, "character" => 3 }, "end" => { "line" => 0, "character" => 6 } },
+              "newText" => "sum",
+            },
+          ],
+        },
+      },
+      edit,
+    )
+  end
+
+  def test_apply_lsp_edits
+    workspace_edit = {
+      "documentChanges" => [
+        { "kind" => "create", "uri" => "file:///src/new.rs" },
+        {
+          "textDocument" => { "uri" => "file:///src/lib.rs", "version" => 3 },
+          "edits" => [
+            { "range" => { "start" => { "line" => 1, "character" => 4 }, "end" => { "line" => 1, "character" => 9 } }, "newText" => "b + a" },
+          ],
+        },
+      ],
+    }
+    rewriter = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum")
+
+    result = rewriter.apply_lsp_edits(JSON.generate(workspace_edit), uri: "file:///src/lib.rs").rewrite
+
+    assert_includes(result, "fn sum(")
+    assert_includes(result, "    b + a\n")
+  end
+
+  def test_apply_lsp_edits_detects_conflicts
+    rewriter = TreeSitter::Rewriter.new(@source, @tree).replace(@name, "sum")
+    overlapping = [{ "range" => { "start" => { "line" => 0, "character" => 0 }, "end" => { "line" => 0, "character" => 5 } }, "newText" => "" }]
+
+    assert_raises(TreeSitter::ConflictError) { rewriter.apply_lsp_edits(overlapping) }
+    assert_equal(1, rewriter.edits.size)
+  end
+
+  def test_change_set_to_workspace_edit
+    changes = TreeSitter::ChangeSet.new.add("src/lib.rs", @source, [[3, 6, "sum"]])
+    edit = changes.to_workspace_edit(root: "/work")
+
+    assert_equal(["file:///work/src/lib.rs"], edit["changes"].keys)
+    assert_equal("sum", edit["changes"]["file:///work/src/lib.rs"].first["newText"])
+    assert_equal("file:///a%20b/c.rs", TreeSitter::LSP.file_uri("/a b/c.rs"))
+  end
+end
@@ -67,6 +67,23 @@ class TestNode < Minitest::Test
     assert_equal("add", fn_name.text)
   end
 
+  def test_field_name_for_child
+    fn_item = @root.child(0)
+
+    assert_nil(fn_item.field_name_for_child(0)) # "fn" keyword
+    assert_equal("name", fn_item.field_name_for_child(1))
+    assert_equal("parameters", fn_item.field_name_for_child(2))
+    assert_nil(fn_item.field_name_for_child(100))
+  end
+
+  def test_children_of_node_sharing_range_with_child
+    # Without a trailing newline, source_file and function_item span the same bytes
+    tree = @parser.parse("fn main() {}")
+
+    assert_equal(["function_item"], tree.root_node.children.map(&:kind))
+    assert_equal("source_file", tree.root_node.child(0).parent.kind)
+  end
+
   def test_node_text
     fn_item = @root.child(0)
     fn_name = fn_item.child_by_field_name("name")
@@ -151,6 +168,13 @@ class TestNode < Minitest::Test
     assert_includes(inspect_str, "end_byte=6")
   end
 
+  def test_range_to_s
+    fn_item = @root.child(0)
+    fn_name = fn_item.child_by_field_name("name")
+
+    assert_equal("[0, 3] - [0, 6]", fn_name.range.to_s)
+  end
+
   def test_point_new
     point = TreeSitter::Point.new(5, 10)
 
@@ -310,6 +334,50 @@ class TestNode < Minitest::Test
     refute_predicate(@root, :has_changes?)
   end
 
+  def test_dump
+    root = @parser.parse("fn main() {}").root_node
+
+    assert_equal(<<~DUMP.chomp, root.dump)
+      (source_file [0, 0] - [0, 12]
+        (function_item [0, 0] - [0, 12]
+          ("fn" [0, 0] - [0, 2])
+          name: (identifier [0, 3] - [0, 7] "main")
+          parameters: (parameters [0, 7] - [0, 9]
+            ("(" [0, 7] - [0, 8])
+            (")" [0, 8] - [0, 9]))
+          body: (block [0, 10] - [0, 12]
+            ("{" [0, 10] - [0, 11])
+            ("}" [0, 11] - [0, 12]))))
+    DUMP
+  end
+
+  def test_dump_named_only
+    root = @parser.parse("fn main() {}").root_node
+
+    assert_equal(<<~DUMP.chomp, root.dump(anonymous: false, ranges: false, text: false, indent: 4))
+      (source_file
+          (function_item
+              name: (identifier)
+              parameters: (parameters)
+              body: (block)))
+    DUMP
+  end
+
+  def test_dump_max_depth
+    root = @parser.parse("fn main() {}").root_node
+
+    assert_equal("(source_file [0, 0] - [0, 12]\n  (function_item [0, 0] - [0, 12] ...))", root.dump(max_depth: 1))
+    assert_equal("(source_file ...)", root.dump(ranges: false, max_depth: 0))
+  end
+
+  def test_dump_without_fields
+    name = @parser.parse("fn main() {}").root_node.child(0).child_by_field_name("name")
+
+    assert_equal('(identifier [0, 3] - [0, 7] "main")', name.dump)
+    assert_equal('(identifier "main")', name.dump(fields: false, ranges: false, text: :all))
+    assert_raises(ArgumentError) { name.dump(text: :nodes) }
+  end
+
   private
 
   def find_error_node(node)
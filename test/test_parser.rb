@@ -107,6 +107,87 @@ class TestParser < Minitest::Test
     refute_nil(tree)
   end
 
+  def test_incremental_parse_after_edit
+    old_source = "fn main() {}\nfn other() {}\n"
+    new_source = "fn main() { run(); }\nfn other() {}\n"
+    tree = @parser.parse(old_source)
+
+    tree.edit(TreeSitter::InputEdit.new(
+      11, 11, 19,
+      TreeSitter::Point.new(0, 11), TreeSitter::Point.new(0, 11), TreeSitter::Point.new(0, 19),
+    ))
+    new_tree = @parser.parse(new_source, tree)
+
+    assert_equal(new_source, new_tree.source)
+    refute_predicate(new_tree.root_node, :has_error?)
+
+    changed = tree.changed_ranges(new_tree)
+
+    refute_empty(changed)
+    assert(changed.all? { |range| range.start_byte >= 10 && range.end_byte <= 21 })
+  end
+
+  def test_input_edit_accessors
+    edit = TreeSitter::InputEdit.new(
+      1, 2, 3,
+      TreeSitter::Point.new(0, 1), TreeSitter::Point.new(0, 2), TreeSitter::Point.new(1, 0),
+    )
+
+    assert_equal([1, 2, 3], [edit.start_byte, edit.old_end_byte, edit.new_end_byte])
+    assert_equal(TreeSitter::Point.new(1, 0), edit.new_end_point)
+  end
+
+  def test_edit_keeps_earlier_nodes_valid
+    tree = @parser.parse("fn main() {}")
+    fn_item = tree.root_node.child(0)
+
+    tree.edit(TreeSitter::InputEdit.new(
+      0, 0, 1,
+      TreeSitter::Point.new(0, 0), TreeSitter::Point.new(0, 0), TreeSitter::Point.new(0, 1),
+    ))
+
+    assert_equal(0, fn_item.start_byte)
+    assert_equal("main", fn_item.child_by_field_name("name").text)
+  end
+
+  def test_reparse_from_new_source
+    old_source = "fn a() {}\n\nfn b() {}\n\nfn c() {}\n"
+    new_source = "fn a() { x(); }\n\nfn b() {}\n\nfn cc() {}\n"
+    old_tree = @parser.parse(old_source)
+
+    new_tree, changed = @parser.reparse(old_tree, new_source)
+
+    assert_equal(new_source, new_tree.source)
+    assert_equal(old_source, old_tree.source)
+    refute_predicate(new_tree.root_node, :has_error?)
+    assert_equal(new_source.length, new_tree.root_node.end_byte)
+
+    # Both edited functions are reported, the untouched middle one is not
+    a_item, b_item, c_item = new_tree.root_node.named_children
+    overlaps = ->(node) { changed.any? { |r| r.start_byte < node.end_byte && r.end_byte > node.start_byte } }
+
+    assert(overlaps.call(a_item))
+    refute(overlaps.call(b_item))
+    assert(overlaps.call(c_item))
+  end
+
+  def test_reparse_leaves_old_tree_unedited
+    old_tree = @parser.parse("fn main() {}")
+    @parser.reparse(old_tree, "fn main() { go(); }")
+
+    assert_equal(["function_item"], old_tree.root_node.children.map(&:kind))
+    assert_equal(12, old_tree.root_node.end_byte)
+  end
+
+  def test_reparse_identical_source
+    old_tree = @parser.parse("fn main() {}")
+
+    new_tree, changed = @parser.reparse(old_tree, "fn main() {}")
+
+    assert_equal("fn main() {}", new_tree.source)
+    assert_empty(changed)
+  end
+
   def test_reset
     @parser.reset
     # Should not raise
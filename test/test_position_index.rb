@@ -0,0 +1,57 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestPositionIndex < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @source = "fn main() {\n    let s = \"😀é\"; run();\n}\n"
+    @tree = @parser.parse(@source)
+    @index = TreeSitter::PositionIndex.new(@source)
+  end
+
+  def test_column_units
+    # `run` starts after the emoji (4 bytes, 2 UTF-16 units) and é (2 bytes)
+    run = @source.byteindex("run")
+
+    assert_equal([1, 22], @index.position(run))
+    assert_equal([1, 18], @index.position(run, unit: :char))
+    assert_equal([1, 19], @index.position(run, unit: :utf16))
+    assert_equal([2, 20], @index.position(run, unit: :utf16, one_based: true))
+    assert_equal(run, @index.byte_offset(1, 18, unit: :char))
+    assert_equal(run, @index.byte_offset(2, 20, unit: :utf16, one_based: true))
+    assert_raises(ArgumentError) { @index.byte_offset(0, 0, unit: :column) }
+  end
+
+  def test_clamps_positions
+    assert_equal(11, @index.byte_offset(0, 99))
+    assert_equal(@source.bytesize, @index.byte_offset(10, 0))
+  end
+
+  def test_resolve_targets
+    name = @tree.root_node.child(0).child_by_field_name("name")
+
+    assert_equal([3, 7], @index.resolve([[0, 3], [0, 7]]).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal([3, 3], @index.resolve([0, 3]).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal([3, 7], @index.resolve(3...7).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal([3, 7], @index.resolve(3..6).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal([3, 7], @index.resolve(name.start_point...TreeSitter::Point.new(0, 7)).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal([12, @source.bytesize], @index.resolve(TreeSitter::Lines.new(1..)).then { |r| [r.start_byte, r.end_byte] })
+    assert_equal(name.range, @index.resolve(name))
+    assert_raises(ArgumentError) { @index.resolve("main") }
+  end
+
+  def test_rewriter_accepts_positions
+    result = TreeSitter::Rewriter.new(@source, @tree, column_unit: :utf16, one_based: true)
+      .replace([[2, 20], [2, 23]], "stop")
+      .insert_before([1, 1], "// entry\n")
+      .remove(TreeSitter::Lines.new(3..3))
+      .rewrite
+
+    assert_equal("// entry\nfn main() {\n    let s = \"😀é\"; stop();\n", result)
+  end
+end
@@ -87,6 +87,37 @@ class TestQuery < Minitest::Test
     assert_kind_of(TreeSitter::Node, capture.node)
   end
 
+  def test_query_source_and_pattern_offsets
+    source = "(function_item) @fn\n(struct_item) @struct"
+    query = TreeSitter::Query.new(@lang, source)
+
+    assert_equal(source, query.source)
+    assert_equal(0, query.start_byte_for_pattern(0))
+    assert_equal(20, query.start_byte_for_pattern(1))
+    assert_raises(IndexError) { query.start_byte_for_pattern(2) }
+  end
+
+  def test_disable_pattern
+    query = TreeSitter::Query.new(@lang, "(function_item) @fn\n(identifier) @id")
+    query.disable_pattern(1)
+    tree = @parser.parse("fn main() {}")
+
+    assert_equal([0], TreeSitter::QueryCursor.new.matches(query, tree.root_node, tree.source).map(&:pattern_index))
+    assert_raises(IndexError) { query.disable_pattern(2) }
+  end
+
+  def test_cursor_match_limit
+    cursor = TreeSitter::QueryCursor.new
+    cursor.match_limit = 64
+
+    assert_equal(64, cursor.match_limit)
+
+    query = TreeSitter::Query.new(@lang, "(function_item) @fn")
+    cursor.matches(query, @tree.root_node, @source)
+
+    refute_predicate(cursor, :did_exceed_match_limit?)
+  end
+
   def test_find_all_struct_fields
     query = TreeSitter::Query.new(@lang, "(field_declaration name: (field_identifier) @field_name)")
     cursor = TreeSitter::QueryCursor.new
@@ -97,4 +128,17 @@ class TestQuery < Minitest::Test
     assert_includes(field_names, "x")
     assert_includes(field_names, "y")
   end
+
+  def test_cursor_byte_range
+    query = TreeSitter::Query.new(@lang, "(function_item name: (identifier) @fn_name)")
+    all_names = TreeSitter::QueryCursor.new.matches(query, @tree.root_node, @source)
+    last = all_names.last.captures.first.node
+    cursor = TreeSitter::QueryCursor.new
+    cursor.set_byte_range(last.start_byte, last.end_byte)
+
+    names = cursor.matches(query, @tree.root_node, @source).map { |m| m.captures.first.node.text }
+
+    assert_equal([last.text], names)
+    assert_raises(ArgumentError) { cursor.set_byte_range(5, 1) }
+  end
 end
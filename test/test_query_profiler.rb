@@ -0,0 +1,118 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestQueryProfiler < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @trees = [
+      @parser.parse(fixture_content("sample.rs")),
+      @parser.parse("fn one() {}\nfn two() { one(); }\n"),
+    ]
+    @query = <<~QUERY
+      ; functions
+      (function_item name: (identifier) @name)
+
+      (call_expression function: (identifier) @callee)
+
+      (union_item) @union
+    QUERY
+  end
+
+  def test_reports_one_entry_per_pattern
+    report = TreeSitter::QueryProfiler.run(@query, @trees)
+
+    assert_equal(3, report.patterns.length)
+    assert_equal([0, 1, 2], report.patterns.map(&:index))
+    assert_equal(2, report.tree_count)
+    assert_equal("; functions\n(function_item name: (identifier) @name)", report.patterns[0].source)
+    assert_equal("(union_item) @union", report.patterns[2].source)
+  end
+
+  def test_counts_matches_across_trees
+    report = TreeSitter::QueryProfiler.run(@query, @trees)
+    query = TreeSitter::Query.new(TreeSitter.language("rust"), "(function_item name: (identifier) @name)")
+    expected = @trees.sum do |tree|
+      TreeSitter::QueryCursor.new.matches(query, tree.root_node, tree.source).length
+    end
+
+    assert_equal(expected, report.patterns[0].match_count)
+    assert_equal(expected, report.patterns[0].capture_count)
+    assert_operator(report.patterns[1].match_count, :>=, 1)
+    assert_equal(report.patterns.sum(&:match_count), report.total_matches)
+  end
+
+  def test_never_matched
+    report = TreeSitter::QueryProfiler.run(@query, @trees)
+
+    assert_equal([2], report.never_matched.map(&:index))
+    assert_predicate(report.patterns[2], :never_matched?)
+  end
+
+  def test_timing
+    report = TreeSitter::QueryProfiler.run(@query, @trees)
+
+    assert(report.patterns.all? { |stats| stats.time >= 0 })
+    assert_in_delta(report.patterns.sum(&:time), report.total_time)
+    assert_equal(2, report.slowest(2).length)
+  end
+
+  def test_no_match_limit_hits_by_default
+    report = TreeSitter::QueryProfiler.run(@query, @trees)
+
+    assert_empty(report.hit_match_limit)
+  end
+
+  def test_reports_patterns_that_hit_the_match_limit
+    tree = @parser.parse("fn main() { f(a, b, c, d, e); }\n")
+    query = "(arguments (identifier) @first (identifier) @second)\n(function_item) @fn\n"
+
+    report = TreeSitter::QueryProfiler.run(query, [tree], match_limit: 1)
+
+    assert_equal([0], report.hit_match_limit.map(&:index))
+    assert_includes(report.to_s, "[match limit]")
+  end
+
+  def test_pattern_sources_keep_the_comments_above_them
+    query = "(function_item) @fn\n; about functions\n\n; Unions are rare\n; in this corpus\n(union_item) @union\n"
+
+    report = TreeSitter::QueryProfiler.run(query, @trees)
+
+    assert_equal(
+      ["(function_item) @fn\n; about functions", "; Unions are rare\n; in this corpus\n(union_item) @union"],
+      report.patterns.map(&:source),
+    )
+    assert_includes(report.to_s, "(union_item) @union  [never matched]")
+  end
+
+  def test_reuses_compiled_pattern_queries
+    language = TreeSitter.language("rust")
+    first = TreeSitter::QueryProfiler.send(:pattern_query, language, @query, 3, 1)
+
+    assert_same(first, TreeSitter::QueryProfiler.send(:pattern_query, language, @query, 3, 1))
+    refute_same(first, TreeSitter::QueryProfiler.send(:pattern_query, language, "#{@query}\n", 3, 1))
+  end
+
+  def test_accepts_compiled_query
+    query = TreeSitter::Query.new(TreeSitter.language("rust"), @query)
+
+    report = TreeSitter::QueryProfiler.run(query, @trees, match_limit: 256)
+
+    assert_equal(3, report.patterns.length)
+  end
+
+  def test_to_s_flags_dead_patterns
+    output = TreeSitter::QueryProfiler.run(@query, @trees).to_s
+
+    assert_includes(output, "(union_item) @union  [never matched]")
+    assert_includes(output, "3 patterns, 2 trees")
+  end
+
+  def test_requires_language_without_trees
+    assert_raises(ArgumentError) { TreeSitter::QueryProfiler.run(@query, []) }
+  end
+end
@@ -207,4 +207,200 @@ class TestQueryRewriter < Minitest::Test
 
     assert_includes(result, "<test>")
   end
+
+  def test_only_within_limits_edits_to_ranges
+    source = <<~RUST
+      fn main() {
+          old_func();
+          old_func();
+      }
+    RUST
+    tree = @parser.parse(source)
+    third_line = TreeSitter::Git.ranges_from_diff("@@ -3 +3 @@\n", source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang, only_within: third_line)
+      .query("(call_expression function: (identifier) @fn_name)")
+      .replace("@fn_name") { "new_func" }
+      .rewrite
+
+    assert_equal("fn main() {\n    old_func();\n    new_func();\n}\n", result)
+  end
+
+  def test_chained_queries_keep_their_own_operations
+    source = <<~RUST
+      // helper
+      fn helper() {}
+    RUST
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(line_comment) @comment")
+      .remove("@comment")
+      .query("(function_item name: (identifier) @name)")
+      .replace("@name") { "util" }
+      .rewrite
+
+    assert_equal("\nfn util() {}\n", result)
+  end
+
+  def test_on_pattern_by_index
+    source = "// line\n/* block */\nfn main() {}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(line_comment) @comment\n(block_comment) @comment")
+      .on_pattern(1) { |rewriter| rewriter.remove("@comment") }
+      .rewrite
+
+    assert_equal("// line\n\nfn main() {}\n", result)
+  end
+
+  def test_on_pattern_needs_a_block
+    source = "// line\nfn main() {}\n"
+    rewriter = TreeSitter::QueryRewriter.new(source, @parser.parse(source), @lang).query("(line_comment) @comment")
+
+    error = assert_raises(ArgumentError) { rewriter.on_pattern(0) }
+    assert_match(/needs a block/, error.message)
+  end
+
+  def test_on_pattern_by_name
+    source = <<~RUST
+      fn main() {
+          let a = compute().unwrap();
+          dbg!(a);
+      }
+    RUST
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query(
+        unwrap: '(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @method
+                   (#eq? @method "unwrap"))) @call',
+        dbg: "(expression_statement (macro_invocation macro: (identifier) @macro (#eq? @macro \"dbg\"))) @statement",
+      )
+      .on_pattern(:unwrap) { |rewriter| rewriter.replace("@call", template: "@recv.expect(\"TODO\")") }
+      .on_pattern(:dbg) { |rewriter| rewriter.remove("@statement") }
+      .rewrite
+
+    assert_includes(result, "let a = compute().expect(\"TODO\");")
+    refute_includes(result, "dbg!")
+    assert_raises(ArgumentError) do
+      TreeSitter::QueryRewriter.new(source, tree, @lang)
+        .query(unwrap: "(identifier) @id")
+        .on_pattern(:missing) { |rewriter| rewriter.remove("@id") }
+        .rewrite
+    end
+  end
+
+  def test_replace_match_spans_all_captures
+    source = "fn main() {\n    let total = 1 + 2;\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(let_declaration pattern: (identifier) @name value: (_) @value)")
+      .replace_match { |match| "#{match.captures.first.node.text}: i32 = 3" }
+      .rewrite
+
+    assert_equal("fn main() {\n    let total: i32 = 3;\n}\n", result)
+  end
+
+  def test_replace_with_template
+    source = "fn main() {\n    let a = value.unwrap();\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query('(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @m) (#eq? @m "unwrap")) @call')
+      .replace("@call", template: "@recv.try_into()?")
+      .rewrite
+
+    assert_equal("fn main() {\n    let a = value.try_into()?;\n}\n", result)
+  end
+
+  def test_template_filters
+    source = "fn make_thing() {}\n"
+    tree = @parser.parse(source)
+    rewriter = TreeSitter::QueryRewriter.new(source, tree, @lang).query("(function_item name: (identifier) @name)")
+
+    assert_equal("fn MakeThing() {}\n", rewriter.replace("@name", template: "{{@name | pascal_case}}").rewrite)
+    assert_raises(ArgumentError) do
+      TreeSitter::QueryRewriter.new(source, tree, @lang)
+        .query("(function_item name: (identifier) @name)")
+        .replace("@name", template: "{{@name | shout}}")
+        .rewrite
+    end
+  end
+
+  def test_template_quantified_capture_keeps_separators
+    source = "fn main() {\n    add(1,  2);\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(call_expression function: (identifier) @fn arguments: (arguments (_)* @args)) @call")
+      .replace("@call", template: "sum(@args)")
+      .rewrite
+
+    assert_equal("fn main() {\n    sum(1,  2);\n}\n", result)
+  end
+
+  def test_template_reindents_multiline_captures
+    source = <<~RUST
+      fn main() {
+          if ready {
+              go();
+          }
+      }
+    RUST
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(if_expression condition: (_) @cond consequence: (block) @body) @if")
+      .replace("@if", template: "{\n    let _guard = lock();\n    if @cond @body\n}")
+      .rewrite
+
+    assert_equal(<<~RUST, result)
+      fn main() {
+          {
+              let _guard = lock();
+              if ready {
+                  go();
+              }
+          }
+      }
+    RUST
+  end
+
+  def test_within_node
+    source = <<~RUST
+      fn first() {
+          let x = 1;
+          x + 1
+      }
+
+      fn second() {
+          let x = 2;
+          x
+      }
+    RUST
+    tree = @parser.parse(source)
+    second = tree.root_node.named_children.last
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang, within: second)
+      .query('((identifier) @id (#eq? @id "x"))')
+      .replace("@id") { "y" }
+      .rewrite
+
+    assert_includes(result, "let x = 1;\n    x + 1")
+    assert_includes(result, "let y = 2;\n    y\n")
+  end
+
+  def test_multibyte_source
+    source = "fn main() {\n    let s = \"héllo wörld\";\n    let n = 1;\n}\n"
+    tree = @parser.parse(source)
+    rewriter = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(integer_literal) @n")
+      .replace("@n") { "2" }
+
+    assert_equal(["1"], rewriter.preview_edits.map { |edit| edit[:original] })
+    assert_equal("fn main() {\n    let s = \"héllo wörld\";\n    let n = 2;\n}\n", rewriter.rewrite)
+  end
 end
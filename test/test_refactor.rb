@@ -210,4 +210,43 @@ class TestRefactor < Minitest::Test
     # new_name should replace old_name occurrences
     assert_equal(2, result.scan("new_name()").count)
   end
+
+  def test_rename_within_a_function
+    source = <<~RUST
+      fn first(x: i32) -> i32 {
+          x
+      }
+
+      fn second(x: i32) -> i32 {
+          x * 2
+      }
+    RUST
+    tree = @parser.parse(source)
+    first = tree.root_node.named_children.first
+
+    result = TreeSitter::Refactor.rename_symbol(source, tree, @lang, from: "x", to: "value", within: first)
+
+    assert_includes(result, "fn first(value: i32) -> i32 {\n    value\n}")
+    assert_includes(result, "fn second(x: i32) -> i32 {\n    x * 2\n}")
+  end
+
+  def test_inline_variable_within_a_scope
+    source = <<~RUST
+      fn a() {
+          let n = 1;
+          n
+      }
+
+      fn b(n: i32) -> i32 {
+          n
+      }
+    RUST
+    tree = @parser.parse(source)
+    scope = tree.root_node.named_children.first.range
+
+    result = TreeSitter::Refactor.inline_variable(source, tree, @lang, name: "n", within: [scope])
+
+    assert_includes(result, "    1\n}")
+    assert_includes(result, "fn b(n: i32) -> i32 {\n    n\n}")
+  end
 end
@@ -0,0 +1,147 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestReflow < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+  end
+
+  def test_breaks_long_argument_lists
+    source = <<~RUST
+      fn main() {
+          let result = compute(first_argument, second_argument, third_argument);
+      }
+    RUST
+    result = TreeSitter::Reflow.fit(@parser.parse(source), max_width: 40)
+
+    assert_equal(<<~RUST, result)
+      fn main() {
+          let result = compute(
+              first_argument,
+              second_argument,
+              third_argument,
+          );
+      }
+    RUST
+    assert_equal(result, TreeSitter::Reflow.fit(@parser.parse(result), max_width: 40))
+  end
+
+  def test_joins_short_lists
+    source = <<~RUST
+      fn main() {
+          let v = vec_of(
+              1,
+              2,
+          );
+          let t = (1,);
+      }
+    RUST
+
+    assert_equal(<<~RUST, TreeSitter::Reflow.fit(@parser.parse(source)))
+      fn main() {
+          let v = vec_of(1, 2);
+          let t = (1,);
+      }
+    RUST
+  end
+
+  def test_lays_out_nested_lists_from_their_new_position
+    source = <<~RUST
+      fn main() {
+          let p = build(Point { x: 1, y: 2 }, [first_value, second_value, third_value]);
+      }
+    RUST
+
+    assert_equal(<<~RUST, TreeSitter::Reflow.fit(@parser.parse(source), max_width: 45))
+      fn main() {
+          let p = build(
+              Point { x: 1, y: 2 },
+              [
+                  first_value,
+                  second_value,
+                  third_value,
+              ],
+          );
+      }
+    RUST
+  end
+
+  def test_no_comma_after_base_struct
+    source = <<~RUST
+      fn main() {
+          let config = Config { name: String::from("server"), port: 8080, ..Default::default() };
+      }
+    RUST
+
+    assert_equal(<<~RUST, TreeSitter::Reflow.fit(@parser.parse(source), max_width: 40))
+      fn main() {
+          let config = Config {
+              name: String::from("server"),
+              port: 8080,
+              ..Default::default()
+          };
+      }
+    RUST
+  end
+
+  def test_ruby_parameters_and_block_arguments_take_no_trailing_comma
+    register_language("ruby")
+    source = "def configure(name, options, &block)\n  build(name, options, &block)\nend\n"
+
+    assert_equal(<<~RUBY, TreeSitter::Reflow.fit(parse("ruby", source), max_width: 20))
+      def configure(
+        name,
+        options,
+        &block
+      )
+        build(
+          name,
+          options,
+          &block
+        )
+      end
+    RUBY
+  end
+
+  def test_javascript_rest_parameter_takes_no_trailing_comma
+    register_language("javascript")
+    source = "function f(first, second, ...rest) {}\n"
+
+    assert_equal(<<~JS, TreeSitter::Reflow.fit(parse("javascript", source), max_width: 20))
+      function f(
+          first,
+          second,
+          ...rest
+      ) {}
+    JS
+  end
+
+  def test_go_gets_the_required_trailing_comma
+    register_language("go")
+    source = "package main\n\nfunc main() {\n\tcall(first, second)\n}\n"
+
+    assert_equal(
+      "package main\n\nfunc main() {\n\tcall(\n\t\tfirst,\n\t\tsecond,\n\t)\n}\n",
+      TreeSitter::Reflow.fit(parse("go", source), max_width: 16),
+    )
+  end
+
+  def test_keeps_lists_with_comments
+    source = "fn main() {\n    f(\n        a, // first\n        b,\n    );\n}\n"
+
+    assert_empty(TreeSitter::Reflow.edits(@parser.parse(source)))
+  end
+
+  private
+
+  def parse(language, source)
+    parser = TreeSitter::Parser.new
+    parser.language = language
+    parser.parse(source)
+  end
+end
@@ -0,0 +1,120 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "stringio"
+require "tmpdir"
+require "tree_sitter/cli"
+
+class TestRepl < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @source = <<~RUST
+      fn add(a: i32, b: i32) -> i32 {
+          a + b
+      }
+    RUST
+    @tree = @parser.parse(@source)
+  end
+
+  def run_session(*lines)
+    output = StringIO.new
+    repl = TreeSitter::Repl.new(@source, @tree, input: StringIO.new(lines.join("\n") + "\n"), output: output, color: false)
+    repl.run
+    [repl, output.string]
+  end
+
+  def test_shows_tree_with_fields_and_ranges
+    _repl, output = run_session("quit")
+
+    assert_includes(output, "(source_file [0, 0] - [3, 0])")
+    assert_includes(output, "name: (identifier [0, 3] - [0, 6]) \"add\"")
+  end
+
+  def test_query_lists_matches_with_excerpts
+    _repl, output = run_session("(function_item name: (identifier) @name)", "quit")
+
+    assert_includes(output, "1 match")
+    assert_includes(output, "@name identifier [0, 3] - [0, 6]")
+    assert_includes(output, "fn add(a: i32, b: i32) -> i32 {")
+  end
+
+  def test_multiline_query
+    _repl, output = run_session("(binary_expression", "  left: (identifier) @left)", "quit")
+
+    assert_includes(output, "@left identifier [1, 4] - [1, 5]")
+  end
+
+  def test_highlights_captures_when_color_enabled
+    output = StringIO.new
+    repl = TreeSitter::Repl.new(@source, @tree, input: StringIO.new, output: output, color: true)
+    repl.run_query("(identifier) @id")
+
+    assert_includes(output.string, "fn #{TreeSitter::Repl::HIGHLIGHT_START}add#{TreeSitter::Repl::HIGHLIGHT_END}(")
+  end
+
+  def test_invalid_query_reports_error
+    _repl, output = run_session("(not_a_node) @x", "quit")
+
+    assert_includes(output, "Query syntax error")
+  end
+
+  def test_navigation
+    repl, output = run_session("cd child 0", "cd field name", "up", "fields")
+
+    assert_equal("function_item", repl.current.kind)
+    assert_includes(output, "1: name: (identifier [0, 3] - [0, 6]) \"add\"")
+    assert_includes(output, "2: parameters: (parameters")
+  end
+
+  def test_cd_to_a_missing_child
+    repl, output = run_session("cd foo", "cd -1", "cd named x", "cd 9", "cd named 9")
+
+    assert_equal("source_file", repl.current.kind)
+    ["foo", "-1", "named x", "9", "named 9"].each do |args|
+      assert_includes(output, "No such child: #{args}")
+    end
+  end
+
+  def test_up_at_root
+    _repl, output = run_session("up")
+
+    assert_includes(output, "Already at the root")
+  end
+
+  def test_save_query
+    Dir.mktmpdir do |dir|
+      path = File.join(dir, "functions")
+      repl, output = run_session("(function_item) @fn", "save #{path}")
+
+      assert_equal("(function_item) @fn", repl.last_query)
+      assert_includes(output, "Saved query to #{path}.scm")
+      assert_equal("(function_item) @fn\n", File.read("#{path}.scm"))
+    end
+  end
+
+  def test_cli_repl_command
+    Dir.mktmpdir do |dir|
+      path = File.join(dir, "sample.rs")
+      File.write(path, @source)
+      output = StringIO.new
+
+      status = TreeSitter::CLI.start(["repl", path], input: StringIO.new("quit\n"), output: output)
+
+      assert_equal(0, status)
+      assert_includes(output.string, "(function_item [0, 0] - [2, 1])")
+    end
+  end
+
+  def test_cli_unknown_extension
+    error = StringIO.new
+
+    status = TreeSitter::CLI.start(["repl", "notes.txt"], error: error)
+
+    assert_equal(1, status)
+    assert_includes(error.string, "Cannot tell the language")
+  end
+end
@@ -159,4 +159,52 @@ class TestRewriter < Minitest::Test
 
     assert_equal(2, rewriter.edits.length)
   end
+
+  def test_only_within_skips_nodes_outside_ranges
+    fn_item = @tree.root_node.child(0)
+    fn_name = fn_item.child_by_field_name("name")
+    body_line = TreeSitter::Range.new(32, 41, TreeSitter::Point.new(1, 0), TreeSitter::Point.new(1, 9))
+    body = fn_item.child_by_field_name("body").named_child(0)
+
+    result = TreeSitter::Rewriter.new(@source, @tree, only_within: [body_line])
+      .replace(fn_name, "sum")
+      .replace(body, "b + a")
+      .rewrite
+
+    assert_includes(result, "fn add(")
+    assert_includes(result, "    b + a\n")
+  end
+
+  def test_replace_with_reindent
+    body = @tree.root_node.child(0).child_by_field_name("body").named_children.first
+
+    result = TreeSitter::Rewriter.new(@source, @tree)
+      .replace(body, "match a {\n    0 => b,\n    _ => a + b,\n}", reindent: true)
+      .rewrite
+
+    assert_equal(<<~RUST, result)
+      fn add(a: i32, b: i32) -> i32 {
+          match a {
+              0 => b,
+              _ => a + b,
+          }
+      }
+    RUST
+  end
+
+  def test_reindent_leaves_multiline_strings_alone
+    body = @tree.root_node.child(0).child_by_field_name("body").named_children.first
+
+    result = TreeSitter::Rewriter.new(@source, @tree)
+      .replace(body, "const S: &str = \"x\n  y\";\na + b", reindent: true)
+      .rewrite
+
+    assert_equal(<<~RUST, result)
+      fn add(a: i32, b: i32) -> i32 {
+          const S: &str = "x
+        y";
+          a + b
+      }
+    RUST
+  end
 end
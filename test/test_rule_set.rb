@@ -0,0 +1,74 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestRuleSet < Minitest::Test
+  include TestHelper
+
+  UNWRAP = '(call_expression function: (field_expression value: (_) @recv field: (field_identifier) @m) (#eq? @m "unwrap")) @call'
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @lang = TreeSitter.language("rust")
+  end
+
+  def test_rewrite_until_stable_handles_nested_matches
+    source = "fn main() {\n    let a = value.unwrap().unwrap();\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang, parser: @parser)
+      .query(UNWRAP)
+      .replace("@call", template: "@recv?")
+      .rewrite_until_stable
+
+    assert_predicate(result, :stable?)
+    assert_equal("fn main() {\n    let a = value??;\n}\n", result.source)
+    assert_equal(2, result.pass_count)
+    assert_equal(["value.unwrap()?"], result.passes.first.edits.map { |edit| edit[:replacement] })
+    assert_equal(result.source, result.tree.root_node.text)
+    refute(result.tree.root_node.has_error?)
+  end
+
+  def test_rules_run_in_turn
+    source = "fn main() {\n    let a = compute().unwrap();\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::RuleSet.new("rust")
+      .rule(:try) { |r| r.query(UNWRAP).replace("@call", template: "@recv?") }
+      .rule(:rename) { |r| r.query('((identifier) @id (#eq? @id "compute"))').replace("@id") { "calculate" } }
+      .run(source, tree)
+
+    assert_equal("fn main() {\n    let a = calculate()?;\n}\n", result.source)
+    assert_equal(["try", "rename"], result.passes.first.changes.map(&:rule))
+  end
+
+  def test_detects_oscillation
+    source = "fn main() {\n    a;\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::RuleSet.new(@lang, parser: @parser)
+      .rule { |r| r.query('((identifier) @id (#eq? @id "a"))').replace("@id") { "b" } }
+      .rule { |r| r.query('((identifier) @id (#eq? @id "b"))').replace("@id") { "a" } }
+      .run(source, tree)
+
+    assert_equal(:oscillating, result.status)
+    assert_equal(1, result.pass_count)
+    assert_equal(source, result.source)
+  end
+
+  def test_stops_after_max_passes
+    source = "fn main() {\n    1;\n}\n"
+    tree = @parser.parse(source)
+
+    result = TreeSitter::QueryRewriter.new(source, tree, @lang)
+      .query("(integer_literal) @n")
+      .replace("@n", template: "(@n)")
+      .rewrite_until_stable(max_passes: 3)
+
+    assert_equal(:max_passes, result.status)
+    assert_equal(3, result.pass_count)
+    assert_includes(result.source, "(((1)));")
+  end
+end
@@ -0,0 +1,186 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "stringio"
+require "tmpdir"
+require "tree_sitter/cli"
+
+class TestStructuralDiff < Minitest::Test
+  include TestHelper
+
+  OLD_SOURCE = <<~RUST
+    fn main() {
+        let total = a + b;
+    }
+  RUST
+
+  NEW_SOURCE = <<~RUST
+    fn main() {
+        let total = a - b;
+    }
+  RUST
+
+  def setup
+    register_language("rust")
+  end
+
+  def test_reformatting_is_not_a_change
+    old_source = "fn add(x: i32) -> i32 { x + 1 }\n"
+    new_source = "fn add(x: i32) -> i32 {\n    x + 1\n}\n"
+
+    diff = TreeSitter::StructuralDiff.new(old_source, new_source, language: "rust")
+
+    refute_predicate(diff, :changed?)
+    assert_equal("", diff.render_inline)
+  end
+
+  def test_changed_tokens
+    diff = TreeSitter::StructuralDiff.new(OLD_SOURCE, NEW_SOURCE, language: "rust")
+
+    assert_predicate(diff, :structural?)
+    assert_predicate(diff, :changed?)
+    assert_equal(["+"], diff.deleted_tokens.map(&:text))
+    assert_equal(["-"], diff.inserted_tokens.map(&:text))
+  end
+
+  def test_rows_pair_lines
+    diff = TreeSitter::StructuralDiff.new(OLD_SOURCE, NEW_SOURCE, language: "rust")
+
+    assert_equal([:equal, :changed, :equal], diff.rows.map(&:status))
+    assert_equal([[0, 0], [1, 1], [2, 2]], diff.rows.map { |row| [row.old_line, row.new_line] })
+  end
+
+  def test_added_line_is_paired_with_nothing
+    new_source = "fn main() {\n    setup();\n    let total = a + b;\n}\n"
+
+    diff = TreeSitter::StructuralDiff.new(OLD_SOURCE, new_source, language: "rust")
+
+    assert_equal([[0, 0], [nil, 1], [1, 2], [2, 3]], diff.rows.map { |row| [row.old_line, row.new_line] })
+    assert_equal(:inserted, diff.rows[1].status)
+  end
+
+  def test_render_inline
+    output = TreeSitter::StructuralDiff.new(OLD_SOURCE, NEW_SOURCE, language: "rust").render_inline
+
+    assert_equal(<<~DIFF, output)
+      @@ -1 +1 @@
+       fn main() {
+      -    let total = a + b;
+      +    let total = a - b;
+       }
+    DIFF
+  end
+
+  def test_render_inline_highlights_only_changed_tokens
+    output = TreeSitter::StructuralDiff.new(OLD_SOURCE, NEW_SOURCE, language: "rust").render_inline(color: true)
+    inserted = TreeSitter::StructuralDiff::INSERTED_COLOR
+    reset = TreeSitter::StructuralDiff::RESET
+
+    assert_includes(output, "+    let total = a #{inserted}-#{reset} b;")
+  end
+
+  def test_render_side_by_side
+    output = TreeSitter::StructuralDiff.new(OLD_SOURCE, NEW_SOURCE, language: "rust").render_side_by_side(width: 60)
+
+    assert_includes(output, "2     let total = a + b;")
+    assert_includes(output, " | 2     let total = a - b;")
+    assert_includes(output, "1 fn main() {")
+  end
+
+  def test_line_diff_without_language
+    diff = TreeSitter::StructuralDiff.new("a\nb\nc\n", "a\nB\nc\n")
+
+    refute_predicate(diff, :structural?)
+    assert_includes(diff.render_inline, "-b\n+B\n")
+  end
+
+  def test_falls_back_to_lines_above_max_cost
+    diff = TreeSitter::StructuralDiff.new(OLD_SOURCE, "fn main() {\n    let sum = c * d;\n}\n", language: "rust", max_cost: 2)
+
+    refute_predicate(diff, :structural?)
+    assert_includes(diff.render_inline, "-    let total = a + b;\n+    let sum = c * d;\n")
+  end
+
+  def test_difftool_command
+    Dir.mktmpdir do |dir|
+      old_path = File.join(dir, "old.rs")
+      new_path = File.join(dir, "new.rs")
+      File.write(old_path, OLD_SOURCE)
+      File.write(new_path, NEW_SOURCE)
+      output = StringIO.new
+
+      status = TreeSitter::CLI.start(["difftool", "--inline", "--no-color", old_path, new_path], output: output)
+
+      assert_equal(0, status)
+      assert_includes(output.string, "new.rs (rust)")
+      assert_includes(output.string, "+    let total = a - b;")
+    end
+  end
+
+  def test_difftool_git_external_diff_arguments
+    Dir.mktmpdir do |dir|
+      old_path = File.join(dir, "tmp_old")
+      new_path = File.join(dir, "tmp_new")
+      File.write(old_path, "one\ntwo\n")
+      File.write(new_path, "one\nthree\n")
+      output = StringIO.new
+
+      args = ["difftool", "--no-color", "notes.txt", old_path, "abc123", "100644", new_path, "def456", "100644"]
+      status = TreeSitter::CLI.start(args, output: output)
+
+      assert_equal(0, status)
+      assert_includes(output.string, "notes.txt (line diff)")
+      assert_includes(output.string, "2 two")
+      assert_includes(output.string, "2 three")
+    end
+  end
+
+  def test_difftool_git_external_diff_rename_arguments
+    Dir.mktmpdir do |dir|
+      old_path = File.join(dir, "tmp_old")
+      new_path = File.join(dir, "tmp_new")
+      File.write(old_path, OLD_SOURCE)
+      File.write(new_path, NEW_SOURCE)
+      output = StringIO.new
+
+      args = [
+        "difftool", "--inline", "--no-color",
+        "src/old.txt", old_path, "abc123", "100644", new_path, "def456", "100644",
+        "src/main.rs", "similarity index 90%\nrename from src/old.txt\nrename to src/main.rs\n",
+      ]
+      status = TreeSitter::CLI.start(args, output: output)
+
+      assert_equal(0, status)
+      assert_includes(output.string, "src/old.txt => src/main.rs (rust)")
+      assert_includes(output.string, "+    let total = a - b;")
+    end
+  end
+
+  def test_difftool_line_diff_does_not_claim_formatting_only
+    Dir.mktmpdir do |dir|
+      old_path = File.join(dir, "old.txt")
+      new_path = File.join(dir, "new.txt")
+      File.write(old_path, "same\n")
+      File.write(new_path, "same\n")
+      output = StringIO.new
+
+      TreeSitter::CLI.start(["difftool", old_path, new_path], output: output)
+
+      assert_includes(output.string, "No changes")
+      refute_includes(output.string, "only formatting")
+    end
+  end
+
+  def test_difftool_new_file
+    Dir.mktmpdir do |dir|
+      new_path = File.join(dir, "new.rs")
+      File.write(new_path, "fn main() {}\n")
+      output = StringIO.new
+
+      status = TreeSitter::CLI.start(["difftool", "--inline", File::NULL, new_path], output: output)
+
+      assert_equal(0, status)
+      assert_includes(output.string, "+fn main() {}")
+    end
+  end
+end
@@ -0,0 +1,81 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestSymbols < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+  end
+
+  def test_qualified_names
+    tree = @parser.parse(<<~RUST)
+      struct Point { x: f64 }
+
+      impl<T> Point<T> {
+          fn new() -> Self { todo!() }
+      }
+
+      mod geometry {
+          fn area() -> f64 {
+              fn nested() {}
+              0.0
+          }
+      }
+    RUST
+
+    definitions = TreeSitter::Symbols.extract(tree)
+
+    assert_equal(
+      ["Point", "Point", "Point::new", "geometry", "geometry::area"],
+      definitions.map(&:qualified_name),
+    )
+    assert_equal([:struct, :impl, :method, :module, :function], definitions.map(&:kind))
+  end
+
+  def test_ruby_class_methods_in_singleton_class
+    register_language("ruby")
+    parser = TreeSitter::Parser.new
+    parser.language = "ruby"
+    tree = parser.parse(<<~RUBY)
+      class Foo
+        class << self
+          def bar; end
+
+          class Helper
+            def run; end
+          end
+        end
+
+        def self.baz; end
+
+        def bar; end
+      end
+    RUBY
+
+    assert_equal(
+      ["Foo", "Foo.bar", "Foo::Helper", "Foo::Helper#run", "Foo.baz", "Foo#bar"],
+      TreeSitter::Symbols.extract(tree).map(&:qualified_name),
+    )
+  end
+
+  def test_signature_and_body
+    tree = @parser.parse("fn add(a: i32,\n       b: i32) -> i32 { a + b }\n")
+
+    definition = TreeSitter::Symbols.find(tree, "add")
+
+    assert_equal("fn add ( a : i32 , b : i32 ) -> i32", definition.signature)
+    assert_equal(["{", "a", "+", "b", "}"], definition.body_tokens)
+    assert_equal("", definition.scope)
+  end
+
+  def test_unsupported_language
+    tree = @parser.parse("fn main() {}")
+
+    assert_raises(ArgumentError) { TreeSitter::Symbols.extract(tree, language: "cobol") }
+    refute(TreeSitter::Symbols.supported?("cobol"))
+  end
+end
@@ -0,0 +1,104 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestTextDiff < Minitest::Test
+  def test_no_edits_for_equal_text
+    assert_empty(TreeSitter::TextDiff.edits("same", "same"))
+  end
+
+  def test_single_edit_between_common_prefix_and_suffix
+    edits = TreeSitter::TextDiff.edits("let x = 1;\nlet y = 2;\n", "let x = 1;\nlet yy = 20;\n")
+
+    assert_equal(1, edits.length)
+    edit = edits.first
+
+    assert_equal(16, edit.start_byte)
+    assert_equal(20, edit.old_end_byte)
+    assert_equal(22, edit.new_end_byte)
+    assert_equal(TreeSitter::Point.new(1, 5), edit.start_point)
+    assert_equal(TreeSitter::Point.new(1, 9), edit.old_end_point)
+    assert_equal(TreeSitter::Point.new(1, 11), edit.new_end_point)
+  end
+
+  def test_insertion_and_deletion
+    insert = TreeSitter::TextDiff.edits("ab", "aXb").first
+
+    assert_equal([1, 1, 2], [insert.start_byte, insert.old_end_byte, insert.new_end_byte])
+
+    delete = TreeSitter::TextDiff.edits("aXb", "ab").first
+
+    assert_equal([1, 2, 1], [delete.start_byte, delete.old_end_byte, delete.new_end_byte])
+  end
+
+  def test_overlapping_prefix_and_suffix
+    # "aa" -> "aaa": prefix and suffix must not both claim the shared "a"s
+    edit = TreeSitter::TextDiff.edits("aa", "aaa").first
+
+    assert_equal([2, 2, 3], [edit.start_byte, edit.old_end_byte, edit.new_end_byte])
+  end
+
+  def test_myers_produces_one_edit_per_hunk
+    old_source = "a = 1\nb = 2\nc = 3\nd = 4\n"
+    new_source = "a = 10\nb = 2\nc = 3\nd = 40\n"
+
+    edits = TreeSitter::TextDiff.edits(old_source, new_source)
+
+    assert_equal(2, edits.length)
+    # Last hunk first, so earlier offsets stay valid while applying
+    assert_equal([23, 23, 24], [edits[0].start_byte, edits[0].old_end_byte, edits[0].new_end_byte])
+    assert_equal([5, 5, 6], [edits[1].start_byte, edits[1].old_end_byte, edits[1].new_end_byte])
+    assert_equal(new_source, apply(old_source, edits, new_source))
+  end
+
+  def test_prefix_suffix_algorithm_produces_single_edit
+    old_source = "a = 1\nb = 2\nc = 3\nd = 4\n"
+    new_source = "a = 10\nb = 2\nc = 3\nd = 40\n"
+
+    edits = TreeSitter::TextDiff.edits(old_source, new_source, algorithm: :prefix_suffix)
+
+    assert_equal(1, edits.length)
+    assert_equal([5, 23, 25], [edits[0].start_byte, edits[0].old_end_byte, edits[0].new_end_byte])
+  end
+
+  def test_new_end_point_of_multiline_insert
+    edit = TreeSitter::TextDiff.edits("ab", "a\nxy\nb").first
+
+    assert_equal(TreeSitter::Point.new(0, 1), edit.start_point)
+    assert_equal(TreeSitter::Point.new(2, 0), edit.new_end_point)
+  end
+
+  def test_diff_hunks
+    hunks = TreeSitter::TextDiff.diff(["a", "b", "c", "d"], ["a", "x", "c", "d", "e"])
+
+    assert_equal(2, hunks.length)
+    assert_equal([1, 1, 1, 1], hunks[0].to_a)
+    assert_equal([4, 0, 4, 1], hunks[1].to_a)
+  end
+
+  def test_diff_gives_up_past_max_cost
+    assert_nil(TreeSitter::TextDiff.diff(["a"] * 5, ["b"] * 5, max_cost: 3))
+  end
+
+  def test_point_at_uses_byte_columns
+    source = "é = 1\nx"
+
+    assert_equal(TreeSitter::Point.new(0, 2), TreeSitter::TextDiff.point_at(source, 2))
+    assert_equal(TreeSitter::Point.new(1, 1), TreeSitter::TextDiff.point_at(source, source.bytesize))
+  end
+
+  private
+
+  def apply(source, edits, new_source)
+    # Each edit's inserted text is the new source between the edit's start
+    # (shifted by the edits before it) and its new end
+    result = source.b
+    edits.each do |edit|
+      shift = edits.select { |other| other.start_byte < edit.start_byte }
+        .sum { |other| other.new_end_byte - other.old_end_byte }
+      inserted = new_source.b.byteslice(edit.start_byte + shift, edit.new_end_byte - edit.start_byte)
+      result[edit.start_byte...edit.old_end_byte] = inserted
+    end
+    result
+  end
+end
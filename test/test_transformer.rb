@@ -244,4 +244,72 @@ class TestTransformer < Minitest::Test
 
     assert_equal("b", first_fn_name)
   end
+
+  def test_move_with_reindent
+    source = <<~RUST
+      fn main() {
+          if ready {
+              let config = Config {
+                  debug: true,
+              };
+          }
+          start();
+      }
+    RUST
+    tree = @parser.parse(source)
+    cursor = TreeSitter::QueryCursor.new
+    find = ->(pattern) { cursor.matches(TreeSitter::Query.new(TreeSitter.language("rust"), pattern), tree.root_node, source).first.captures.first.node }
+    declaration = find.call("(let_declaration) @let")
+    call = find.call("(expression_statement (call_expression)) @call")
+
+    result = TreeSitter::Transformer.new(source, tree)
+      .move(declaration, before: call, reindent: true)
+      .rewrite
+
+    assert_includes(result, <<~RUST)
+          }
+          let config = Config {
+              debug: true,
+          };
+          start();
+      }
+    RUST
+  end
+
+  def test_multibyte_source
+    source = "fn main() {\n    let a = \"ä\";\n    let b = 2;\n}\n"
+    tree = @parser.parse(source)
+    first, second = tree.root_node.child(0).child_by_field_name("body").named_children
+
+    result = TreeSitter::Transformer.new(source, tree).swap(first, second).rewrite
+
+    assert_equal("fn main() {\n    let b = 2;\n    let a = \"ä\";\n}\n", result)
+  end
+
+  def test_positions_as_targets
+    source = "fn main() {\n    let a = 1;\n    let b = 2;\n}\n"
+    tree = @parser.parse(source)
+
+    moved = TreeSitter::Transformer.new(source, tree)
+      .move(TreeSitter::Lines.new(2..2), before: TreeSitter::Lines.new(1..1), separator: "")
+      .rewrite
+    swapped = TreeSitter::Transformer.new(source, tree)
+      .swap([[1, 8], [1, 9]], [[2, 8], [2, 9]])
+      .rewrite
+
+    assert_equal("fn main() {\n    let b = 2;\n    let a = 1;\n}\n", moved)
+    assert_equal("fn main() {\n    let b = 1;\n    let a = 2;\n}\n", swapped)
+  end
+
+  def test_only_within
+    source = "fn main() {\n    let a = 1;\n    let b = 2;\n}\n"
+    tree = @parser.parse(source)
+    first, second = tree.root_node.child(0).child_by_field_name("body").named_children
+
+    skipped = TreeSitter::Transformer.new(source, tree, only_within: [first.range]).swap(first, second).rewrite
+    swapped = TreeSitter::Transformer.new(source, tree, only_within: [first.range, second.range]).swap(first, second).rewrite
+
+    assert_equal(source, skipped)
+    assert_equal("fn main() {\n    let b = 2;\n    let a = 1;\n}\n", swapped)
+  end
 end
@@ -0,0 +1,136 @@
+# frozen_string_literal: true
+
+require "test_helper"
+
+class TestTree < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @parser = TreeSitter::Parser.new
+    @parser.language = "rust"
+    @tree = @parser.parse("fn main() {}")
+  end
+
+  def test_to_dot
+    assert_equal(<<~'DOT', @tree.to_dot)
+      digraph tree {
+        node [shape=box, fontname="monospace"];
+        n0 [label="source_file\n[0, 0] - [0, 12]"];
+        n1 [label="function_item\n[0, 0] - [0, 12]"];
+        n0 -> n1;
+        n2 [label="identifier\n[0, 3] - [0, 7]\n\"main\""];
+        n1 -> n2 [label="name"];
+        n3 [label="parameters\n[0, 7] - [0, 9]"];
+        n1 -> n3 [label="parameters"];
+        n4 [label="block\n[0, 10] - [0, 12]"];
+        n1 -> n4 [label="body"];
+      }
+    DOT
+  end
+
+  def test_to_dot_with_anonymous_nodes
+    dot = @tree.to_dot(anonymous: true, max_depth: 1)
+
+    assert_includes(dot, "n1 [label=\"function_item\\n[0, 0] - [0, 12]\"];")
+    refute_includes(dot, "label=\"fn")
+  end
+
+  def test_to_mermaid
+    assert_equal(<<~MERMAID, @tree.to_mermaid)
+      graph TD
+        n0["source_file [0, 0] - [0, 12]"]
+        n0 --> n1["function_item [0, 0] - [0, 12]"]
+        n1 -->|name| n2["identifier [0, 3] - [0, 7]<br/>#quot;main#quot;"]
+        n1 -->|parameters| n3["parameters [0, 7] - [0, 9]"]
+        n1 -->|body| n4["block [0, 10] - [0, 12]"]
+    MERMAID
+  end
+
+  def test_to_mermaid_marks_errors
+    tree = @parser.parse("fn main( {}")
+
+    assert_includes(tree.to_mermaid, "classDef error")
+  end
+
+  def test_to_html
+    html = @tree.to_html(title: "main.rs")
+
+    assert_includes(html, "<title>main.rs</title>")
+    assert_includes(html, '<span class="field">name:</span> <span class="kind">identifier</span>')
+    assert_includes(html, 'data-start="3" data-end="7"')
+    assert_includes(html, 'const source = "fn main() {}";')
+    assert_equal(html.scan("<details").length, html.scan("</details>").length)
+  end
+
+  def test_to_html_uses_character_offsets_and_escapes_source
+    tree = @parser.parse("// é </script>\nfn main() {}")
+    html = tree.to_html
+
+    assert_includes(html, 'data-start="18" data-end="22"')
+    assert_includes(html, "<\\/script>")
+    assert_equal(1, html.scan("</script>").length)
+  end
+
+  def test_to_html_flags_errors
+    html = @parser.parse("fn main( {}").to_html
+
+    assert_match(/class="node (error|missing|has-error)/, html)
+  end
+
+  def test_to_xml
+    xml = @tree.to_xml
+
+    assert(xml.start_with?(%(<?xml version="1.0" encoding="UTF-8"?>\n<unit language="rust">)))
+    assert_includes(xml, %(fn <identifier field="name" start_byte="3" end_byte="7" start="0:3" end="0:7">main</identifier>))
+    assert_includes(xml, %(<parameters field="parameters" start_byte="7" end_byte="9" start="0:7" end="0:9">()</parameters>))
+  end
+
+  def test_to_xml_without_positions
+    xml = @tree.to_xml(positions: false, declaration: false)
+
+    assert_equal(
+      %(<unit language="rust"><source_file><function_item>fn <identifier field="name">main</identifier>) +
+        %(<parameters field="parameters">()</parameters> <block field="body">{}</block></function_item>) +
+        %(</source_file></unit>\n),
+      xml,
+    )
+  end
+
+  def test_xml_round_trip
+    source = fixture_content("sample.rs")
+    tree = @parser.parse(source)
+
+    assert_equal(source, TreeSitter::Tree.source_from_xml(tree.to_xml))
+  end
+
+  def test_xml_round_trip_preserves_escapes_and_carriage_returns
+    source = "fn main() {\r\n    let s = \"<a & b>\";\r\n}\r\n"
+    xml = @parser.parse(source).to_xml
+
+    assert_includes(xml, "&#13;")
+    assert_includes(xml, "&lt;a &amp; b&gt;")
+    assert_equal(source, TreeSitter::Tree.source_from_xml(xml))
+  end
+
+  def test_xml_round_trip_escapes_characters_xml_does_not_allow
+    source = "fn main() {\n    let s = \"\u0001\f\";\n}\n"
+    xml = @parser.parse(source).to_xml
+
+    assert_includes(xml, %(<escape char="0x01"/><escape char="0x0c"/>))
+    assert_equal(source, TreeSitter::Tree.source_from_xml(xml))
+  end
+
+  def test_source_from_xml_parses_the_document
+    assert_equal("", TreeSitter::Tree.source_from_xml(%(<unit language="rust"/>)))
+    assert_equal("a > b", TreeSitter::Tree.source_from_xml(%(<unit note="x > y"><e a=">">a &gt; b</e></unit>)))
+    assert_raises(ArgumentError) { TreeSitter::Tree.source_from_xml("<unit>") }
+  end
+
+  def test_edits_to_xml_carry_back_to_source
+    xml = @tree.to_xml.sub(">main</identifier>", ">start</identifier>")
+
+    assert_equal("fn start() {}", TreeSitter::Tree.source_from_xml(xml))
+    assert_raises(ArgumentError) { TreeSitter::Tree.source_from_xml("<root/>") }
+  end
+end
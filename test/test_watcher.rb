@@ -0,0 +1,153 @@
+# frozen_string_literal: true
+
+require "test_helper"
+require "tmpdir"
+
+class TestWatcher < Minitest::Test
+  include TestHelper
+
+  def setup
+    register_language("rust")
+    @dir = Dir.mktmpdir
+    @path = File.join(@dir, "main.rs")
+    File.write(@path, "fn main() {}\n")
+    File.write(File.join(@dir, "README.txt"), "not code\n")
+    @watcher = TreeSitter::Watcher.new([@dir], backend: :polling)
+  end
+
+  def teardown
+    FileUtils.remove_entry(@dir)
+  end
+
+  def test_first_poll_parses_known_files
+    changes = @watcher.poll
+
+    assert_equal([@path], changes.map(&:first))
+    path, tree, ranges = changes.first
+
+    assert_equal(@path, path)
+    assert_equal("source_file", tree.root_node.kind)
+    assert_equal([0], ranges.map(&:start_byte))
+    assert_equal(@watcher.tree_for(@path), tree)
+  end
+
+  def test_unchanged_files_are_not_reported
+    @watcher.poll
+
+    assert_empty(@watcher.poll)
+  end
+
+  def test_change_is_reparsed_incrementally
+    @watcher.poll
+    File.write(@path, "fn main() { start(); }\nfn start() {}\n")
+
+    yielded = []
+    @watcher.poll { |*change| yielded << change }
+
+    assert_equal(1, yielded.length)
+    _path, tree, ranges = yielded.first
+
+    assert_equal("fn main() { start(); }\nfn start() {}\n", tree.source)
+    assert_equal(2, tree.root_node.named_child_count)
+    refute_empty(ranges)
+    assert_operator(ranges.first.start_byte, :>=, 10)
+  end
+
+  def test_deleted_file
+    @watcher.poll
+    File.delete(@path)
+
+    changes = @watcher.poll
+
+    assert_equal([[@path, nil, []]], changes)
+    assert_nil(@watcher.tree_for(@path))
+  end
+
+  def test_files_that_disappear_are_treated_as_deleted
+    temp = File.join(@dir, "main.tmp.rs")
+    File.write(temp, "fn main() {}\n")
+    # Deletes the temporary file after it was listed, before it is read
+    detector = lambda do |path|
+      File.delete(temp) if path == temp
+      "rust" if path.end_with?(".rs")
+    end
+    watcher = TreeSitter::Watcher.new(@dir, detector: detector, backend: :polling)
+
+    assert_equal([@path], watcher.poll.map(&:first))
+    assert_nil(watcher.refresh(temp))
+    assert_nil(watcher.send(:language_for, temp))
+  end
+
+  def test_new_file_in_directory
+    @watcher.poll
+    other = File.join(@dir, "lib.rs")
+    File.write(other, "struct A;\n")
+
+    changes = @watcher.poll
+
+    assert_equal([other], changes.map(&:first))
+  end
+
+  def test_custom_detector
+    watcher = TreeSitter::Watcher.new(@dir, detector: ->(path) { "rust" if path.end_with?(".txt") }, backend: :polling)
+
+    assert_equal([File.join(@dir, "README.txt")], watcher.poll.map(&:first))
+  end
+
+  def test_failed_reparse_is_retried
+    @watcher.poll
+    parser = @watcher.send(:parser_for, "rust")
+    parser.define_singleton_method(:reparse) { |*| nil }
+    File.write(@path, "fn main() { start(); }\n")
+
+    assert_empty(@watcher.poll)
+
+    parser.singleton_class.send(:remove_method, :reparse)
+
+    assert_equal([@path], @watcher.poll.map(&:first))
+  end
+
+  def test_detector_is_asked_once_per_file_version
+    calls = Hash.new(0)
+    detector = lambda do |path|
+      calls[File.basename(path)] += 1
+      "rust" if path.end_with?(".rs")
+    end
+    watcher = TreeSitter::Watcher.new(@dir, detector: detector, backend: :polling)
+    3.times { watcher.poll }
+
+    assert_equal({ "main.rs" => 1, "README.txt" => 1 }, calls)
+  end
+
+  def test_inotify_backend
+    watcher = TreeSitter::Watcher.new([@dir], interval: 0.01)
+    skip("rb-inotify is not available") unless watcher.backend == :inotify
+
+    seen = Queue.new
+    thread = Thread.new { watcher.run { |path, _tree, _ranges| seen << path } }
+
+    assert_equal(@path, seen.pop(timeout: 5))
+    sleep(0.2) # until the notifier watches the directory
+    other = File.join(@dir, "lib.rs")
+    File.write(other, "struct A;\n")
+
+    assert_equal(other, seen.pop(timeout: 5))
+  ensure
+    watcher&.stop
+    thread&.join(5)
+  end
+
+  def test_run_until_stopped
+    seen = []
+    thread = Thread.new do
+      watcher = TreeSitter::Watcher.new([@path], backend: :polling, interval: 0.01)
+      watcher.run do |path, _tree, _ranges|
+        seen << path
+        watcher.stop
+      end
+    end
+
+    assert(thread.join(5), "watcher did not stop")
+    assert_equal([@path], seen)
+  end
+end